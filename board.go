@@ -3,13 +3,13 @@
 package main
 
 import (
-	"flag"
-	"log"
 	"chess-engine/handlers"
+	"context"
+	"flag"
 	"fmt"
 	"image/color"
+	"log"
 	"path/filepath"
-	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -20,56 +20,43 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-const boardSize=8
-const pieceDir="chess-gui/peices"
+const boardSize = 8
+const pieceDir = "chess-gui/peices"
+
 var verbose *bool
 
-const startFenNotation="rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+const startFenNotation = handlers.StartFEN
 
-var mpPieceToImage=map[rune]string{
-	'P':"whitePawn.svg",'N':"whiteKnight.svg", 'B': "whiteBishop.svg", 'R': "whiteRook.svg",
-	'Q':"whiteQueen.svg",'K':"whiteKing.svg",
-	'p':"blackPawn.svg",'n':"blackKnight.svg", 'b': "blackBishop.svg", 'r': "blackRook.svg",
-	'q':"blackQueen.svg",'k':"blackKing.svg",
+var mpPieceToImage = map[rune]string{
+	'P': "whitePawn.svg", 'N': "whiteKnight.svg", 'B': "whiteBishop.svg", 'R': "whiteRook.svg",
+	'Q': "whiteQueen.svg", 'K': "whiteKing.svg",
+	'p': "blackPawn.svg", 'n': "blackKnight.svg", 'b': "blackBishop.svg", 'r': "blackRook.svg",
+	'q': "blackQueen.svg", 'k': "blackKing.svg",
 }
 
 var selectedRow, selectedCol int
 var pieceSelected bool
-var parsedBoard [8][8]rune
-var whiteTurn = true
+
+// gamePos is the game in progress; the engine always plays Black.
+var gamePos handlers.Position
+
+// gameHistory holds the hashes of the positions before gamePos, for repetitions.
+var gameHistory []uint64
+
+// searcher finds the engine's moves and keeps its transposition table between them.
+var searcher = handlers.NewSearcher()
 
 var boardContainer *fyne.Container
 var boardCells [8][8]*fyne.Container
+
 //var blackScore = 1290
 //var whiteScore = 1290
 
-type KingPosition struct {
-	Row     int
-	Col     int
-	IsCheck bool
-}
+func handlePieceClick(row, col int) {
+	clickedPiece := gamePos.Board[row][col]
 
-var whiteKing = KingPosition{Row: 7, Col: 4, IsCheck: false}
-var blackKing = KingPosition{Row: 0, Col: 4, IsCheck: false}
-
-// func getBlackPieces() [][2]int {
-// 	var positions [][2]int
-// 	for i := 0; i < 8; i++ {
-// 		for j := 0; j < 8; j++ {
-// 			piece := parsedBoard[i][j]
-// 			if piece >= 'a' && piece <= 'z' {
-// 				positions = append(positions, [2]int{i, j})
-// 			}
-// 		}
-// 	}
-// 	return positions
-// }
-
-func handlePieceClick(row, col int){
-	clickedPiece:=parsedBoard[row][col]
-
-	if !pieceSelected{
-		if clickedPiece != 0 && (whiteTurn == isWhite(clickedPiece)) {
+	if !pieceSelected {
+		if clickedPiece != 0 && (gamePos.WhiteToMove == isWhite(clickedPiece)) {
 			selectedRow, selectedCol = row, col
 			pieceSelected = true
 			fmt.Printf("Selected piece at: %d, %d\n", row, col)
@@ -77,7 +64,7 @@ func handlePieceClick(row, col int){
 	} else {
 
 		if clickedPiece != 0 &&
-			(whiteTurn == isWhite(clickedPiece)) &&
+			(gamePos.WhiteToMove == isWhite(clickedPiece)) &&
 			(row != selectedRow || col != selectedCol) {
 			selectedRow, selectedCol = row, col
 			fmt.Printf("Reselected piece at: %d, %d\n", row, col)
@@ -89,377 +76,157 @@ func handlePieceClick(row, col int){
 			fmt.Println("Piece deselected")
 			return
 		}
-		movePiece(selectedRow, selectedCol, row, col)
+		movePiece(selectedRow, selectedCol, row, col, 0)
 	}
 }
 
-func parseFEN(fen string) [8][8]rune {
-	var board [8][8]rune
-	rows := strings.Split(fen, "/")
-	for rowIdx, row := range rows {
-		colIdx := 0
-		for _, char := range row {
-			if char >= '1' && char <= '8' {
-				colIdx += int(char - '0')
-			} else {
-				board[rowIdx][colIdx] = char
-				colIdx++
-			}
-		}
-	}
-	return board
-}
-
-func isPathClear(fromRow, fromCol, toRow, toCol int) bool {
-	rowStep, colStep := 0, 0
-	piece := parsedBoard[fromRow][fromCol]
-	if piece == 'N' || piece == 'n' {
-		return true
-	}
-	if fromRow < toRow {
-		rowStep = 1
-	} else if fromRow > toRow {
-		rowStep = -1
-	}
-
-	if fromCol < toCol {
-		colStep = 1
-	} else if fromCol > toCol {
-		colStep = -1
-	}
-
-	r, c := fromRow+rowStep, fromCol+colStep
-	for r != toRow || c != toCol {
-		if parsedBoard[r][c] != 0 {
-			return false 
-		}
-		r += rowStep
-		c += colStep
-	}
-
-	return true
-}
-
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
-func movePiece(fromRow, fromCol, toRow, toCol int) {
-	if fromRow==toRow && fromCol==toCol {
+// movePiece plays a move for the side to move. promotion is the piece a pawn
+// reaching the last rank becomes ('Q', 'R', 'B' or 'N'); 0 means a queen.
+func movePiece(fromRow, fromCol, toRow, toCol int, promotion rune) {
+	if fromRow == toRow && fromCol == toCol {
 		pieceSelected = false
 		return
 	}
 
-	piece := parsedBoard[fromRow][fromCol]
-	isWhitePiece := piece >= 'A' && piece <= 'Z'
-	targetPiece := parsedBoard[toRow][toCol]
-	isTargetWhitePiece := targetPiece >= 'A' && targetPiece <= 'Z'
-
-	if (whiteTurn && !isWhitePiece) || (!whiteTurn && isWhitePiece) {
+	piece := gamePos.Board[fromRow][fromCol]
+	if piece == 0 || isWhite(piece) != gamePos.WhiteToMove {
 		fmt.Println("Not your turn!")
 		return
 	}
 
-	if (piece == 'K' || piece == 'k') && abs(fromCol-toCol) == 2 {
-		if handlers.IsCastleable(parsedBoard, fromRow, fromCol, toRow, toCol) {
-			performCastling(fromRow, fromCol, toRow, toCol, piece)
-			//parsedBoard[toRow][toCol]=parsedBoard[fromRow][fromCol]
-			//parsedBoard[fromRow][fromCol]=0
-
-			if !whiteTurn{
-				bestMove:=handlers.FindBestMove(parsedBoard,whiteTurn)
-				movePiece(bestMove.FromRow,bestMove.FromCol,bestMove.ToRow,bestMove.ToCol)
-			}
-			return
-		}else{
-			fmt.Println("Not Possible to castle")
-			pieceSelected = false
-			return
-		}
+	var promotionPiece *rune
+	if promotion != 0 {
+		promotionPiece = &promotion
 	}
-	if targetPiece != 0 && ((whiteTurn && isTargetWhitePiece) || (!whiteTurn && !isTargetWhitePiece)) {
-		fmt.Println("Can't capture own piece")
+	if !handlers.IsValidMove(&gamePos, fromRow, fromCol, toRow, toCol, promotionPiece) {
+		fmt.Println("Invalid move for piece:", string(piece))
 		return
 	}
 
-	// if targetPiece != 0 {
-	// 	if isWhitePiece {
-	// 		blackScore -= handlers.GetValue(targetPiece)
-	// 	} else {
-	// 		whiteScore -= handlers.GetValue(targetPiece)
-	// 	}
-	// }
-	if !isPathClear(fromRow, fromCol, toRow, toCol) {
-		fmt.Println("Path is blocked for piece:", string(piece))
-		return
-	}
-	var promotionPiece rune
-	if whiteTurn && toRow == 0 && piece == 'P' {
-		promotionPiece = 'Q'
-	} else if !whiteTurn && toRow == 7 && piece == 'p' {
-		promotionPiece = 'q'
-	}
-	if !handlers.IsValidMove(parsedBoard, piece, fromRow, fromCol, toRow, toCol, &promotionPiece) {
-		fmt.Println("Invalid move for piece:", string(piece))
-		return
+	if gamePos.WhiteToMove {
+		fmt.Println("White move")
+	} else {
+		fmt.Println("Black move")
 	}
-	tempBoard := [8][8]rune{}
-	for i:=0;i<8;i++{
+	gameHistory = append(gameHistory, handlers.GetZobristValue(&gamePos))
+	gamePos.MakeMove(handlers.Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol, Promotion: promotion})
+	pieceSelected = false
+
+	// Castling, en passant and promotion touch more than the two clicked squares.
+	refreshBoardUI()
+
+	for i := 0; i < 8; i++ {
 		for j := 0; j < 8; j++ {
-			tempBoard[i][j] = parsedBoard[i][j]
-		}
-	}
-	tempBoard[toRow][toCol] = piece
-	tempBoard[fromRow][fromCol] = 0
-	kingToCheck := &whiteKing
-	opponentKing := &blackKing
-	if !isWhitePiece {
-		kingToCheck = &blackKing
-		opponentKing = &whiteKing
-	}
-	// if piece == 'K' {
-	// 	whiteKing.Row, whiteKing.Col = toRow, toCol
-	// } else if piece == 'k' {
-	// 	blackKing.Row, blackKing.Col = toRow, toCol
-	// }
-
-	switch piece{
-	case 'K':
-		whiteKing.Row,whiteKing.Col=toRow,toCol
-	case 'k':
-		blackKing.Row, blackKing.Col = toRow, toCol
-	}
-	
-	if whiteKing.IsCheck && whiteTurn {
-		if !handlers.IsSquareUnderAttack(parsedBoard, whiteKing.Row, whiteKing.Col, true) {
-			whiteKing.IsCheck = false
-		}
-	} else if blackKing.IsCheck && !whiteTurn {
-		if !handlers.IsSquareUnderAttack(parsedBoard, blackKing.Row, blackKing.Col, false) {
-			blackKing.IsCheck = false
+			piece := gamePos.Board[i][j]
+			if piece == 0 {
+				fmt.Print(". ")
+			} else {
+				fmt.Printf("%c ", piece)
+			}
 		}
+		fmt.Println()
 	}
-	if handlers.IsSquareUnderAttack(tempBoard, kingToCheck.Row, kingToCheck.Col, isWhitePiece) {
-		fmt.Println("Move would leave your king in check!")
+	fmt.Println(gamePos.FEN())
+
+	if reportCheck() || reportDraw() {
 		return
 	}
-	if promotionPiece != 0 {
-		piece = promotionPiece
-	}
-	parsedBoard[toRow][toCol] = piece
-	parsedBoard[fromRow][fromCol] = 0
-
-	// if piece == 'K' {
-	// 	whiteKing.Row, whiteKing.Col = toRow, toCol
-	// } else if piece == 'k' {
-	// 	blackKing.Row, blackKing.Col = toRow, toCol
-	// }
-
-	switch piece{
-	case 'K':
-		whiteKing.Row,whiteKing.Col=toRow,toCol
-	case 'k':
-		blackKing.Row,blackKing.Col=toRow,toCol
+
+	if !gamePos.WhiteToMove {
+		searcher.SetHistory(gameHistory)
+		bestMove := searcher.FindBestMove(context.Background(), &gamePos)
+		movePiece(bestMove.FromRow, bestMove.FromCol, bestMove.ToRow, bestMove.ToCol, bestMove.Promotion)
 	}
+}
 
-	if handlers.IsSquareUnderAttack(parsedBoard, opponentKing.Row, opponentKing.Col, !isWhitePiece) {
-		if isWhitePiece {
-			fmt.Println("Black KING is under check")
-			blackKing.IsCheck = true
-			if isCheckmate(false) {
-				fmt.Println("Checkmate! White wins!")
-			}
+// reportCheck prints check, checkmate and stalemate messages for the side to
+// move and reports whether the game is over.
+func reportCheck() bool {
+	side := "White"
+	if !gamePos.WhiteToMove {
+		side = "Black"
+	}
+	switch gamePos.Status() {
+	case handlers.Checkmate:
+		if gamePos.WhiteToMove {
+			fmt.Println("Checkmate! Black wins!")
 		} else {
-			fmt.Println("White KING is under check")
-			whiteKing.IsCheck = true
-			if isCheckmate(true) {
-				fmt.Println("Checkmate! Black wins!")
-			}
-		}
-	} else {
-		if !isWhitePiece {
-			blackKing.IsCheck = false
-		} else {
-			whiteKing.IsCheck = false
+			fmt.Println("Checkmate! White wins!")
 		}
+		return true
+	case handlers.Stalemate:
+		fmt.Println("Stalemate!", side, "has no legal move. Draw!")
+		return true
 	}
-	if whiteTurn{
-		fmt.Println("White move")
-	}else{
-		fmt.Println("Black move")
-	}
-	whiteTurn=!whiteTurn
-	pieceSelected=false
-	
-	updateBoardUI(fromRow, fromCol, toRow, toCol)
-	
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 8; j++ {
-				piece := parsedBoard[i][j]
-				if piece == 0 {
-					fmt.Print(". ") 
-				} else {
-					fmt.Printf("%c ", piece)
-				}
-			}
-			fmt.Println() 
-		}
-	if !whiteTurn{
-		bestMove:=handlers.FindBestMove(parsedBoard,whiteTurn)
-		movePiece(bestMove.FromRow,bestMove.FromCol,bestMove.ToRow,bestMove.ToCol)
+	kingRow, kingCol := findKing(gamePos.WhiteToMove)
+	if handlers.IsInCheck(gamePos.Board, gamePos.WhiteToMove, kingRow, kingCol) {
+		fmt.Println(side, "KING is under check")
 	}
+	return false
 }
 
-func isCheckmate(isWhiteKing bool) bool {
-	kingPos := &blackKing
-	if isWhiteKing {
-		kingPos = &whiteKing
-	}
-
-	fmt.Printf("\n=== CHECKMATE ANALYSIS ===\n")
-	fmt.Printf("Analyzing position for %s king at (%d,%d)\n",
-		map[bool]string{true: "White", false: "Black"}[isWhiteKing],
-		kingPos.Row, kingPos.Col)
-
-	if !kingPos.IsCheck {
-		fmt.Println("King is not in check - cannot be checkmate")
+// reportDraw prints the reason when the game is drawn and reports whether it is.
+func reportDraw() bool {
+	reason := handlers.CheckDraw(&gamePos, gameHistory)
+	if reason == handlers.NotDrawn {
 		return false
 	}
+	fmt.Println("Draw by", reason.String()+"!")
+	return true
+}
 
-	fmt.Println("\nChecking all possible king moves:")
-	kingMoves := [][2]int{
-		{-1, -1}, {-1, 0}, {-1, 1},
-		{0, -1}, {0, 1},
-		{1, -1}, {1, 0}, {1, 1},
-	}
-
-	kingPiece := 'k'
+func findKing(isWhiteKing bool) (int, int) {
+	king := 'k'
 	if isWhiteKing {
-		kingPiece = 'K'
+		king = 'K'
 	}
-
-	for _, move := range kingMoves {
-		newRow := kingPos.Row + move[0]
-		newCol := kingPos.Col + move[1]
-
-		if newRow >= 0 && newRow < 8 && newCol >= 0 && newCol < 8 {
-			tempBoard := [8][8]rune{}
-			for i := 0; i < 8; i++ {
-				for j := 0; j < 8; j++ {
-					tempBoard[i][j] = parsedBoard[i][j]
-				}
-			}
-
-			targetPiece := tempBoard[newRow][newCol]
-			isTargetWhitePiece := targetPiece >= 'A' && targetPiece <= 'Z'
-
-			fmt.Printf("Testing king move to (%d,%d): ", newRow, newCol)
-
-			if targetPiece == 0 || (isWhiteKing != isTargetWhitePiece) {
-				tempBoard[newRow][newCol] = kingPiece
-				tempBoard[kingPos.Row][kingPos.Col] = 0
-				if !handlers.IsSquareUnderAttack(tempBoard, newRow, newCol, isWhiteKing) {
-					fmt.Println("LEGAL MOVE FOUND - not checkmate")
-					return false
-				}
-				fmt.Println("square is under attack")
-			} else {
-				fmt.Println("square occupied by friendly piece")
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			if gamePos.Board[i][j] == king {
+				return i, j
 			}
 		}
 	}
-
-	fmt.Println("\nCHECKMATE CONFIRMED!")
-	return true
+	return -1, -1
 }
 
-func performCastling(fromRow, fromCol, toRow, toCol int, piece rune) {
-	isKingSide := toCol > fromCol
-	rookFromCol := 0
-	rookToCol := 3
-	if isKingSide {
-		rookFromCol = 7
-		rookToCol = 5
-	}
-
-	parsedBoard[toRow][toCol] = piece
-	fmt.Printf("%c",piece)
-	fmt.Println("")
-	parsedBoard[fromRow][fromCol] = 0
-
-	var rook rune
-	var kingToUpdate *KingPosition
-
-	if piece=='k'{
-		rook='r'
-		kingToUpdate=&blackKing
-	}else{
-		rook='R'
-		kingToUpdate=&whiteKing
+func refreshBoardUI() {
+	for row := 0; row < boardSize; row++ {
+		for col := 0; col < boardSize; col++ {
+			updateCellUI(row, col)
+		}
 	}
-	parsedBoard[toRow][rookToCol] = rook
-	parsedBoard[toRow][rookFromCol] = 0
-	//fmt.Println(kingToUpdate.Row," row and col ",kingToUpdate.Col)
-
-	updateBoardUI(fromRow, fromCol, toRow, toCol)
-	kingToUpdate.Row=toRow
-	kingToUpdate.Col=toCol
-	//fmt.Println(kingToUpdate.Row," row and col ",kingToUpdate.Col)
-
-	updateBoardUI(toRow, rookFromCol, toRow, rookToCol)
-
-	whiteTurn = !whiteTurn
-	pieceSelected = false
 }
 
-func updateBoardUI(fromRow, fromCol, toRow, toCol int) {
-
-	fromCell := boardCells[fromRow][fromCol]
-	toCell := boardCells[toRow][toCol]
+func updateCellUI(row, col int) {
+	cell := boardCells[row][col]
 
-	fromSquareColor := color.White
-	if (fromRow+fromCol)%2 == 1 {
-		fromSquareColor = color.Black
+	squareColor := color.White
+	if (row+col)%2 == 1 {
+		squareColor = color.Black
 	}
-	toSquareColor := color.White
-	if (toRow+toCol)%2 == 1 {
-		toSquareColor = color.Black
-	}
-
-	fromSquare := canvas.NewRectangle(fromSquareColor)
-	toSquare := canvas.NewRectangle(toSquareColor)
-	fromSquare.SetMinSize(fyne.NewSize(75, 75))
-	toSquare.SetMinSize(fyne.NewSize(75, 75))
+	square := canvas.NewRectangle(squareColor)
+	square.SetMinSize(fyne.NewSize(75, 75))
 
-	fromButton := widget.NewButton(" ", func() {
-		handlePieceClick(fromRow, fromCol)
-	})
-	toButton := widget.NewButton(" ", func() {
-		handlePieceClick(toRow, toCol)
+	button := widget.NewButton(" ", func() {
+		handlePieceClick(row, col)
 	})
-	fromButton.Importance = widget.LowImportance
-	toButton.Importance = widget.LowImportance
-	fromButton.Resize(fyne.NewSize(75, 75))
-	toButton.Resize(fyne.NewSize(75, 75))
+	button.Importance = widget.LowImportance
+	button.Resize(fyne.NewSize(75, 75))
 
-	fromCell.Objects = []fyne.CanvasObject{fromSquare, fromButton}
-
-	toCell.Objects = []fyne.CanvasObject{toSquare}
-	if piece := parsedBoard[toRow][toCol]; piece != 0 {
+	cell.Objects = []fyne.CanvasObject{square}
+	if piece := gamePos.Board[row][col]; piece != 0 {
 		imagePath := filepath.Join(pieceDir, mpPieceToImage[piece])
 		pieceImage := canvas.NewImageFromFile(imagePath)
 		pieceImage.FillMode = canvas.ImageFillContain
 		pieceImage.Resize(fyne.NewSize(75, 75))
-		toCell.Objects = append(toCell.Objects, pieceImage)
+		cell.Objects = append(cell.Objects, pieceImage)
 	}
-	toCell.Objects = append(toCell.Objects, toButton)
+	cell.Objects = append(cell.Objects, button)
 
-	fromCell.Refresh()
-	toCell.Refresh()
+	cell.Refresh()
 }
+
 func isWhite(piece rune) bool {
 	return piece == 'P' || piece == 'N' || piece == 'B' || piece == 'R' || piece == 'Q' || piece == 'K'
 }
@@ -469,33 +236,9 @@ func generateChessBoard() *fyne.Container {
 
 	for row := 0; row < boardSize; row++ {
 		for col := 0; col < boardSize; col++ {
-			squareColor := color.White
-			if (row+col)%2 == 1 {
-				squareColor = color.Black
-			}
-			square := canvas.NewRectangle(squareColor)
-			square.SetMinSize(fyne.NewSize(75, 75))
-
-			cell := container.NewStack(square)
-
-			rowCopy, colCopy := row, col
-			tapButton := widget.NewButton(" ", func() {
-				handlePieceClick(rowCopy, colCopy)
-			})
-			tapButton.Importance = widget.LowImportance
-			tapButton.Resize(fyne.NewSize(75, 75))
-
-			if piece := parsedBoard[row][col]; piece != 0 {
-				imagePath := filepath.Join(pieceDir, mpPieceToImage[piece])
-				pieceImage := canvas.NewImageFromFile(imagePath)
-				pieceImage.FillMode = canvas.ImageFillContain
-				pieceImage.Resize(fyne.NewSize(75, 75))
-				cell = container.NewStack(square, pieceImage, tapButton)
-			} else {
-				cell = container.NewStack(square, tapButton)
-			}
-
+			cell := container.NewStack()
 			boardCells[row][col] = cell
+			updateCellUI(row, col)
 			board.Add(cell)
 		}
 	}
@@ -504,15 +247,14 @@ func generateChessBoard() *fyne.Container {
 }
 
 func main() {
-	verbose=flag.Bool("verbose",false,"enable verbose output")
+	verbose = flag.Bool("verbose", false, "enable verbose output")
 	flag.Parse()
 	log.SetFlags(0)
-	handlers.InitZobrist()
-	if *verbose{
+	if *verbose {
 		log.Println("Verbose mode enabled.")
 	}
 	fmt.Println("This is a regular output")
-	if *verbose{
+	if *verbose {
 		log.Println("This is verbose message ")
 	}
 	chessApp := app.New()
@@ -521,7 +263,11 @@ func main() {
 	window := chessApp.NewWindow("Chess Game")
 	window.Resize(fyne.NewSize(600, 600))
 
-	parsedBoard = parseFEN(strings.Split(startFenNotation, " ")[0])
+	var err error
+	gamePos, err = handlers.ParseFEN(startFenNotation)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	boardContainer = container.NewVBox(
 		widget.NewLabel("Chess Game"),
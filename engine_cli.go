@@ -1,62 +1,20 @@
-//go:build !js
+//go:build !js && !gui
 
 package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"chess-engine/handlers"
 )
 
-// parseFEN converts a simple piece-placement FEN (first field) into a board.
-func parseFEN(fen string) [8][8]rune {
-	var board [8][8]rune
-	rows := strings.Split(fen, "/")
-	for rowIdx, row := range rows {
-		colIdx := 0
-		for _, char := range row {
-			if char >= '1' && char <= '8' {
-				colIdx += int(char - '0')
-			} else {
-				board[rowIdx][colIdx] = char
-				colIdx++
-			}
-		}
-	}
-	return board
-}
-
-// boardToFEN converts a board back to the first FEN field (piece placement only).
-func boardToFEN(board [8][8]rune) string {
-	var sb strings.Builder
-	for row := 0; row < 8; row++ {
-		empty := 0
-		for col := 0; col < 8; col++ {
-			p := board[row][col]
-			if p == 0 {
-				empty++
-			} else {
-				if empty > 0 {
-					sb.WriteString(fmt.Sprintf("%d", empty))
-					empty = 0
-				}
-				sb.WriteRune(p)
-			}
-		}
-		if empty > 0 {
-			sb.WriteString(fmt.Sprintf("%d", empty))
-		}
-		if row < 7 {
-			sb.WriteString("/")
-		}
-	}
-	return sb.String()
-}
-
 // printBoard draws the board in the terminal.
 func printBoard(board [8][8]rune) {
 	fmt.Println()
@@ -92,102 +50,235 @@ func squareToCoords(s string) (row, col int, ok bool) {
 	return row, col, true
 }
 
-// coords -> "e2"
-func coordsToSquare(row, col int) string {
-	file := 'a' + rune(col)
-	rank := '8' - rune(row)
-	return fmt.Sprintf("%c%c", file, rank)
+func isWhite(piece rune) bool {
+	return piece == 'P' || piece == 'N' || piece == 'B' || piece == 'R' || piece == 'Q' || piece == 'K'
 }
 
-// simple abs helper.
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// runPerft handles "perft <depth> [FEN]" and "divide <depth> [FEN]". The FEN may
+// be given as separate arguments; it defaults to the start position.
+func runPerft(command string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: %s <depth> [FEN]", command)
 	}
-	return x
-}
-
-// applyMove copies the board and applies a move (with basic castling/en-passant/promotion).
-func applyMove(board [8][8]rune, move handlers.Move) [8][8]rune {
-	var newBoard [8][8]rune
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			newBoard[i][j] = board[i][j]
-		}
+	depth, err := strconv.Atoi(args[0])
+	if err != nil || depth < 1 {
+		return fmt.Errorf("invalid depth %q", args[0])
 	}
-
-	piece := board[move.FromRow][move.FromCol]
-	newBoard[move.FromRow][move.FromCol] = 0
-
-	// Castling rook move
-	if piece == 'K' || piece == 'k' {
-		if abs(move.ToCol-move.FromCol) == 2 {
-			if move.ToCol > move.FromCol {
-				newBoard[move.FromRow][5] = newBoard[move.FromRow][7]
-				newBoard[move.FromRow][7] = ' '
-			} else {
-				newBoard[move.FromRow][3] = newBoard[move.FromRow][0]
-				newBoard[move.FromRow][0] = ' '
-			}
-		}
+	fen := handlers.StartFEN
+	if len(args) > 1 {
+		fen = strings.Join(args[1:], " ")
 	}
-	// En-passant capture
-	if (piece == 'P' || piece == 'p') && move.ToCol != move.FromCol {
-		if board[move.ToRow][move.ToCol] == 0 {
-			newBoard[move.FromRow][move.ToCol] = 0
-		}
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return err
 	}
 
-	newBoard[move.ToRow][move.ToCol] = piece
-	// Promotion
-	if piece == 'P' && move.ToRow == 0 {
-		newBoard[move.ToRow][move.ToCol] = 'Q'
-	} else if piece == 'p' && move.ToRow == 7 {
-		newBoard[move.ToRow][move.ToCol] = 'q'
+	start := time.Now()
+	nodes := 0
+	if command == "divide" {
+		for _, mc := range handlers.Divide(&pos, depth) {
+			fmt.Printf("%s: %d\n", mc.Move, mc.Nodes)
+			nodes += mc.Nodes
+		}
+		fmt.Println()
+	} else {
+		nodes = handlers.Perft(&pos, depth)
 	}
+	elapsed := time.Since(start)
 
-	return newBoard
+	fmt.Printf("Nodes: %d\n", nodes)
+	fmt.Printf("Time:  %v (%.0f nps)\n", elapsed, float64(nodes)/elapsed.Seconds())
+	return nil
 }
 
-func isWhite(piece rune) bool {
-	return piece == 'P' || piece == 'N' || piece == 'B' || piece == 'R' || piece == 'Q' || piece == 'K'
+// newCLIGame starts the record of a terminal game, which the "save" command writes as PGN.
+func newCLIGame(start *handlers.Position) *handlers.Game {
+	game := handlers.NewGame(start)
+	game.SetTag("Event", "Terminal game")
+	game.SetTag("Date", time.Now().Format("2006.01.02"))
+	game.SetTag("White", "Player")
+	game.SetTag("Black", "chess-engine")
+	return game
+}
+
+// saveGame writes game to path as PGN.
+func saveGame(game *handlers.Game, path string) error {
+	return os.WriteFile(path, []byte(game.PGN()), 0o644)
+}
+
+// loadGame reads the first game of the PGN file at path.
+func loadGame(path string) (*handlers.Game, handlers.Position, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, handlers.Position{}, err
+	}
+	games, err := handlers.ParsePGN(string(data))
+	if err != nil {
+		return nil, handlers.Position{}, err
+	}
+	pos, err := games[0].Position()
+	return games[0], pos, err
 }
 
 func main() {
-	handlers.InitZobrist()
-	reader := bufio.NewReader(os.Stdin)
+	threads := flag.Int("threads", 1, "number of search threads")
+	hashMB := flag.Int("hash", handlers.DefaultHashMB, "transposition table size in MB")
+	bookPath := flag.String("book", "", "Polyglot opening book (.bin) to play from")
+	syzygyPath := flag.String("syzygy-path", "", "directories holding Syzygy tablebases, separated like $PATH")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) > 0 && (args[0] == "perft" || args[0] == "divide") {
+		if err := runPerft(args[0], args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	startFen := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR"
+	searcher := handlers.NewSearcher()
+	searcher.SetHashSize(*hashMB)
+	searcher.SetThreads(*threads)
+	if *bookPath != "" {
+		book, err := handlers.LoadBook(*bookPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		searcher.SetBook(book)
+	}
+	if *syzygyPath != "" {
+		tb, err := handlers.OpenTablebase(*syzygyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		searcher.SetTablebase(tb)
+	}
+	if len(args) > 0 && args[0] == "uci" {
+		runUCI(os.Stdin, os.Stdout, searcher, false)
+		return
+	}
+	if len(args) > 0 && args[0] == "xboard" {
+		runXBoard(os.Stdin, os.Stdout, searcher)
+		return
+	}
+	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Println("=== Terminal Chess Engine (you are White, engine is Black) ===")
-	fmt.Println("Enter FEN (piece placement only) or press Enter for the normal start position:")
-	fmt.Printf("FEN [%s]: ", startFen)
+	fmt.Println("Enter a FEN or press Enter for the normal start position:")
 
-	line, _ := reader.ReadString('\n')
-	line = strings.TrimSpace(line)
-	if line == "" {
-		line = startFen
-	}
+	var pos handlers.Position
+	for {
+		fmt.Printf("FEN [%s]: ", handlers.StartFEN)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = handlers.StartFEN
+		}
+		// GUIs start the engine without arguments and open with "uci" or "xboard".
+		// End the prompt line first so protocol replies start on a line of their own.
+		if line == "uci" {
+			fmt.Println()
+			runUCI(reader, os.Stdout, searcher, true)
+			return
+		}
+		if line == "xboard" {
+			fmt.Println()
+			runXBoard(reader, os.Stdout, searcher)
+			return
+		}
 
-	board := parseFEN(line)
-	whiteToMove := true // you start as White
+		pos, err = handlers.ParseFEN(line)
+		if err == nil {
+			break
+		}
+		fmt.Println(err)
+	}
+	game := newCLIGame(&pos)
 
 	for {
-		printBoard(board)
+		printBoard(pos.Board)
+
+		history, _ := game.Hashes()
+		if result := handlers.Result(&pos, history); result != "*" && game.Result() == "*" {
+			game.SetTag("Result", result)
+			switch pos.Status() {
+			case handlers.Checkmate:
+				if result == "1-0" {
+					fmt.Println("Checkmate! White wins.")
+				} else {
+					fmt.Println("Checkmate! Black wins.")
+				}
+			case handlers.Stalemate:
+				fmt.Println("Stalemate. The game is drawn.")
+			default:
+				fmt.Printf("Draw by %s.\n", handlers.CheckDraw(&pos, history))
+			}
+		}
+		gameOver := game.Result() != "*"
 
-		if whiteToMove {
-			fmt.Println("Your move (format: e2e4, or 'q' to quit):")
+		if pos.WhiteToMove || gameOver {
+			if gameOver {
+				fmt.Printf("Game over (%s). 'save game.pgn', 'load game.pgn' or 'q' to quit:\n", game.Result())
+			} else {
+				fmt.Println("Your move (e2e4 or SAN like Nf3, e7e8n or e8=N to under-promote, 'save game.pgn', 'load game.pgn' or 'q' to quit):")
+			}
 			fmt.Print("> ")
-			moveStr, _ := reader.ReadString('\n')
-			moveStr = strings.TrimSpace(strings.ToLower(moveStr))
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Println("Exiting game.")
+				return
+			}
+			line = strings.TrimSpace(line)
+
+			if command, path, _ := strings.Cut(line, " "); command == "save" || command == "load" {
+				path = strings.TrimSpace(path)
+				if path == "" {
+					fmt.Printf("Usage: %s game.pgn\n", command)
+					continue
+				}
+				if command == "save" {
+					if err := saveGame(game, path); err != nil {
+						fmt.Println("Could not save the game:", err)
+						continue
+					}
+					fmt.Printf("Saved %d moves to %s\n", len(game.Moves), path)
+					continue
+				}
+				loaded, loadedPos, err := loadGame(path)
+				if err != nil {
+					fmt.Println("Could not load the game:", err)
+					continue
+				}
+				game, pos = loaded, loadedPos
+				searcher.NewGame()
+				fmt.Printf("Loaded %d moves from %s\n", len(game.Moves), path)
+				continue
+			}
+			moveStr := strings.ToLower(line)
 
 			if moveStr == "q" || moveStr == "quit" || moveStr == "exit" {
 				fmt.Println("Exiting game.")
 				return
 			}
+			if gameOver {
+				fmt.Println("The game is over.")
+				continue
+			}
 
-			if len(moveStr) != 4 {
-				fmt.Println("Invalid input. Use format like e2e4.")
+			// Anything that is not coordinate notation is read as SAN, e.g. "Nf3" or "exd5".
+			if _, ok := handlers.ParseMove(moveStr); !ok {
+				mv, err := handlers.ParseSAN(&pos, line)
+				if err != nil {
+					fmt.Println("Invalid input:", err)
+					continue
+				}
+				game.AddMove(mv)
+				pos.MakeMove(mv)
 				continue
 			}
 
@@ -201,7 +292,7 @@ func main() {
 				continue
 			}
 
-			piece := board[fromRow][fromCol]
+			piece := pos.Board[fromRow][fromCol]
 			if piece == 0 {
 				fmt.Println("No piece on that square.")
 				continue
@@ -211,53 +302,57 @@ func main() {
 				continue
 			}
 
+			// A promotion defaults to a queen unless a fifth letter (q, r, b, n) picks another piece.
 			var promotionPiece *rune
 			if piece == 'P' && toRow == 0 {
 				q := 'Q'
+				if len(moveStr) == 5 {
+					q = rune(strings.ToUpper(moveStr[4:])[0])
+				}
 				promotionPiece = &q
+			} else if len(moveStr) == 5 {
+				fmt.Println("Only a pawn reaching the last rank can promote.")
+				continue
 			}
 
-			if !handlers.IsValidMove(board, piece, fromRow, fromCol, toRow, toCol, promotionPiece) {
+			if !handlers.IsValidMove(&pos, fromRow, fromCol, toRow, toCol, promotionPiece) {
 				fmt.Println("Illegal move according to engine rules.")
 				continue
 			}
 
 			mv := handlers.Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
-			board = applyMove(board, mv)
-			whiteToMove = false
+			if promotionPiece != nil {
+				mv.Promotion = *promotionPiece
+			}
+			game.AddMove(mv)
+			pos.MakeMove(mv)
 
 		} else {
 			// reset profiling before engine move
-			handlers.ResetProfiling()
+			searcher.ResetStats()
 
 			fmt.Println("Engine thinking...")
+			searcher.SetHistory(history)
 			start := time.Now()
-			bestMove := handlers.FindBestMove(board, whiteToMove)
+			bestMove := searcher.FindBestMove(context.Background(), &pos)
 			elapsed := time.Since(start)
 
-			if bestMove.FromRow == 0 && bestMove.FromCol == 0 &&
-				bestMove.ToRow == 0 && bestMove.ToCol == 0 {
-				fmt.Println("Engine has no legal moves. Game over.")
-				return
-			}
-
-			board = applyMove(board, bestMove)
-			fmt.Printf("Engine plays: %s%s (took %v)\n",
-				coordsToSquare(bestMove.FromRow, bestMove.FromCol),
-				coordsToSquare(bestMove.ToRow, bestMove.ToCol),
-				elapsed)
+			san := handlers.MoveToSAN(&pos, bestMove)
+			game.AddMove(bestMove)
+			pos.MakeMove(bestMove)
+			fmt.Printf("Engine plays: %s (%s, took %v)\n", san, bestMove, elapsed)
 
 			// Print aggregated profiling info for this engine move
 			fmt.Println("Profiling (this engine move):")
-			fmt.Printf("  FindBestMove:      %v over %d calls\n", handlers.FindBestMoveTime, handlers.FindBestMoveCount)
-			fmt.Printf("  Minimax:           %v over %d calls\n", handlers.MinimaxTime, handlers.MinimaxCount)
-			fmt.Printf("  QuiescenceSearch:  %v over %d calls\n", handlers.QuiescenceTime, handlers.QuiescenceCount)
-			fmt.Printf("  GenAllMoves:       %v over %d calls\n", handlers.GenerateAllMovesTime, handlers.GenerateAllMovesCount)
-			fmt.Printf("  GenCaptureMoves:   %v over %d calls\n", handlers.GenerateCaptureMovesTime, handlers.GenerateCaptureMovesCount)
-			fmt.Printf("  IsValidMove:       %v over %d calls\n", handlers.IsValidMoveTime, handlers.IsValidMoveCount)
-
-			fmt.Println("New FEN (piece placement):", boardToFEN(board))
-			whiteToMove = true
+			stats := searcher.Stats
+			fmt.Printf("  Searches:          %v over %d calls\n", stats.Time, stats.Searches)
+			fmt.Printf("  Nodes:             %d (%d in quiescence)\n", stats.Nodes, stats.QuiescenceNodes)
+			fmt.Printf("  TT hits:           %d\n", stats.TTHits)
+			fmt.Printf("  Tablebase hits:    %d\n", stats.TBHits)
+			fmt.Printf("  Null-move cutoffs: %d\n", stats.NullCutoffs)
+			fmt.Printf("  Beta cutoffs:      %d\n", stats.BetaCutoffs)
+
+			fmt.Println("New FEN:", pos.FEN())
 		}
 	}
 }
@@ -31,12 +31,12 @@ let wasmInstance;
 })();
 
 onmessage = function (e) {
-    const { type, payload, fen, movesToSearch, isWhiteTurn } = e.data;
+    const { type, payload, fen, movesToSearch, isWhiteTurn, timeLimitMs } = e.data;
 
     switch (type) {
         case "INIT_BOARD":
             console.log("Worker: Initializing board with FEN:", payload.fen);
-            self.init_board_wasm(payload.fen);
+            self.init_board_wasm(payload.fen, JSON.stringify(payload.history || []));
             postMessage({ type: "INIT_BOARD_RESULT" });
             break;
         case "VALIDATE_MOVE":
@@ -46,20 +46,22 @@ onmessage = function (e) {
             break;
         case "GET_AI_MOVE":
             console.log("Worker: Getting AI move");
-            const aiResult = self.get_ai_move_string_wasm(payload && payload.isWhiteTurn);
+            const aiResult = self.get_ai_move_string_wasm(payload && payload.isWhiteTurn, payload && payload.timeLimitMs);
             postMessage({ type: "GET_AI_MOVE_RESULT", payload: aiResult });
             break;
         case "GET_ALL_MOVES":
             console.log("Worker: Getting all legal moves for FEN:", fen);
-            const isWhiteTurn = e.data.isWhiteTurn !== undefined ? e.data.isWhiteTurn : false;
-            const movesJson = self.get_all_legal_moves_wasm(fen, isWhiteTurn);
+            // Not named isWhiteTurn: a const in the switch would shadow the destructured
+            // value for every case and throw in SEARCH_SUBSET.
+            const sideToMove = isWhiteTurn !== undefined ? isWhiteTurn : false;
+            const movesJson = self.get_all_legal_moves_wasm(fen, sideToMove);
             postMessage({ type: "GET_MOVES_RESULT", data: movesJson });
             break;
         case "SEARCH_SUBSET":
             // Root splitting: search only the assigned moves
             console.log("Worker: Searching subset of moves", movesToSearch);
             try {
-                const resultJson = self.search_subset_wasm(fen, JSON.stringify(movesToSearch), isWhiteTurn);
+                const resultJson = self.search_subset_wasm(fen, JSON.stringify(movesToSearch), isWhiteTurn, timeLimitMs);
                 postMessage({ type: "SEARCH_SUBSET_RESULT", data: resultJson });
             } catch (err) {
                 postMessage({
@@ -71,8 +73,17 @@ onmessage = function (e) {
         case "APPLY_MOVE":
             // Apply a move and return new FEN
             const moveJson = e.data.moveJson;
-            const applyResult = self.apply_move_wasm(e.data.fen, moveJson);
+            const applyResult = self.apply_move_wasm(e.data.fen, moveJson, e.data.history || "[]");
             postMessage({ type: "APPLY_MOVE_RESULT", data: applyResult });
             break;
+        case "LOAD_BOOK":
+            // Polyglot book bytes fetched or picked by the page
+            const bookResult = self.load_book_wasm(e.data.bytes);
+            postMessage({ type: "LOAD_BOOK_RESULT", data: bookResult });
+            break;
+        case "BOOK_MOVE":
+            const bookMove = self.book_move_wasm(fen);
+            postMessage({ type: "BOOK_MOVE_RESULT", data: bookMove });
+            break;
     }
 };
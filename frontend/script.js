@@ -4,6 +4,7 @@ document.addEventListener('DOMContentLoaded', () => {
     const restartButton = document.getElementById('restart-button');
     const heatmapToggle = document.getElementById('heatmap-toggle');
     const sideSelect = document.getElementById('side-select');
+    const bookInput = document.getElementById('book-input');
     const fenDisplay = document.getElementById('fen-display');
     const pvDisplay = document.getElementById('pv-display');
     const candidatesList = document.getElementById('candidates-list');
@@ -18,7 +19,13 @@ document.addEventListener('DOMContentLoaded', () => {
         'q': 'pieces/blackQueen.svg', 'k': 'pieces/blackKing.svg'
     };
 
+    const START_FEN = 'rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1';
+    // Workers stop searching after this long and return their best move so far,
+    // well before waitForWorkerMessage gives up on them.
+    const SEARCH_TIME_LIMIT_MS = 10000;
+
     let boardState = [];
+    let currentFen = START_FEN;
     let fromSquare = null;
     let isAwaitingAi = false;
     let isGameOver = false;
@@ -28,6 +35,10 @@ document.addEventListener('DOMContentLoaded', () => {
     let legalMoves = [];
     let candidateMoves = [];
     let moveHistory = [];
+    // FENs of the positions before currentFen, sent to the workers so that they
+    // can find repetitions.
+    let positionHistory = [];
+    let bookLoaded = false;
 
     function playerIsWhite() {
         return sideSelect.value === 'White';
@@ -41,7 +52,9 @@ document.addEventListener('DOMContentLoaded', () => {
         return isWhite ? 'White' : 'Black';
     }
 
-    function endGame(outcome) {
+    // endGame shows the result: outcome is 'win', 'lose' or 'draw', and reason
+    // says why a drawn game is drawn.
+    function endGame(outcome, reason = '') {
         isGameOver = true;
         gameOutcome = outcome;
         const overlay = document.getElementById('game-over-overlay');
@@ -50,21 +63,74 @@ document.addEventListener('DOMContentLoaded', () => {
         const subtitle = document.getElementById('game-over-subtitle');
         overlay.classList.remove('hidden');
         overlay.setAttribute('aria-hidden', 'false');
-        card.classList.remove('win', 'lose');
-        card.classList.add(outcome === 'win' ? 'win' : 'lose');
-        title.textContent = outcome === 'win' ? 'You won' : 'You lost';
-        subtitle.textContent = outcome === 'win'
-            ? 'The AI has no legal moves left.'
-            : 'The AI won this game.';
+        card.classList.remove('win', 'lose', 'draw');
+        card.classList.add(outcome);
+        if (outcome === 'draw') {
+            title.textContent = 'Draw';
+            subtitle.textContent = `Drawn by ${reason}.`;
+        } else {
+            title.textContent = outcome === 'win' ? 'You won' : 'You lost';
+            subtitle.textContent = outcome === 'win'
+                ? `Checkmate: ${sideName(playerIsWhite())} wins.`
+                : `Checkmate: ${sideName(aiIsWhite())} wins.`;
+        }
         updateStatus();
     }
 
+    // endGameByStatus ends the game when the side to move is mated or stalemated.
+    // status comes from the engine; playerMoved says whose move led to it.
+    function endGameByStatus(status, playerMoved) {
+        if (status === 'checkmate') {
+            endGame(playerMoved ? 'win' : 'lose');
+            return true;
+        }
+        if (status === 'stalemate') {
+            endGame('draw', 'stalemate');
+            return true;
+        }
+        return false;
+    }
+
     function hideGameOverUi() {
         const overlay = document.getElementById('game-over-overlay');
         const card = document.getElementById('game-over-card');
         overlay.classList.add('hidden');
         overlay.setAttribute('aria-hidden', 'true');
-        card.classList.remove('win', 'lose');
+        card.classList.remove('win', 'lose', 'draw');
+    }
+
+    // showPromotionPicker asks which piece a pawn should promote to and resolves
+    // with 'q', 'r', 'b' or 'n', or null when the player cancels.
+    function showPromotionPicker(isWhite) {
+        const overlay = document.getElementById('promotion-overlay');
+        const choices = document.getElementById('promotion-choices');
+        const cancelButton = document.getElementById('promotion-cancel');
+        return new Promise((resolve) => {
+            const finish = (letter) => {
+                overlay.classList.add('hidden');
+                overlay.setAttribute('aria-hidden', 'true');
+                choices.innerHTML = '';
+                cancelButton.onclick = null;
+                resolve(letter);
+            };
+            choices.innerHTML = '';
+            ['q', 'r', 'b', 'n'].forEach(letter => {
+                const piece = isWhite ? letter.toUpperCase() : letter;
+                const button = document.createElement('button');
+                button.type = 'button';
+                button.className = 'promotion-choice';
+                button.setAttribute('aria-label', { q: 'Queen', r: 'Rook', b: 'Bishop', n: 'Knight' }[letter]);
+                const img = document.createElement('img');
+                img.src = pieceImageFiles[piece];
+                img.alt = piece;
+                button.appendChild(img);
+                button.addEventListener('click', () => finish(letter));
+                choices.appendChild(button);
+            });
+            cancelButton.onclick = () => finish(null);
+            overlay.classList.remove('hidden');
+            overlay.setAttribute('aria-hidden', 'false');
+        });
     }
 
     function callWorker(type, payload) {
@@ -97,7 +163,7 @@ document.addEventListener('DOMContentLoaded', () => {
         if (typeof move === 'string') return move;
         if (move.move) return move.move;
         if (move.fromRow !== undefined) {
-            return coordsToSquare(move.fromRow, move.fromCol) + coordsToSquare(move.toRow, move.toCol);
+            return coordsToSquare(move.fromRow, move.fromCol) + coordsToSquare(move.toRow, move.toCol) + (move.promotion || '');
         }
         return String(move);
     }
@@ -111,6 +177,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 fromCol: squareToCol(text.slice(0, 2)),
                 toRow: squareToRow(text.slice(2, 4)),
                 toCol: squareToCol(text.slice(2, 4)),
+                promotion: text.slice(4, 5),
                 raw: move && typeof move === 'object' ? move : null,
                 score: move && typeof move === 'object' ? move.score : undefined
             };
@@ -118,28 +185,6 @@ document.addEventListener('DOMContentLoaded', () => {
         return null;
     }
 
-    function boardToFen() {
-        let fen = '';
-        for (let r = 0; r < 8; r++) {
-            let empty = 0;
-            for (let c = 0; c < 8; c++) {
-                const piece = boardState[r][c];
-                if (piece === ' ') {
-                    empty++;
-                } else {
-                    if (empty > 0) {
-                        fen += empty;
-                        empty = 0;
-                    }
-                    fen += piece;
-                }
-            }
-            if (empty > 0) fen += empty;
-            if (r < 7) fen += '/';
-        }
-        return fen;
-    }
-
     function fenToBoard(fen) {
         const board = Array(8).fill(null).map(() => Array(8).fill(' '));
         const [position] = fen.split(' ');
@@ -158,8 +203,26 @@ document.addEventListener('DOMContentLoaded', () => {
         return board;
     }
 
+    // The FEN returned by Go is the single source of truth: it carries side to move,
+    // castling rights, en-passant square and clocks that the board array cannot.
+    function setPosition(fen) {
+        currentFen = fen;
+        boardState = fenToBoard(fen);
+    }
+
+    // advancePosition moves the game on to fen, remembering the position it leaves.
+    function advancePosition(fen) {
+        positionHistory.push(currentFen);
+        setPosition(fen);
+    }
+
+    // initBoardMessage tells a worker the current position and how the game got there.
+    function initBoardMessage(fen) {
+        return { type: 'INIT_BOARD', payload: { fen, history: positionHistory } };
+    }
+
     async function getLegalMovesForCurrentSide(isWhiteTurn) {
-        const fen = boardToFen();
+        const fen = currentFen;
         return new Promise((resolve) => {
             const listener = (e) => {
                 if (e.data.type === 'GET_MOVES_RESULT') {
@@ -243,8 +306,7 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     function updateAnalysisPanels() {
-        const activeSide = isAwaitingAi ? aiIsWhite() : playerIsWhite();
-        fenDisplay.textContent = boardToFen() + ' ' + (activeSide ? 'w' : 'b') + ' - - 0 1';
+        fenDisplay.textContent = currentFen;
         pvDisplay.textContent = candidateMoves.length
             ? candidateMoves.slice(0, 3).map((move, index) => `${index + 1}. ${move.text}`).join(' ')
             : 'No candidate line yet.';
@@ -281,7 +343,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
     function updateStatus() {
         if (isGameOver && gameOutcome) {
-            statusElement.textContent = gameOutcome === 'win' ? 'You won' : 'You lost';
+            statusElement.textContent = { win: 'You won', lose: 'You lost', draw: 'Draw' }[gameOutcome];
         } else if (isAwaitingAi) {
             statusElement.textContent = `${sideName(aiIsWhite())} is thinking`;
         } else {
@@ -297,14 +359,14 @@ document.addEventListener('DOMContentLoaded', () => {
 
     async function refreshLegalMoves(checkForLoss = false) {
         legalMoves = await getLegalMovesForCurrentSide(playerIsWhite());
-        if (checkForLoss && legalMoves.length === 0) {
+        if (checkForLoss && !isGameOver && legalMoves.length === 0) {
             endGame('lose');
         }
         if (!candidateMoves.length) candidateMoves = legalMoves.slice(0, 8);
         updateUi();
     }
 
-    function handleSquareClick(row, col) {
+    async function handleSquareClick(row, col) {
         if (isGameOver || isAwaitingAi) return;
         const clickedPiece = boardState[row][col];
         const isWhitePiece = clickedPiece !== ' ' && clickedPiece === clickedPiece.toUpperCase();
@@ -329,7 +391,21 @@ document.addEventListener('DOMContentLoaded', () => {
             updateUi();
             return;
         }
-        const moveString = coordsToSquare(fromSquare.row, fromSquare.col) + coordsToSquare(row, col);
+        let moveString = coordsToSquare(fromSquare.row, fromSquare.col) + coordsToSquare(row, col);
+        const isPromotion = legalMoves.some(move =>
+            move.promotion &&
+            move.fromRow === fromSquare.row && move.fromCol === fromSquare.col &&
+            move.toRow === row && move.toCol === col);
+        if (isPromotion) {
+            isAwaitingAi = true;
+            const letter = await showPromotionPicker(playerIsWhite());
+            isAwaitingAi = false;
+            if (!letter) {
+                updateUi();
+                return;
+            }
+            moveString += letter;
+        }
         isAwaitingAi = true;
         setSearchFlow([{ text: `Validating ${moveString}`, state: 'active' }]);
         validateMove(moveString);
@@ -339,12 +415,21 @@ document.addEventListener('DOMContentLoaded', () => {
         try {
             const result = await callWorker('VALIDATE_MOVE', { moveString, isWhiteTurn: playerIsWhite() });
             if (result && result.valid) {
-                boardState = fenToBoard(result.newFen);
+                advancePosition(result.newFen);
                 lastMove = normalizeMove(moveString);
-                moveHistory.push(moveString);
+                moveHistory.push(result.san || moveString);
                 fromSquare = null;
                 candidateMoves = [];
                 updateUi();
+                if (endGameByStatus(result.status, true)) {
+                    isAwaitingAi = false;
+                    return;
+                }
+                if (result.draw) {
+                    isAwaitingAi = false;
+                    endGame('draw', result.draw);
+                    return;
+                }
                 setTimeout(() => getAiMove(), 100);
             } else {
                 statusElement.textContent = result.error || 'Invalid move';
@@ -387,16 +472,49 @@ document.addEventListener('DOMContentLoaded', () => {
     async function syncWorkers(fen) {
         await Promise.all(window.chessWorkers.map(worker => {
             return waitForWorkerMessage(worker, 'INIT_BOARD_RESULT', () => {
-                worker.postMessage({ type: 'INIT_BOARD', payload: { fen } });
+                worker.postMessage(initBoardMessage(fen));
             }, 5000);
         }));
     }
 
+    // loadBook hands the bytes of a Polyglot .bin book to the first worker, which
+    // is the one asked for book moves. An empty buffer unloads the book.
+    async function loadBook(bytes, name) {
+        const result = await waitForWorkerMessage(window.chessWorkers[0], 'LOAD_BOOK_RESULT', () => {
+            window.chessWorkers[0].postMessage({ type: 'LOAD_BOOK', bytes: new Uint8Array(bytes) });
+        }, 5000);
+        const data = result.data || {};
+        bookLoaded = Boolean(data.loaded);
+        if (data.error) {
+            setSearchFlow([{ text: `Book ${name} rejected: ${data.error}`, state: 'done' }]);
+        } else if (bookLoaded) {
+            setSearchFlow([{ text: `Book ${name} loaded (${data.entries} entries)`, state: 'done' }]);
+        }
+    }
+
+    // loadDefaultBook uses book.bin next to the page when the site provides one.
+    async function loadDefaultBook() {
+        try {
+            const resp = await fetch('book.bin');
+            if (resp.ok) await loadBook(await resp.arrayBuffer(), 'book.bin');
+        } catch (error) {
+            console.warn('No default opening book:', error);
+        }
+    }
+
+    async function getBookMove(fen) {
+        if (!bookLoaded) return null;
+        const result = await waitForWorkerMessage(window.chessWorkers[0], 'BOOK_MOVE_RESULT', () => {
+            window.chessWorkers[0].postMessage({ type: 'BOOK_MOVE', fen });
+        }, 5000);
+        return result.data && result.data.found ? result.data : null;
+    }
+
     async function getAiMove() {
         isAwaitingAi = true;
         updateUi();
         try {
-            const fen = boardToFen();
+            const fen = currentFen;
             setSearchFlow([
                 { text: 'Board synced to workers', state: 'done' },
                 { text: `Generating ${sideName(aiIsWhite()).toLowerCase()} legal moves`, state: 'active' }
@@ -412,11 +530,18 @@ document.addEventListener('DOMContentLoaded', () => {
                 return;
             }
 
+            const bookMove = await getBookMove(fen);
+            if (bookMove) {
+                finishAiSearch([{ ...bookMove, score: NaN, book: true }], fen);
+                return;
+            }
+
             const moveObjects = allMoves.map(move => move.raw || {
                 fromRow: move.fromRow,
                 fromCol: move.fromCol,
                 toRow: move.toRow,
-                toCol: move.toCol
+                toCol: move.toCol,
+                promotion: move.promotion
             });
             const chunks = splitIntoChunks(moveObjects, window.chessWorkers.length);
             const activeChunks = chunks
@@ -435,7 +560,8 @@ document.addEventListener('DOMContentLoaded', () => {
                         type: 'SEARCH_SUBSET',
                         fen,
                         movesToSearch: item.chunk,
-                        isWhiteTurn: aiIsWhite()
+                        isWhiteTurn: aiIsWhite(),
+                        timeLimitMs: SEARCH_TIME_LIMIT_MS
                     });
                 });
             }));
@@ -477,9 +603,10 @@ document.addEventListener('DOMContentLoaded', () => {
             fromRow: bestOverall.fromRow,
             fromCol: bestOverall.fromCol,
             toRow: bestOverall.toRow,
-            toCol: bestOverall.toCol
+            toCol: bestOverall.toCol,
+            promotion: bestOverall.promotion || ''
         });
-        window.chessWorkers[0].postMessage({ type: 'APPLY_MOVE', fen, moveJson });
+        window.chessWorkers[0].postMessage({ type: 'APPLY_MOVE', fen, moveJson, history: JSON.stringify(positionHistory) });
         const applyMoveListener = (e) => {
             if (e.data.type !== 'APPLY_MOVE_RESULT') return;
             window.chessWorkers[0].removeEventListener('message', applyMoveListener);
@@ -488,17 +615,25 @@ document.addEventListener('DOMContentLoaded', () => {
                 return;
             }
             const newFen = e.data.data.newFen;
-            boardState = fenToBoard(newFen);
+            advancePosition(newFen);
             const played = normalizeMove(bestOverall);
             lastMove = played;
-            moveHistory.push(played.text);
+            const san = e.data.data.san || played.text;
+            moveHistory.push(san);
             candidateMoves = [played, ...candidateMoves.filter(move => move.text !== played.text)].slice(0, 8);
             setSearchFlow([
-                { text: `Engine chose ${played.text}`, state: 'done' },
-                { text: `Score ${Number.isFinite(bestOverall.score) ? displayScore(bestOverall.score).toFixed(2) : 'n/a'}`, state: 'done' }
+                { text: `Engine chose ${san}`, state: 'done' },
+                bestOverall.book
+                    ? { text: 'Book move', state: 'done' }
+                    : { text: `Score ${Number.isFinite(bestOverall.score) ? displayScore(bestOverall.score).toFixed(2) : 'n/a'}`, state: 'done' }
             ]);
-            window.chessWorkers.forEach(worker => worker.postMessage({ type: 'INIT_BOARD', payload: { fen: newFen } }));
+            window.chessWorkers.forEach(worker => worker.postMessage(initBoardMessage(newFen)));
             isAwaitingAi = false;
+            if (endGameByStatus(e.data.data.status, false)) return;
+            if (e.data.data.draw) {
+                endGame('draw', e.data.data.draw);
+                return;
+            }
             refreshLegalMoves(true);
         };
         window.chessWorkers[0].addEventListener('message', applyMoveListener);
@@ -506,7 +641,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
     async function getAiMoveSingleWorker() {
         try {
-            const fen = boardToFen();
+            const fen = currentFen;
             await new Promise((resolve) => {
                 const listener = (e) => {
                     if (e.data.type === 'INIT_BOARD_RESULT') {
@@ -515,21 +650,18 @@ document.addEventListener('DOMContentLoaded', () => {
                     }
                 };
                 window.chessWorker.addEventListener('message', listener);
-                window.chessWorker.postMessage({ type: 'INIT_BOARD', payload: { fen } });
+                window.chessWorker.postMessage(initBoardMessage(fen));
             });
-            const aiMove = await callWorker('GET_AI_MOVE', { isWhiteTurn: aiIsWhite() });
+            const aiMove = await callWorker('GET_AI_MOVE', { isWhiteTurn: aiIsWhite(), timeLimitMs: SEARCH_TIME_LIMIT_MS });
             if (aiMove && aiMove.valid) {
-                if (!aiMove.gamestatus) endGame('lose');
-                if (aiMove.newFen) boardState = fenToBoard(aiMove.newFen);
+                if (aiMove.newFen) advancePosition(aiMove.newFen);
+                if (!endGameByStatus(aiMove.status, false) && aiMove.draw) endGame('draw', aiMove.draw);
                 if (aiMove.move) {
                     const played = normalizeMove(aiMove.move);
                     lastMove = played;
-                    moveHistory.push(played.text);
+                    moveHistory.push(aiMove.san || played.text);
                 }
-                window.chessWorkers.forEach(worker => worker.postMessage({
-                    type: 'INIT_BOARD',
-                    payload: { fen: aiMove.newFen || boardToFen() }
-                }));
+                window.chessWorkers.forEach(worker => worker.postMessage(initBoardMessage(currentFen)));
             } else {
                 endGame('win');
             }
@@ -542,9 +674,9 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     async function initGame() {
-        const startFen = 'rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR';
-        boardState = fenToBoard(startFen);
-        window.chessWorkers.forEach(worker => worker.postMessage({ type: 'INIT_BOARD', payload: { fen: startFen } }));
+        setPosition(START_FEN);
+        positionHistory = [];
+        window.chessWorkers.forEach(worker => worker.postMessage(initBoardMessage(START_FEN)));
         fromSquare = null;
         isAwaitingAi = false;
         isGameOver = false;
@@ -574,7 +706,20 @@ document.addEventListener('DOMContentLoaded', () => {
 
     sideSelect.addEventListener('change', initGame);
 
-    window.onChessWorkerReady = () => initGame();
+    bookInput.addEventListener('change', async () => {
+        const file = bookInput.files[0];
+        if (!file) return;
+        try {
+            await loadBook(await file.arrayBuffer(), file.name);
+        } catch (error) {
+            setSearchFlow([{ text: `Book ${file.name} failed: ${error.message}`, state: 'done' }]);
+        }
+    });
+
+    window.onChessWorkerReady = async () => {
+        await loadDefaultBook();
+        initGame();
+    };
     restartButton.addEventListener('click', initGame);
     document.getElementById('game-over-restart').addEventListener('click', initGame);
 });
@@ -0,0 +1,89 @@
+package handlers
+
+// DrawReason tells why a game is drawn.
+type DrawReason int
+
+const (
+	NotDrawn DrawReason = iota
+	DrawThreefoldRepetition
+	DrawFiftyMoveRule
+	DrawSeventyFiveMoveRule
+	DrawInsufficientMaterial
+)
+
+func (r DrawReason) String() string {
+	switch r {
+	case DrawThreefoldRepetition:
+		return "threefold repetition"
+	case DrawFiftyMoveRule:
+		return "fifty-move rule"
+	case DrawSeventyFiveMoveRule:
+		return "seventy-five-move rule"
+	case DrawInsufficientMaterial:
+		return "insufficient material"
+	}
+	return "not drawn"
+}
+
+// CheckDraw reports whether the game that reached pos is drawn. history holds
+// the Zobrist hashes (GetZobristValue) of the earlier positions of the game,
+// oldest first; only those since the last capture or pawn move matter. The
+// fifty-move rule and threefold repetition are treated as claimed at once. A
+// checkmate given with the last move of the fifty or seventy-five takes
+// precedence, and stalemate is left to the caller.
+func CheckDraw(pos *Position, history []uint64) DrawReason {
+	if pos.HalfmoveClock >= 100 && isCheckmate(pos) {
+		return NotDrawn
+	}
+	switch {
+	case pos.HalfmoveClock >= 150:
+		return DrawSeventyFiveMoveRule
+	case IsInsufficientMaterial(pos.Board):
+		return DrawInsufficientMaterial
+	case repetitions(GetZobristValue(pos), history, pos.HalfmoveClock) >= 2:
+		return DrawThreefoldRepetition
+	case pos.HalfmoveClock >= 100:
+		return DrawFiftyMoveRule
+	}
+	return NotDrawn
+}
+
+// repetitions counts how often hash occurs among the last halfmoveClock entries
+// of history, which are the only positions since the last irreversible move.
+// Positions with the other side to move are skipped.
+func repetitions(hash uint64, history []uint64, halfmoveClock int) int {
+	count := 0
+	for back := 2; back <= halfmoveClock && back <= len(history); back += 2 {
+		if history[len(history)-back] == hash {
+			count++
+		}
+	}
+	return count
+}
+
+// IsInsufficientMaterial reports whether neither side can possibly checkmate:
+// king against king, king and one minor piece against king, or only bishops
+// besides the kings, all on squares of one colour.
+func IsInsufficientMaterial(board [8][8]rune) bool {
+	knights, bishops := 0, 0
+	var bishopColours [2]bool
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			switch board[row][col] {
+			case 0, 'K', 'k':
+			case 'N', 'n':
+				knights++
+			case 'B', 'b':
+				bishops++
+				bishopColours[(row+col)%2] = true
+			default:
+				return false
+			}
+		}
+	}
+	if knights+bishops <= 1 {
+		return true
+	}
+	// Two knights, a knight and a bishop, or bishops on both colours can mate.
+	return knights == 0 && !(bishopColours[0] && bishopColours[1])
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInsufficientMaterial(t *testing.T) {
+	tests := []struct {
+		fen  string
+		want bool
+	}{
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", true},
+		{"4k3/8/8/8/8/8/8/2N1K3 w - - 0 1", true},
+		{"4k3/8/8/8/8/8/8/2b1K3 w - - 0 1", true},
+		// Bishops all on dark squares (c1, f8 and b2).
+		{"4kb2/8/8/8/8/8/1B6/2B1K3 w - - 0 1", true},
+		{"4k3/8/8/8/8/8/8/2BBK3 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/8/1NN1K3 w - - 0 1", false},
+		{"4kn2/8/8/8/8/8/8/2B1K3 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 0 1", false},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := IsInsufficientMaterial(pos.Board); got != tc.want {
+			t.Errorf("IsInsufficientMaterial(%q) = %v, want %v", tc.fen, got, tc.want)
+		}
+	}
+}
+
+func TestCheckDraw(t *testing.T) {
+	// Shuffle the knights out and back twice: the start position comes up for
+	// the third time after the eighth move.
+	pos, _ := ParseFEN(StartFEN)
+	var history []uint64
+	for i, text := range []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"} {
+		if got := CheckDraw(&pos, history); got != NotDrawn {
+			t.Fatalf("drawn by %v before move %d", got, i+1)
+		}
+		move, _ := ParseMove(text)
+		history = append(history, GetZobristValue(&pos))
+		pos.MakeMove(move)
+	}
+	if got := CheckDraw(&pos, history); got != DrawThreefoldRepetition {
+		t.Errorf("CheckDraw after the repetition = %v, want %v", got, DrawThreefoldRepetition)
+	}
+
+	// The first time the position after 1. e4 comes up it has an en-passant
+	// target, but no black pawn can take on e3, so it still counts.
+	pos, _ = ParseFEN(StartFEN)
+	history = nil
+	for i, text := range []string{"e2e4", "g8f6", "g1f3", "f6g8", "f3g1", "g8f6", "g1f3", "f6g8", "f3g1"} {
+		if got := CheckDraw(&pos, history); got != NotDrawn {
+			t.Fatalf("drawn by %v before move %d", got, i+1)
+		}
+		move, _ := ParseMove(text)
+		history = append(history, GetZobristValue(&pos))
+		pos.MakeMove(move)
+	}
+	if got := CheckDraw(&pos, history); got != DrawThreefoldRepetition {
+		t.Errorf("CheckDraw after a repetition across a double pawn push = %v, want %v", got, DrawThreefoldRepetition)
+	}
+
+	tests := []struct {
+		fen  string
+		want DrawReason
+	}{
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 99 80", NotDrawn},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 100 80", DrawFiftyMoveRule},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 150 100", DrawSeventyFiveMoveRule},
+		{"4k3/8/8/8/8/8/8/2N1K3 w - - 3 40", DrawInsufficientMaterial},
+		// Mate on the hundredth half-move still counts.
+		{"R3k3/8/4K3/8/8/8/8/8 b - - 100 80", NotDrawn},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := CheckDraw(&pos, nil); got != tc.want {
+			t.Errorf("CheckDraw(%q) = %v, want %v", tc.fen, got, tc.want)
+		}
+	}
+}
+
+func TestSearchScoresRepetitionAsDraw(t *testing.T) {
+	pos, _ := ParseFEN("4k3/8/8/8/8/8/8/Q3K3 w - - 2 10")
+	kd1, _ := ParseMove("e1d1")
+
+	s := NewSearcher()
+	if _, score := s.SearchSpecificMoves(context.Background(), &pos, []Move{kd1}); score <= 0 {
+		t.Fatalf("a queen up scores %d", score)
+	}
+
+	// The game has already been in the position after Kd1.
+	after := pos
+	after.MakeMove(kd1)
+	s = NewSearcher()
+	s.SetHistory([]uint64{GetZobristValue(&after)})
+	if _, score := s.SearchSpecificMoves(context.Background(), &pos, []Move{kd1}); score != 0 {
+		t.Errorf("repeating a position scores %d, want 0", score)
+	}
+}
+
+func TestFindBestMoveAvoidsRepetitionAfterSearch(t *testing.T) {
+	pos, _ := ParseFEN("4k3/8/8/8/8/8/8/Q3K3 w - - 2 10")
+	s := NewSearcher()
+	first := s.FindBestMove(context.Background(), &pos)
+
+	// The table now holds first for this position, but playing it again
+	// would repeat the position the game has already been in.
+	after := pos
+	after.MakeMove(first)
+	s.SetHistory([]uint64{GetZobristValue(&after)})
+	if again := s.FindBestMove(context.Background(), &pos); again == first {
+		t.Errorf("FindBestMove repeated %v into a drawn position", first)
+	}
+}
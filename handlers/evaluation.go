@@ -1,27 +1,12 @@
 package handlers
 
-import (
-	"crypto/rand"
-	"encoding/binary"
-	//"fmt"
-)
+//import "fmt"
 
 // type Move struct {
 // 	FromRow,FromCol int
 // 	ToRow,ToCol int
 // }
 
-type HashMap struct {
-	HashKey  uint64
-	Score    int
-	Depth    int
-	BestMove Move
-}
-
-const ttSize = 512
-
-var transpositionTable [ttSize]HashMap
-
 var WhitePawnPST = [8][8]int{
 	{0, 0, 0, 0, 0, 0, 0, 0},
 	{50, 50, 50, 50, 50, 50, 50, 50},
@@ -110,71 +95,6 @@ var WhiteKingEndgamePST = [8][8]int{
 	{-30, -30, 0, 0, 0, 0, -30, -30},
 	{-50, -30, -30, -30, -30, -30, -30, -50},
 }
-var zobristTable [12][64]uint64
-var current_hash uint64
-
-func randomUnit64() uint64 {
-	var buf [8]byte
-	_, _ = rand.Read(buf[:])
-	return binary.LittleEndian.Uint64(buf[:])
-}
-
-func InitZobrist() {
-	for p := 0; p < 12; p++ {
-		for sq := 0; sq < 64; sq++ {
-			zobristTable[p][sq] = randomUnit64()
-		}
-	}
-	//fmt.Println("Zobrist Table Initialised!!!")
-}
-
-var pieceToIndex = map[rune]int{
-	'P': 0, 'N': 1, 'B': 2, 'R': 3, 'Q': 4, 'K': 5,
-	'p': 6, 'n': 7, 'b': 8, 'r': 9, 'q': 10, 'k': 11,
-}
-
-func GetZobristValue(board [8][8]rune) uint64 {
-	var hash uint64 = 0
-	for row := 0; row < 8; row++ {
-		for col := 0; col < 8; col++ {
-			piece := board[row][col]
-			if pieceIndex, ok := pieceToIndex[piece]; ok {
-				squareIndex := row*8 + col
-				hash ^= zobristTable[pieceIndex][squareIndex]
-			}
-
-		}
-	}
-	current_hash = hash
-	return hash
-}
-
-func GetCurrentHash() uint64 {
-	return current_hash
-}
-
-func UpdateHashForMove(currentHash uint64, move Move, board [8][8]rune) uint64 {
-	newHash := currentHash
-
-	fromPiece := board[move.FromRow][move.FromCol]
-	if pieceIdx, ok := pieceToIndex[fromPiece]; ok {
-		fromSquare := move.FromRow*8 + move.FromCol
-		newHash ^= zobristTable[pieceIdx][fromSquare]
-	}
-
-	toPiece := board[move.ToRow][move.ToCol]
-	if pieceIdx, ok := pieceToIndex[toPiece]; ok {
-		toSquare := move.ToRow*8 + move.ToCol
-		newHash ^= zobristTable[pieceIdx][toSquare]
-	}
-
-	if pieceIdx, ok := pieceToIndex[fromPiece]; ok {
-		toSquare := move.ToRow*8 + move.ToCol
-		newHash ^= zobristTable[pieceIdx][toSquare]
-	}
-
-	return newHash
-}
 
 func GetValue(piece rune) int {
 	return PieceValues[piece]
@@ -186,35 +106,41 @@ func Evaluate_board(board [8][8]rune) int {
 		for j := 0; j < 8; j++ {
 			piece := board[i][j]
 			if piece != 0 {
-				board_state += GetValue(board[i][j])
-				switch piece {
-				case 'P':
-					board_state += WhitePawnPST[i][j]
-				case 'p':
-					board_state -= WhitePawnPST[7-i][j]
-				case 'Q':
-					board_state += WhiteQueenPST[i][j]
-				case 'q':
-					board_state -= WhiteQueenPST[7-i][j]
-				case 'N':
-					board_state += WhiteKnightPST[i][j]
-				case 'n':
-					board_state -= WhiteKnightPST[7-i][j]
-				case 'B':
-					board_state += WhiteBishopPST[i][j]
-				case 'b':
-					board_state -= WhiteBishopPST[7-i][j]
-				case 'R':
-					board_state += WhiteRookPST[i][j]
-				case 'r':
-					board_state -= WhiteRookPST[7-i][j]
-				case 'K':
-					board_state += WhiteKingMiddlegamePST[i][j]
-				case 'k':
-					board_state -= WhiteKingMiddlegamePST[7-i][j]
-				}
+				board_state += GetValue(piece) + pieceSquare(piece, i, j)
 			}
 		}
 	}
 	return board_state
 }
+
+// pieceSquare returns the piece-square table bonus of piece on row/col from
+// White's point of view, so negative for a well placed black piece.
+func pieceSquare(piece rune, row, col int) int {
+	switch piece {
+	case 'P':
+		return WhitePawnPST[row][col]
+	case 'p':
+		return -WhitePawnPST[7-row][col]
+	case 'Q':
+		return WhiteQueenPST[row][col]
+	case 'q':
+		return -WhiteQueenPST[7-row][col]
+	case 'N':
+		return WhiteKnightPST[row][col]
+	case 'n':
+		return -WhiteKnightPST[7-row][col]
+	case 'B':
+		return WhiteBishopPST[row][col]
+	case 'b':
+		return -WhiteBishopPST[7-row][col]
+	case 'R':
+		return WhiteRookPST[row][col]
+	case 'r':
+		return -WhiteRookPST[7-row][col]
+	case 'K':
+		return WhiteKingMiddlegamePST[row][col]
+	case 'k':
+		return -WhiteKingMiddlegamePST[7-row][col]
+	}
+	return 0
+}
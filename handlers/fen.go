@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StartFEN is the standard initial position.
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// ParseFEN parses a FEN string into a Position. Trailing fields may be omitted,
+// in which case they default to "w - - 0 1", so a bare piece placement is accepted.
+func ParseFEN(fen string) (Position, error) {
+	pos := Position{
+		WhiteToMove:    true,
+		EnPassantRow:   -1,
+		EnPassantCol:   -1,
+		FullmoveNumber: 1,
+	}
+
+	fields := strings.Fields(fen)
+	if len(fields) == 0 {
+		return pos, fmt.Errorf("invalid FEN: empty string")
+	}
+	if len(fields) > 6 {
+		return pos, fmt.Errorf("invalid FEN: expected at most 6 fields, got %d", len(fields))
+	}
+
+	if err := parsePlacement(fields[0], &pos.Board); err != nil {
+		return pos, err
+	}
+
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "w":
+			pos.WhiteToMove = true
+		case "b":
+			pos.WhiteToMove = false
+		default:
+			return pos, fmt.Errorf("invalid FEN: side to move must be 'w' or 'b', got %q", fields[1])
+		}
+	}
+
+	if len(fields) > 2 && fields[2] != "-" {
+		for _, c := range fields[2] {
+			switch c {
+			case 'K':
+				pos.Castling.WhiteKingSide = true
+			case 'Q':
+				pos.Castling.WhiteQueenSide = true
+			case 'k':
+				pos.Castling.BlackKingSide = true
+			case 'q':
+				pos.Castling.BlackQueenSide = true
+			default:
+				return pos, fmt.Errorf("invalid FEN: unknown castling flag %q in %q", c, fields[2])
+			}
+		}
+	}
+
+	if len(fields) > 3 && fields[3] != "-" {
+		row, col, ok := parseSquare(fields[3])
+		if !ok || (row != 2 && row != 5) {
+			return pos, fmt.Errorf("invalid FEN: bad en-passant square %q", fields[3])
+		}
+		pos.EnPassantRow, pos.EnPassantCol = row, col
+	}
+
+	if len(fields) > 4 {
+		n, err := strconv.Atoi(fields[4])
+		if err != nil || n < 0 {
+			return pos, fmt.Errorf("invalid FEN: bad halfmove clock %q", fields[4])
+		}
+		pos.HalfmoveClock = n
+	}
+
+	if len(fields) > 5 {
+		n, err := strconv.Atoi(fields[5])
+		if err != nil || n < 1 {
+			return pos, fmt.Errorf("invalid FEN: bad fullmove number %q", fields[5])
+		}
+		pos.FullmoveNumber = n
+	}
+
+	return pos, nil
+}
+
+// parsePlacement fills board from the first FEN field and checks that both kings are present.
+func parsePlacement(placement string, board *[8][8]rune) error {
+	ranks := strings.Split(placement, "/")
+	if len(ranks) != 8 {
+		return fmt.Errorf("invalid FEN: expected 8 ranks, got %d", len(ranks))
+	}
+
+	whiteKings, blackKings := 0, 0
+	for row, rank := range ranks {
+		col := 0
+		for _, char := range rank {
+			if char >= '1' && char <= '8' {
+				col += int(char - '0')
+				continue
+			}
+			if _, ok := pieceToIndex[char]; !ok {
+				return fmt.Errorf("invalid FEN: unknown piece %q on rank %d", char, 8-row)
+			}
+			if col >= 8 {
+				return fmt.Errorf("invalid FEN: rank %d has more than 8 squares", 8-row)
+			}
+			switch char {
+			case 'K':
+				whiteKings++
+			case 'k':
+				blackKings++
+			}
+			board[row][col] = char
+			col++
+		}
+		if col != 8 {
+			return fmt.Errorf("invalid FEN: rank %d has %d squares, want 8", 8-row, col)
+		}
+	}
+
+	if whiteKings != 1 {
+		if whiteKings == 0 {
+			return fmt.Errorf("invalid FEN: missing white king")
+		}
+		return fmt.Errorf("invalid FEN: %d white kings", whiteKings)
+	}
+	if blackKings != 1 {
+		if blackKings == 0 {
+			return fmt.Errorf("invalid FEN: missing black king")
+		}
+		return fmt.Errorf("invalid FEN: %d black kings", blackKings)
+	}
+	return nil
+}
+
+// FEN serialises the position back to all six FEN fields.
+func (pos *Position) FEN() string {
+	var sb strings.Builder
+	sb.WriteString(BoardToPlacement(pos.Board))
+
+	if pos.WhiteToMove {
+		sb.WriteString(" w ")
+	} else {
+		sb.WriteString(" b ")
+	}
+
+	castling := ""
+	if pos.Castling.WhiteKingSide {
+		castling += "K"
+	}
+	if pos.Castling.WhiteQueenSide {
+		castling += "Q"
+	}
+	if pos.Castling.BlackKingSide {
+		castling += "k"
+	}
+	if pos.Castling.BlackQueenSide {
+		castling += "q"
+	}
+	if castling == "" {
+		castling = "-"
+	}
+	sb.WriteString(castling)
+
+	if pos.HasEnPassant() {
+		sb.WriteString(" " + squareName(pos.EnPassantRow, pos.EnPassantCol))
+	} else {
+		sb.WriteString(" -")
+	}
+
+	sb.WriteString(fmt.Sprintf(" %d %d", pos.HalfmoveClock, pos.FullmoveNumber))
+	return sb.String()
+}
+
+// BoardToPlacement converts a board to the first FEN field (piece placement only).
+func BoardToPlacement(board [8][8]rune) string {
+	var sb strings.Builder
+	for row := 0; row < 8; row++ {
+		empty := 0
+		for col := 0; col < 8; col++ {
+			p := board[row][col]
+			if p == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			sb.WriteRune(p)
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+		if row < 7 {
+			sb.WriteString("/")
+		}
+	}
+	return sb.String()
+}
+
+// parseSquare converts an algebraic square like "e2" to board coordinates.
+func parseSquare(s string) (row, col int, ok bool) {
+	if len(s) != 2 {
+		return 0, 0, false
+	}
+	file := s[0]
+	rank := s[1]
+	if file < 'a' || file > 'h' || rank < '1' || rank > '8' {
+		return 0, 0, false
+	}
+	// internal row 0 is rank 8, row 7 is rank 1
+	return 8 - int(rank-'0'), int(file - 'a'), true
+}
+
+// squareName converts board coordinates to an algebraic square like "e2".
+func squareName(row, col int) string {
+	return string([]byte{byte('a' + col), byte('8' - row)})
+}
@@ -1,9 +1,7 @@
 package handlers
 
 import (
-	"fmt"
-	"sort"
-	"time"
+	"unicode"
 )
 
 type CastlingRights struct {
@@ -23,38 +21,43 @@ type CastlingRights struct {
 type Move struct {
 	FromRow, FromCol int
 	ToRow, ToCol     int
+	// Promotion is the piece a pawn promotes to ('Q', 'R', 'B' or 'N'), or 0.
+	// The colour comes from the pawn, so the letter is always upper case.
+	Promotion rune
 }
 
-// Simple in-engine profiling counters (aggregated across calls).
-var (
-	IsValidMoveTime         time.Duration
-	IsValidMoveCount        int64
-	GenerateAllMovesTime    time.Duration
-	GenerateAllMovesCount   int64
-	GenerateCaptureMovesTime time.Duration
-	GenerateCaptureMovesCount int64
-	FindBestMoveTime        time.Duration
-	FindBestMoveCount       int64
-	MinimaxTime             time.Duration
-	MinimaxCount            int64
-	QuiescenceTime          time.Duration
-	QuiescenceCount         int64
-)
+// promotionPieces lists the pieces a pawn may promote to, best first.
+var promotionPieces = []rune{'Q', 'R', 'B', 'N'}
 
-// ResetProfiling clears all profiling counters; useful between moves.
-func ResetProfiling() {
-	IsValidMoveTime = 0
-	IsValidMoveCount = 0
-	GenerateAllMovesTime = 0
-	GenerateAllMovesCount = 0
-	GenerateCaptureMovesTime = 0
-	GenerateCaptureMovesCount = 0
-	FindBestMoveTime = 0
-	FindBestMoveCount = 0
-	MinimaxTime = 0
-	MinimaxCount = 0
-	QuiescenceTime = 0
-	QuiescenceCount = 0
+// String returns the move in coordinate notation, e.g. "e2e4" or "e7e8n".
+func (m Move) String() string {
+	s := squareName(m.FromRow, m.FromCol) + squareName(m.ToRow, m.ToCol)
+	if m.Promotion != 0 {
+		s += string(unicode.ToLower(m.Promotion))
+	}
+	return s
+}
+
+// ParseMove parses coordinate notation such as "e2e4" or "e7e8n". It only checks
+// the syntax; use IsValidMove to check the move against a position.
+func ParseMove(s string) (Move, bool) {
+	if len(s) != 4 && len(s) != 5 {
+		return Move{}, false
+	}
+	fromRow, fromCol, ok1 := parseSquare(s[0:2])
+	toRow, toCol, ok2 := parseSquare(s[2:4])
+	if !ok1 || !ok2 {
+		return Move{}, false
+	}
+	move := Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
+	if len(s) == 5 {
+		promotion := unicode.ToUpper(rune(s[4]))
+		if promotion != 'Q' && promotion != 'R' && promotion != 'B' && promotion != 'N' {
+			return Move{}, false
+		}
+		move.Promotion = promotion
+	}
+	return move, true
 }
 
 // var initialPositions = map[string]bool{
@@ -91,54 +94,61 @@ func UpdateCastlingRights(board [8][8]rune, fromRow, fromCol int, castlingRights
 	}
 }
 
+// IsSquareUnderAttack reports whether any piece of the attacking colour attacks
+// row/col. It looks outward from the square (pawn, knight and king offsets, then
+// the eight rays) instead of asking every piece on the board.
 func IsSquareUnderAttack(board [8][8]rune, row, col int, attackerIsWhite bool) bool {
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			piece := board[i][j]
-			if piece == 0 || isWhite(piece) != attackerIsWhite {
-				continue
-			}
-			if canAttackSquare(board, piece, i, j, row, col) {
-				return true
-			}
-		}
+	if target := board[row][col]; target != 0 && isWhite(target) == attackerIsWhite {
+		return false
 	}
-	return false
-}
 
-func canAttackSquare(board [8][8]rune, piece rune, fromRow, fromCol, toRow, toCol int) bool {
-	// Bounds check to prevent index out of range errors
-	if toRow < 0 || toRow >= 8 || toCol < 0 || toCol >= 8 {
-		return false
+	pawn, knight, bishop, rook, queen, king := 'p', 'n', 'b', 'r', 'q', 'k'
+	pawnRow := row - 1 // black pawns attack downwards, so they sit one row above
+	if attackerIsWhite {
+		pawn, knight, bishop, rook, queen, king = 'P', 'N', 'B', 'R', 'Q', 'K'
+		pawnRow = row + 1
 	}
-	if fromRow < 0 || fromRow >= 8 || fromCol < 0 || fromCol >= 8 {
-		return false
+
+	if pawnRow >= 0 && pawnRow < 8 {
+		if (col > 0 && board[pawnRow][col-1] == pawn) || (col < 7 && board[pawnRow][col+1] == pawn) {
+			return true
+		}
 	}
-	if board[toRow][toCol] != 0 && isWhite(piece) == isWhite(board[toRow][toCol]) {
-		return false
+	for _, d := range knightOffsets {
+		r, c := row+d[0], col+d[1]
+		if r >= 0 && r < 8 && c >= 0 && c < 8 && board[r][c] == knight {
+			return true
+		}
 	}
-
-	switch piece {
-	case 'P':
-		return abs(fromCol-toCol) == 1 && toRow == fromRow-1
-	case 'p':
-		return abs(fromCol-toCol) == 1 && toRow == fromRow+1
-	case 'R', 'r':
-		return (fromRow == toRow || fromCol == toCol) && clearPath(board, fromRow, fromCol, toRow, toCol)
-	case 'N', 'n':
-		return (abs(fromRow-toRow) == 2 && abs(fromCol-toCol) == 1) ||
-			(abs(fromRow-toRow) == 1 && abs(fromCol-toCol) == 2)
-	case 'B', 'b':
-		return abs(fromRow-toRow) == abs(fromCol-toCol) && clearPath(board, fromRow, fromCol, toRow, toCol)
-	case 'Q', 'q':
-		return (fromRow == toRow || fromCol == toCol || abs(fromRow-toRow) == abs(fromCol-toCol)) &&
-			clearPath(board, fromRow, fromCol, toRow, toCol)
-	case 'K', 'k':
-		return abs(fromRow-toRow) <= 1 && abs(fromCol-toCol) <= 1
+	for _, d := range kingOffsets {
+		r, c := row+d[0], col+d[1]
+		if r >= 0 && r < 8 && c >= 0 && c < 8 && board[r][c] == king {
+			return true
+		}
+	}
+	for i, d := range kingOffsets {
+		slider := rook
+		if i%2 == 0 {
+			slider = bishop
+		}
+		for r, c := row+d[0], col+d[1]; r >= 0 && r < 8 && c >= 0 && c < 8; r, c = r+d[0], c+d[1] {
+			if piece := board[r][c]; piece != 0 {
+				if piece == slider || piece == queen {
+					return true
+				}
+				break
+			}
+		}
 	}
 	return false
 }
 
+var knightOffsets = [8][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+
+// kingOffsets alternates diagonal and orthogonal steps so IsSquareUnderAttack can
+// tell bishop rays (even index) from rook rays (odd index).
+var kingOffsets = [8][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}}
+
 func IsInCheck(board [8][8]rune, isWhiteKing bool, kingRow, kingCol int) bool {
 	// If king is not found, return false (shouldn't happen in valid game states)
 	if kingRow < 0 || kingRow >= 8 || kingCol < 0 || kingCol >= 8 {
@@ -147,7 +157,11 @@ func IsInCheck(board [8][8]rune, isWhiteKing bool, kingRow, kingCol int) bool {
 	return IsSquareUnderAttack(board, kingRow, kingCol, !isWhiteKing)
 }
 
-func IsCastleable(board [8][8]rune, fromRow, fromCol, toRow, toCol int) bool {
+// IsCastleable reports whether the king on fromRow/fromCol may castle to toCol:
+// the side must still hold the matching castling right, the squares between king
+// and rook must be empty, and the king may not be in, pass through or land in check.
+func IsCastleable(pos *Position, fromRow, fromCol, toRow, toCol int) bool {
+	board := &pos.Board
 	piece := board[fromRow][fromCol]
 
 	if (piece != 'K' && piece != 'k') || abs(fromCol-toCol) != 2 || fromRow != toRow {
@@ -158,134 +172,91 @@ func IsCastleable(board [8][8]rune, fromRow, fromCol, toRow, toCol int) bool {
 	row := fromRow
 	isWhiteKing := piece == 'K'
 
-	if IsInCheck(board, isWhiteKing, row, fromCol) {
+	homeRow := 0
+	if isWhiteKing {
+		homeRow = 7
+	}
+	if row != homeRow || fromCol != 4 {
+		return false
+	}
+
+	switch {
+	case isWhiteKing && isKingSide && !pos.Castling.WhiteKingSide,
+		isWhiteKing && !isKingSide && !pos.Castling.WhiteQueenSide,
+		!isWhiteKing && isKingSide && !pos.Castling.BlackKingSide,
+		!isWhiteKing && !isKingSide && !pos.Castling.BlackQueenSide:
+		return false
+	}
+
+	if IsInCheck(*board, isWhiteKing, row, fromCol) {
 		//fmt.Println("King cant be castled when under check ")
 		return false
 	}
 
+	rook := 'r'
+	if isWhiteKing {
+		rook = 'R'
+	}
+	rookCol := 0
+	step := -1
 	if isKingSide {
-		rookCol := 7
-		if (isWhiteKing && board[7][rookCol] != 'R') || (!isWhiteKing && board[0][rookCol] != 'r') {
+		rookCol = 7
+		step = 1
+	}
+	if board[row][rookCol] != rook {
+		return false
+	}
+	for col := fromCol + step; col != rookCol; col += step {
+		if board[row][col] != 0 {
 			return false
 		}
-		for col := fromCol + 1; col < rookCol; col++ {
-			if board[row][col] != 0 || IsSquareUnderAttack(board, row, col, !isWhiteKing) {
-				return false
-			}
-		}
-	} else {
-		rookCol := 0
-		if (isWhiteKing && board[7][rookCol] != 'R') || (!isWhiteKing && board[0][rookCol] != 'r') {
+	}
+	// Only the squares the king crosses must be safe; b1/b8 may be attacked.
+	for col := fromCol + step; col != toCol+step; col += step {
+		if IsSquareUnderAttack(*board, row, col, !isWhiteKing) {
 			return false
 		}
-		for col := fromCol - 1; col > rookCol; col-- {
-			if board[row][col] != 0 || IsSquareUnderAttack(board, row, col, !isWhiteKing) {
-				return false
-			}
-		}
 	}
 	//fmt.Println("Castleable")
 	return true
 }
 
-func IsValidMove(board [8][8]rune, piece rune, fromRow, fromCol, toRow, toCol int, promotionPiece *rune) bool {
-	start := time.Now()
-	defer func() {
-		IsValidMoveTime += time.Since(start)
-		IsValidMoveCount++
-	}()
-
+// IsValidMove reports whether the side to move in pos may legally play the piece on
+// fromRow/fromCol to toRow/toCol. promotionPiece may be nil, in which case a pawn
+// reaching the last rank is assumed to promote to a queen; otherwise it must be one
+// of Q, R, B or N in either case, and the move must be a pawn reaching the last rank.
+func IsValidMove(pos *Position, fromRow, fromCol, toRow, toCol int, promotionPiece *rune) bool {
 	if toRow < 0 || toRow >= 8 || toCol < 0 || toCol >= 8 {
 		return false
 	}
-
-	if board[toRow][toCol] != 0 {
-		if isWhite(piece) == isWhite(board[toRow][toCol]) {
-			return false
-		}
-	}
-	validPieceMove := false
-	switch piece {
-	case 'P':
-		if fromCol == toCol && board[toRow][toCol] == 0 {
-			if toRow == fromRow-1 || (fromRow == 6 && toRow == 4 && board[5][toCol] == 0) {
-				validPieceMove = handlePawnPromotion(toRow, 'Q', true)
-			}
-		} else if abs(fromCol-toCol) == 1 && toRow == fromRow-1 && !isWhite(board[toRow][toCol]) && board[toRow][toCol] != 0 {
-			validPieceMove = handlePawnPromotion(toRow, 'Q', true)
-		}
-	case 'p':
-		if fromCol == toCol && board[toRow][toCol] == 0 {
-			if toRow == fromRow+1 || (fromRow == 1 && toRow == 3 && board[2][toCol] == 0) {
-				validPieceMove = handlePawnPromotion(toRow, 'q', false)
-			}
-		} else if abs(fromCol-toCol) == 1 && toRow == fromRow+1 && isWhite(board[toRow][toCol]) {
-			validPieceMove = handlePawnPromotion(toRow, 'q', false)
-		}
-	case 'R', 'r':
-		if fromRow == toRow || fromCol == toCol {
-			validPieceMove = clearPath(board, fromRow, fromCol, toRow, toCol)
-		}
-	case 'N', 'n':
-		if (abs(fromRow-toRow) == 2 && abs(fromCol-toCol) == 1) || (abs(fromRow-toRow) == 1 && abs(fromCol-toCol) == 2) {
-			validPieceMove = true
-		}
-	case 'B', 'b':
-		if abs(fromRow-toRow) == abs(fromCol-toCol) {
-			validPieceMove = clearPath(board, fromRow, fromCol, toRow, toCol)
-		}
-	case 'Q', 'q':
-		if fromRow == toRow || fromCol == toCol || abs(fromRow-toRow) == abs(fromCol-toCol) {
-			validPieceMove = clearPath(board, fromRow, fromCol, toRow, toCol)
-		}
-	case 'K', 'k':
-		if IsSquareUnderAttack(board, toRow, toCol, !isWhite(piece)) {
-			return false
-		}
-		if abs(fromRow-toRow) <= 1 && abs(fromCol-toCol) <= 1 {
-			validPieceMove = true
-		} else if abs(fromCol-toCol) == 2 && fromRow == toRow {
-			validPieceMove = IsCastleable(board, fromRow, fromCol, toRow, toCol)
-		}
-	}
-	if !validPieceMove {
+	if fromRow < 0 || fromRow >= 8 || fromCol < 0 || fromCol >= 8 {
 		return false
 	}
-	kingRow, kingCol := -1, -1
-	kingPiece := 'k'
-	if isWhite(piece) {
-		kingPiece = 'K'
-	}
-
-	var tempBoard [8][8]rune
-	for r := 0; r < 8; r++ {
-		for c := 0; c < 8; c++ {
-			tempBoard[r][c] = board[r][c]
-			if board[r][c] == kingPiece {
-				kingRow = r
-				kingCol = c
-			}
-		}
-	}
 
-	tempBoard[fromRow][fromCol] = 0
-	tempBoard[toRow][toCol] = piece
-
-	if piece == 'K' || piece == 'k' {
-		kingRow = toRow
-		kingCol = toCol
+	piece := pos.Board[fromRow][fromCol]
+	if piece == 0 || isWhite(piece) != pos.WhiteToMove {
+		return false
 	}
-
-	if kingRow == -1 || kingCol == -1 {
+	if promotionPiece != nil &&
+		(!isPromotionMove(piece, toRow) || !handlePawnPromotion(toRow, *promotionPiece, isWhite(piece))) {
 		return false
 	}
 
-	if IsInCheck(tempBoard, isWhite(piece), kingRow, kingCol) {
-		return false
+	for _, target := range getPossibleMoves(piece, fromRow, fromCol, pos, genAll) {
+		if target[0] != toRow || target[1] != toCol {
+			continue
+		}
+		move := Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
+		return !leavesKingInCheck(pos, move)
 	}
+	return false
+}
 
-	return true
+// isPromotionMove reports whether piece moving to toRow is a pawn reaching the last rank.
+func isPromotionMove(piece rune, toRow int) bool {
+	return (piece == 'P' && toRow == 0) || (piece == 'p' && toRow == 7)
 }
+
 func handlePawnPromotion(toRow int, promotionPiece rune, isWhite bool) bool {
 	if (isWhite && toRow == 0) || (!isWhite && toRow == 7) {
 		if promotionPiece == 'Q' || promotionPiece == 'R' || promotionPiece == 'B' || promotionPiece == 'N' || promotionPiece == 'q' || promotionPiece == 'r' || promotionPiece == 'b' || promotionPiece == 'n' {
@@ -298,29 +269,6 @@ func handlePawnPromotion(toRow int, promotionPiece rune, isWhite bool) bool {
 	return true
 }
 
-func clearPath(board [8][8]rune, fromRow, fromCol, toRow, toCol int) bool {
-	rowStep := sign(toRow - fromRow)
-	colStep := sign(toCol - fromCol)
-
-	row, col := fromRow+rowStep, fromCol+colStep
-	for row != toRow || col != toCol {
-		if board[row][col] != 0 {
-			return false
-		}
-		row += rowStep
-		col += colStep
-	}
-	return true
-}
-func sign(x int) int {
-	if x < 0 {
-		return -1
-	} else if x > 0 {
-		return 1
-	}
-	return 0
-}
-
 func isWhite(piece rune) bool {
 	return piece == 'P' || piece == 'N' || piece == 'B' || piece == 'R' || piece == 'Q' || piece == 'K'
 }
@@ -332,57 +280,10 @@ func abs(x int) int {
 	return x
 }
 
-func score_move(move Move, board [8][8]rune) int {
-	startRow := move.FromRow
-	startCol := move.FromCol
-	endRow := move.ToRow
-	endCol := move.ToCol
-
-	current_piece := board[startRow][startCol]
-	next_piece := board[endRow][endCol]
-
-	score := 0
-
-	if next_piece != 0 {
-		score = abs(GetValue(next_piece)) - abs(GetValue(current_piece)/10)
-		score = score + 10000
-	}
-	var tempBoard [8][8]rune
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			tempBoard[i][j] = board[i][j]
-		}
-	}
-	prev := Evaluate_board(tempBoard)
-	tempBoard[endRow][endCol] = current_piece
-	tempBoard[startRow][startCol] = 0
-	after := Evaluate_board(tempBoard)
-	//black promition and position changes
-	if !isWhite(current_piece) {
-		if startRow == 6 && endRow == 7 {
-			score += 800
-		}
-		if after-prev < 0 {
-			score += abs(after - prev)
-		} else {
-			score -= abs(after - prev)
-		}
-	}
-	//white promotion and psoition changes
-	if isWhite(current_piece) {
-		if startRow == 1 && endRow == 0 {
-			score += 800
-		}
-		score += after - prev
-	}
-	return score
-
-}
-
 func findKing(board [8][8]rune, isWhite bool) (int, int) {
-	kingToFind:='K'
+	kingToFind := 'K'
 	if !isWhite {
-		kingToFind='k'
+		kingToFind = 'k'
 	}
 	for i := 0; i < 8; i++ {
 		for j := 0; j < 8; j++ {
@@ -390,87 +291,101 @@ func findKing(board [8][8]rune, isWhite bool) (int, int) {
 				return i, j
 			}
 		}
-	}	
+	}
 	return -1, -1
 }
 
-func GenereateAllMoves(board [8][8]rune, isWhiteTurn bool) []Move {
-	start := time.Now()
-	defer func() {
-		GenerateAllMovesTime += time.Since(start)
-		GenerateAllMovesCount++
-	}()
+// leavesKingInCheck plays move on pos and reports whether the mover's own king is
+// attacked afterwards. The position is restored before returning. Because the move
+// is really played, this also catches an en-passant capture that removes both pawns
+// from the king's rank and exposes it to a rook or queen.
+func leavesKingInCheck(pos *Position, move Move) bool {
+	mover := pos.WhiteToMove
+	undo := pos.MakeMove(move)
+	kingRow, kingCol := findKing(pos.Board, mover)
+	inCheck := IsInCheck(pos.Board, mover, kingRow, kingCol)
+	pos.UnmakeMove(move, undo)
+	return inCheck
+}
 
+// GenereateAllMoves returns every legal move for the side to move: captures and
+// promotions first by noisyScore, then quiet moves by their gain on the
+// piece-square tables. Each move is scored once.
+func GenereateAllMoves(pos *Position) []Move {
+	noisy := generateMoves(pos, genNoisy)
+	scores := make([]int, len(noisy))
+	for i, move := range noisy {
+		scores[i] = noisyScore(pos, move)
+	}
+	sortByScore(noisy, scores)
+
+	quiet := generateMoves(pos, genQuiet)
+	scores = make([]int, len(quiet))
+	for i, move := range quiet {
+		scores[i] = squareGain(pos, move)
+	}
+	sortByScore(quiet, scores)
+	return append(noisy, quiet...)
+}
+
+// Kinds of moves generateMoves and getPossibleMoves can restrict themselves to. Noisy moves capture or
+// promote; quiet moves do neither.
+type moveKind int
+
+const (
+	genAll moveKind = iota
+	genNoisy
+	genQuiet
+)
+
+// generateLegalMoves returns every legal move for the side to move, unordered.
+func generateLegalMoves(pos *Position) []Move {
+	return generateMoves(pos, genAll)
+}
+
+// generateMoves returns the legal moves of the given kind for the side to move,
+// unordered.
+func generateMoves(pos *Position, kind moveKind) []Move {
 	var legalMoves []Move
 	for fromRow := 0; fromRow < 8; fromRow++ {
 		for fromCol := 0; fromCol < 8; fromCol++ {
-			piece := board[fromRow][fromCol]
+			piece := pos.Board[fromRow][fromCol]
 
-			if piece == 0 {
-				continue
-			}
-			if isWhiteTurn && !isWhite(piece) {
-				continue
-			}
-			if !isWhiteTurn && isWhite(piece) {
+			if piece == 0 || isWhite(piece) != pos.WhiteToMove {
 				continue
 			}
 
-			possibleMoves := getPossibleMoves(piece, fromRow, fromCol,board)
-			for _,pos:=range possibleMoves{
-				// Bounds check to prevent index out of range errors
-				if pos[0] < 0 || pos[0] >= 8 || pos[1] < 0 || pos[1] >= 8 {
+			for _, target := range getPossibleMoves(piece, fromRow, fromCol, pos, kind) {
+				move := Move{FromRow: fromRow, FromCol: fromCol, ToRow: target[0], ToCol: target[1]}
+				if leavesKingInCheck(pos, move) {
 					continue
 				}
-				var tempBoard [8][8]rune
-				for i:=0;i<8;i++{
-					for j:=0;j<8;j++{
-						tempBoard[i][j]=board[i][j]
+				if isPromotionMove(piece, target[0]) {
+					for _, promotion := range promotionPieces {
+						move.Promotion = promotion
+						legalMoves = append(legalMoves, move)
 					}
+					continue
 				}
-				tempBoard[pos[0]][pos[1]]=piece
-				tempBoard[fromRow][fromCol]=0				
-	
-				var kingRow, kingCol int
-				if piece == 'K' || piece == 'k' {
-					kingRow = pos[0]
-					kingCol = pos[1]
-				} else {
-					kingRow, kingCol = findKing(board, isWhiteTurn)
-				}
-				
-				if !IsInCheck(tempBoard, isWhiteTurn, kingRow, kingCol){
-					legalMoves = append(legalMoves, Move{FromRow: fromRow, FromCol: fromCol, ToRow: pos[0], ToCol: pos[1]})
-				}
+				legalMoves = append(legalMoves, move)
 			}
-			// for _, pos := range possibleMoves {
-			// 	toRow, toCol := pos[0], pos[1]
-			// 	if IsValidMove(board, piece, fromRow, fromCol, toRow, toCol, nil) {
-			// 		legalMoves = append(legalMoves, Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol})
-			// 	}
-			// }
-			// for toRow := 0; toRow < 8; toRow++ {
-			// 	for toCol := 0; toCol < 8; toCol++ {
-			// 		if IsValidMove(board, piece, fromRow, fromCol, toRow, toCol, nil) {
-			// 			newMove := Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
-			// 			legalMoves = append(legalMoves, newMove)
-			// 		}
-			// 	}
-			// }
 		}
 	}
-
-	sort.Slice(legalMoves, func(i, j int) bool {
-		score_i := score_move(legalMoves[i], board)
-		score_j := score_move(legalMoves[j], board)
-		return score_i > score_j
-	})
-
 	return legalMoves
 }
 
-func getPossibleMoves(piece rune, fromRow, fromCol int, board [8][8]rune) [][2]int {
+// getPossibleMoves returns the squares piece on fromRow/fromCol can move to
+// without regard to its own king's safety, restricted to moves of the given
+// kind: genNoisy skips quiet moves and castling without generating them, and
+// genQuiet skips captures and promotions.
+func getPossibleMoves(piece rune, fromRow, fromCol int, pos *Position, kind moveKind) [][2]int {
+	board := &pos.Board
 	var moves [][2]int
+	add := func(r, c int, noisy bool) {
+		if kind == genAll || noisy == (kind == genNoisy) {
+			moves = append(moves, [2]int{r, c})
+		}
+	}
 
 	switch piece {
 	case 'N', 'n':
@@ -479,7 +394,7 @@ func getPossibleMoves(piece rune, fromRow, fromCol int, board [8][8]rune) [][2]i
 			r, c := fromRow+d[0], fromCol+d[1]
 			if r >= 0 && r < 8 && c >= 0 && c < 8 {
 				if board[r][c] == 0 || isWhite(piece) != isWhite(board[r][c]) {
-					moves = append(moves, [2]int{r, c})
+					add(r, c, board[r][c] != 0)
 				}
 			}
 		}
@@ -495,500 +410,101 @@ func getPossibleMoves(piece rune, fromRow, fromCol int, board [8][8]rune) [][2]i
 				r, c := fromRow+dr, fromCol+dc
 				if r >= 0 && r < 8 && c >= 0 && c < 8 {
 					if board[r][c] == 0 || isWhite(piece) != isWhite(board[r][c]) {
-						moves = append(moves, [2]int{r, c})
+						add(r, c, board[r][c] != 0)
 					}
 				}
 			}
 		}
-		if fromCol+2 < 8 && IsCastleable(board, fromRow, fromCol, fromRow, fromCol+2) {
+		if kind == genNoisy {
+			break
+		}
+		if fromCol+2 < 8 && IsCastleable(pos, fromRow, fromCol, fromRow, fromCol+2) {
 			moves = append(moves, [2]int{fromRow, fromCol + 2})
 		}
 		// Queen-side
-		if fromCol-2 >= 0 && IsCastleable(board, fromRow, fromCol, fromRow, fromCol-2) {
+		if fromCol-2 >= 0 && IsCastleable(pos, fromRow, fromCol, fromRow, fromCol-2) {
 			moves = append(moves, [2]int{fromRow, fromCol - 2})
 		}
 
 	case 'P':
-		if fromRow > 0 && board[fromRow - 1][fromCol] == 0 {
-			moves = append(moves, [2]int{fromRow - 1, fromCol})
+		if fromRow > 0 && board[fromRow-1][fromCol] == 0 {
+			add(fromRow-1, fromCol, fromRow-1 == 0)
 		}
 		if fromRow == 6 && board[4][fromCol] == 0 && board[5][fromCol] == 0 {
-			moves = append(moves, [2]int{4, fromCol})
+			add(4, fromCol, false)
+		}
+		if fromRow > 0 && fromCol > 0 && board[fromRow-1][fromCol-1] != 0 && isWhite(piece) != isWhite(board[fromRow-1][fromCol-1]) {
+			add(fromRow-1, fromCol-1, true)
 		}
-		if fromRow > 0 && fromCol > 0 && isWhite(piece) != isWhite(board[fromRow - 1][fromCol - 1]) {
-			moves = append(moves, [2]int{fromRow - 1, fromCol - 1})
+		if fromRow > 0 && fromCol < 7 && board[fromRow-1][fromCol+1] != 0 && isWhite(piece) != isWhite(board[fromRow-1][fromCol+1]) {
+			add(fromRow-1, fromCol+1, true)
 		}
-		if fromRow > 0 && fromCol < 7 && isWhite(piece) != isWhite(board[fromRow - 1][fromCol + 1]) {
-			moves = append(moves, [2]int{fromRow - 1, fromCol + 1})
+		// En passant: capture onto the empty target square behind the pawn that just double-pushed.
+		if fromRow == 3 && pos.EnPassantRow == 2 && abs(pos.EnPassantCol-fromCol) == 1 &&
+			board[fromRow][pos.EnPassantCol] == 'p' {
+			add(2, pos.EnPassantCol, true)
 		}
 	case 'p':
-		if fromRow < 7 && board[fromRow + 1][fromCol] == 0 {
-			moves = append(moves, [2]int{fromRow + 1, fromCol})
+		if fromRow < 7 && board[fromRow+1][fromCol] == 0 {
+			add(fromRow+1, fromCol, fromRow+1 == 7)
 		}
 		if fromRow == 1 && board[3][fromCol] == 0 && board[2][fromCol] == 0 {
-			moves = append(moves, [2]int{3, fromCol})
+			add(3, fromCol, false)
 		}
-		if fromRow < 7 && fromCol > 0 && isWhite(piece) != isWhite(board[fromRow + 1][fromCol - 1]) {
-			moves = append(moves, [2]int{fromRow + 1, fromCol - 1})
+		if fromRow < 7 && fromCol > 0 && board[fromRow+1][fromCol-1] != 0 && isWhite(piece) != isWhite(board[fromRow+1][fromCol-1]) {
+			add(fromRow+1, fromCol-1, true)
 		}
-		if fromRow < 7 && fromCol < 7 && isWhite(piece) != isWhite(board[fromRow + 1][fromCol + 1]) {
-			moves = append(moves, [2]int{fromRow + 1, fromCol + 1})
+		if fromRow < 7 && fromCol < 7 && board[fromRow+1][fromCol+1] != 0 && isWhite(piece) != isWhite(board[fromRow+1][fromCol+1]) {
+			add(fromRow+1, fromCol+1, true)
 		}
-	case 'R', 'r':
-		directions:=[][2]int{{-1,0},{1,0},{0,-1},{0,1}}
-		for _,d := range directions {
-			for i:=1;i<8;i++{
-				toRow,toCol:=fromRow+d[0]*i,fromCol+d[1]*i
-				if toRow<0 || toRow>=8 || toCol<0 || toCol>=8 {
-					break
-				}
-				if board[toRow][toCol]!=0{
-					if isWhite(piece) != isWhite(board[toRow][toCol]) {
-						moves = append(moves, [2]int{toRow, toCol})
-					}
-					break
-				}
-				moves = append(moves, [2]int{toRow, toCol})
-			}
+		if fromRow == 4 && pos.EnPassantRow == 5 && abs(pos.EnPassantCol-fromCol) == 1 &&
+			board[fromRow][pos.EnPassantCol] == 'P' {
+			add(5, pos.EnPassantCol, true)
 		}
+	case 'R', 'r':
+		moves = slide(moves, board, piece, fromRow, fromCol, kind, [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}})
 	case 'B', 'b':
-		directions:=[][2]int{{-1,-1},{-1,1},{1,-1},{1,1}}
-		for _,d := range directions {
-			for i:=1;i<8;i++{
-				toRow,toCol:=fromRow+d[0]*i,fromCol+d[1]*i
-				if toRow<0 || toRow>=8 || toCol<0 || toCol>=8 {
-					break
-				}
-				if board[toRow][toCol]!=0{
-					if isWhite(piece) != isWhite(board[toRow][toCol]) {
-						moves = append(moves, [2]int{toRow, toCol})
-					}
-					break
-				}
-				moves = append(moves, [2]int{toRow, toCol})
-			}
-		}
+		moves = slide(moves, board, piece, fromRow, fromCol, kind, [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}})
 	case 'Q', 'q':
-		directions:=[][2]int{{-1,0},{1,0},{0,-1},{0,1},{-1,-1},{-1,1},{1,-1},{1,1}}
-		for _,d := range directions {
-			for i:=1;i<8;i++{
-				toRow,toCol:=fromRow+d[0]*i,fromCol+d[1]*i
-				if toRow<0 || toRow>=8 || toCol<0 || toCol>=8 {
-					break
-				}
-				if board[toRow][toCol]!=0{
-					if isWhite(piece) != isWhite(board[toRow][toCol]) {
-						moves = append(moves, [2]int{toRow, toCol})
-					}
-					break
-				}
-				moves = append(moves, [2]int{toRow, toCol})
-			}
-		}
+		moves = slide(moves, board, piece, fromRow, fromCol, kind, [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {-1, 1}, {1, -1}, {1, 1}})
 	}
 	return moves
 }
 
-func GenerateCaptureMoves(board [8][8]rune, isWhiteTurn bool) []Move {
-	start := time.Now()
-	defer func() {
-		GenerateCaptureMovesTime += time.Since(start)
-		GenerateCaptureMovesCount++
-	}()
-
-	allMoves := GenereateAllMoves(board, isWhiteTurn)
-	var capturemoves []Move
-	for _, move := range allMoves {
-		isCapture := board[move.ToRow][move.ToCol] != 0
-		piece := board[move.FromRow][move.FromCol]
-		isPawn := piece == 'p' || piece == 'P'
-		isPromotion := isPawn && (move.ToRow == 0 || move.ToRow == 7)
-		if isPromotion || isCapture {
-			capturemoves = append(capturemoves, move)
-		}
-	}
-	return capturemoves
-}
-
-func FindBestMove(board [8][8]rune, isWhiteTurn bool) Move {
-	start := time.Now()
-	defer func() {
-		FindBestMoveTime += time.Since(start)
-		FindBestMoveCount++
-	}()
-
-	allMoves := GenereateAllMoves(board, isWhiteTurn)
-	if len(allMoves) == 0 {
-		fmt.Println("U have lost MINIMAX")
-		return Move{}
-	}
-
-	initial_hash := GetZobristValue(board)
-	index := initial_hash & (ttSize - 1)
-	entry := &transpositionTable[index]
-	if entry.HashKey == initial_hash && entry.Depth >= 3 {
-		//fmt.Println("hash found in the database using it ")
-		return transpositionTable[index].BestMove
-	}
-
-	// Aspiration Search with Iterative Deepening
-	const targetDepth = 3 
-	const aspirationWindow = 25 
-	const infinity = 100000
-	const negInfinity = -100000
-
-	var bestMove Move = allMoves[0]
-	var bestScore int
-	var previousScore int = 0 
-	
-	for depth := 1; depth <= targetDepth; depth++ {
-		var alpha, beta int
-		var score int
-		
-		if depth > 1 {
-			alpha = previousScore - aspirationWindow
-			beta = previousScore + aspirationWindow
-			
-			score, bestMove = searchWithAspiration(board, isWhiteTurn, depth, alpha, beta, initial_hash, allMoves, previousScore)
-			
-			if score <= alpha {
-				alpha = negInfinity
-				beta = previousScore + aspirationWindow
-				score, bestMove = searchWithAspiration(board, isWhiteTurn, depth, alpha, beta, initial_hash, allMoves, previousScore)
-			} else if score >= beta {
-				alpha = previousScore - aspirationWindow
-				beta = infinity
-				score, bestMove = searchWithAspiration(board, isWhiteTurn, depth, alpha, beta, initial_hash, allMoves, previousScore)
-			}
-		} else {
-			alpha = negInfinity
-			beta = infinity
-			score, bestMove = searchWithAspiration(board, isWhiteTurn, depth, alpha, beta, initial_hash, allMoves, 0)
-		}
-		
-		bestScore = score
-		previousScore = score
-		
-		learnedInfo := HashMap{
-			HashKey:  initial_hash,
-			Score:    bestScore,
-			Depth:    depth,
-			BestMove: bestMove,
-		}
-		transpositionTable[index] = learnedInfo
-	}
-
-	return bestMove
-}
-
-func searchWithAspiration(board [8][8]rune, isWhiteTurn bool, depth int, alpha, beta int, initial_hash uint64, allMoves []Move, previousScore int) (int, Move) {
-	const infinity = 100000
-	const negInfinity = -100000
-	
-	var bestMove Move = allMoves[0]
-	var bestScore int
-	
-	if isWhiteTurn {
-		bestScore = negInfinity
-	} else {
-		bestScore = infinity
-	}
-
-	for _, move := range allMoves {
-		var tempBoard [8][8]rune
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 8; j++ {
-				tempBoard[i][j] = board[i][j]
-			}
-		}
-		piece := tempBoard[move.FromRow][move.FromCol]
-		tempBoard[move.ToRow][move.ToCol] = piece
-		tempBoard[move.FromRow][move.FromCol] = 0
-		new_hash := UpdateHashForMove(initial_hash, move, board)
-		
-		score := Minimax(tempBoard, depth, !isWhiteTurn, alpha, beta, new_hash)
-
-		if isWhiteTurn {
-			if score > bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score > alpha {
-				alpha = score
-			}
-			if alpha >= beta {
-				break // Beta cutoff
-			}
-		} else {
-			if score < bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score < beta {
-				beta = score
-			}
-			if alpha >= beta {
-				break // Alpha cutoff
-			}
-		}
-	}
-
-	return bestScore, bestMove
-}
-
-
-func SearchSpecificMoves(board [8][8]rune, isWhiteTurn bool, movesToSearch []Move) (Move, int) {
-	if len(movesToSearch) == 0 {
-		return Move{}, 0
-	}
-
-	const targetDepth = 3
-	const aspirationWindow = 25
-	const infinity = 100000
-	const negInfinity = -100000
-
-	initial_hash := GetZobristValue(board)
-	var bestMove Move = movesToSearch[0]
-	var bestScore int
-	var previousScore int = 0
-
-	// Iterative deepening for this subset
-	for depth := 1; depth <= targetDepth; depth++ {
-		var alpha, beta int
-		var score int
-
-		if depth > 1 {
-			alpha = previousScore - aspirationWindow
-			beta = previousScore + aspirationWindow
-
-			score, bestMove = searchMovesSubset(board, isWhiteTurn, depth, alpha, beta, initial_hash, movesToSearch, previousScore)
-
-			if score <= alpha {
-				alpha = negInfinity
-				beta = previousScore + aspirationWindow
-				score, bestMove = searchMovesSubset(board, isWhiteTurn, depth, alpha, beta, initial_hash, movesToSearch, previousScore)
-			} else if score >= beta {
-				alpha = previousScore - aspirationWindow
-				beta = infinity
-				score, bestMove = searchMovesSubset(board, isWhiteTurn, depth, alpha, beta, initial_hash, movesToSearch, previousScore)
-			}
-		} else {
-			alpha = negInfinity
-			beta = infinity
-			score, bestMove = searchMovesSubset(board, isWhiteTurn, depth, alpha, beta, initial_hash, movesToSearch, 0)
-		}
-
-		bestScore = score
-		previousScore = score
-	}
-
-	return bestMove, bestScore
-}
-
-// searchMovesSubset searches only the provided moves subset
-func searchMovesSubset(board [8][8]rune, isWhiteTurn bool, depth int, alpha, beta int, initial_hash uint64, movesToSearch []Move, previousScore int) (int, Move) {
-	const infinity = 100000
-	const negInfinity = -100000
-
-	var bestMove Move = movesToSearch[0]
-	var bestScore int
-
-	if isWhiteTurn {
-		bestScore = negInfinity
-	} else {
-		bestScore = infinity
-	}
-
-	for _, move := range movesToSearch {
-		var tempBoard [8][8]rune
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 8; j++ {
-				tempBoard[i][j] = board[i][j]
-			}
-		}
-		piece := tempBoard[move.FromRow][move.FromCol]
-		tempBoard[move.ToRow][move.ToCol] = piece
-		tempBoard[move.FromRow][move.FromCol] = 0
-		new_hash := UpdateHashForMove(initial_hash, move, board)
-
-		score := Minimax(tempBoard, depth, !isWhiteTurn, alpha, beta, new_hash)
-
-		if isWhiteTurn {
-			if score > bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score > alpha {
-				alpha = score
-			}
-			if alpha >= beta {
-				break // Beta cutoff
-			}
-		} else {
-			if score < bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score < beta {
-				beta = score
-			}
-			if alpha >= beta {
-				break // Alpha cutoff
-			}
-		}
-	}
-
-	return bestScore, bestMove
-}
-
-func QuiescenceSearch(board [8][8]rune, isWhiteTurn bool, alpha, beta int) int {
-	start := time.Now()
-	defer func() {
-		QuiescenceTime += time.Since(start)
-		QuiescenceCount++
-	}()
-	base_score := Evaluate_board(board)
-	if isWhiteTurn {
-		if base_score >= beta {
-			return beta
-		}
-		if base_score > alpha {
-			alpha = base_score
-		}
-	} else {
-		if base_score <= alpha {
-			return alpha
-		}
-		if base_score < beta {
-			beta = base_score
-		}
-	}
-	capture_move := GenerateCaptureMoves(board, isWhiteTurn)
-	sort.Slice(capture_move, func(i,j int) bool {
-		score_i:=score_move(capture_move[i],board)
-		score_j:=score_move(capture_move[j],board)
-		return score_i>score_j
-	})
-
-	for _, move := range capture_move {
-		var tempBoard [8][8]rune
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 8; j++ {
-				tempBoard[i][j] = board[i][j]
-			}
-		}
-
-		piece := tempBoard[move.FromRow][move.FromCol]
-		tempBoard[move.ToRow][move.ToCol] = piece
-		tempBoard[move.FromRow][move.FromCol] = 0
-		score := QuiescenceSearch(tempBoard, !isWhiteTurn, alpha, beta)
-		if isWhiteTurn {
-			if score > alpha {
-				alpha = score
-			} else {
-				if score < beta {
-					beta = score
-				}
-			}
-			if alpha >= beta {
+// slide appends to moves the squares a rook, bishop or queen on fromRow/fromCol
+// reaches along directions, up to and including an enemy piece, restricted to
+// moves of the given kind.
+func slide(moves [][2]int, board *[8][8]rune, piece rune, fromRow, fromCol int, kind moveKind, directions [][2]int) [][2]int {
+	for _, d := range directions {
+		for i := 1; i < 8; i++ {
+			toRow, toCol := fromRow+d[0]*i, fromCol+d[1]*i
+			if toRow < 0 || toRow >= 8 || toCol < 0 || toCol >= 8 {
 				break
 			}
-		}
-	}
-
-	if isWhiteTurn {
-		return alpha
-	} else {
-		return beta
-	}
-}
-
-func Minimax(board [8][8]rune, depth int, isWhiteTurn bool, alpha int, beta int, current_hash uint64) int {
-	start := time.Now()
-	defer func() {
-		MinimaxTime += time.Since(start)
-		MinimaxCount++
-	}()
-
-	index := current_hash & (ttSize - 1)
-	entry := &transpositionTable[index]
-
-	if entry.HashKey == current_hash && entry.Depth >= depth {
-		return entry.Score
-	}
-
-	if depth == 0 {
-		return QuiescenceSearch(board, isWhiteTurn, alpha, beta)
-	}
-
-	allMoves := GenereateAllMoves(board, isWhiteTurn)
-	if len(allMoves) == 0 {
-		return -99999
-	}
-
-	var bestMove Move
-	var bestScore int
-
-	if isWhiteTurn {
-		bestScore = -100000
-		for _, move := range allMoves {
-			var tempBoard [8][8]rune
-			for i := 0; i < 8; i++ {
-				for j := 0; j < 8; j++ {
-					tempBoard[i][j] = board[i][j]
+			if board[toRow][toCol] != 0 {
+				if isWhite(piece) != isWhite(board[toRow][toCol]) && kind != genQuiet {
+					moves = append(moves, [2]int{toRow, toCol})
 				}
-			}
-
-			new_hash := UpdateHashForMove(current_hash, move, board)
-			makeMove(&tempBoard, move)
-
-			score := Minimax(tempBoard, depth-1, !isWhiteTurn, alpha, beta, new_hash)
-
-			if score > bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score > alpha {
-				alpha = score
-			}
-			if alpha >= beta {
 				break
 			}
-		}
-	} else {
-		bestScore = 100000
-		for _, move := range allMoves {
-			var tempBoard [8][8]rune
-			for i := 0; i < 8; i++ {
-				for j := 0; j < 8; j++ {
-					tempBoard[i][j] = board[i][j]
-				}
-			}
-
-			new_hash := UpdateHashForMove(current_hash, move, board)
-			makeMove(&tempBoard, move)
-
-			score := Minimax(tempBoard, depth-1, !isWhiteTurn, alpha, beta, new_hash)
-
-			if score < bestScore {
-				bestScore = score
-				bestMove = move
-			}
-			if score < beta {
-				beta = score
-			}
-			if alpha >= beta {
-				break
+			if kind != genNoisy {
+				moves = append(moves, [2]int{toRow, toCol})
 			}
 		}
 	}
+	return moves
+}
 
-	entry.HashKey = current_hash
-	entry.Score = bestScore
-	entry.Depth = depth
-	entry.BestMove = bestMove
-
-	return bestScore
+// GenerateCaptureMoves returns the legal captures and promotions for the side to
+// move, best first by noisyScore. Quiet moves are never generated.
+func GenerateCaptureMoves(pos *Position) []Move {
+	capturemoves := generateMoves(pos, genNoisy)
+	scores := make([]int, len(capturemoves))
+	for i, move := range capturemoves {
+		scores[i] = noisyScore(pos, move)
+	}
+	sortByScore(capturemoves, scores)
+	return capturemoves
 }
 
 // func max(a, b int) int {
@@ -1004,9 +520,3 @@ func Minimax(board [8][8]rune, depth int, isWhiteTurn bool, alpha int, beta int,
 // 	}
 // 	return b
 // }
-
-func makeMove(tempBoard *[8][8]rune, move Move) {
-	piece := (*tempBoard)[move.FromRow][move.FromCol]
-	(*tempBoard)[move.ToRow][move.ToCol] = piece
-	(*tempBoard)[move.FromRow][move.FromCol] = 0
-}
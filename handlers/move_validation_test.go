@@ -0,0 +1,31 @@
+package handlers
+
+import "testing"
+
+func TestIsValidMovePromotion(t *testing.T) {
+	pos, _ := ParseFEN("8/4P3/8/8/8/8/4P3/4K2k w - - 0 1")
+	tests := []struct {
+		move      string
+		promotion rune // 0 for none
+		want      bool
+	}{
+		{"e7e8", 0, true},
+		{"e7e8", 'N', true},
+		{"e7e8", 'n', true},
+		{"e7e8", 'K', false},
+		{"e2e4", 0, true},
+		// Only a pawn reaching the last rank can promote.
+		{"e2e4", 'Q', false},
+		{"e1d1", 'Q', false},
+	}
+	for _, tc := range tests {
+		move, _ := ParseMove(tc.move)
+		var promotion *rune
+		if tc.promotion != 0 {
+			promotion = &tc.promotion
+		}
+		if got := IsValidMove(&pos, move.FromRow, move.FromCol, move.ToRow, move.ToCol, promotion); got != tc.want {
+			t.Errorf("IsValidMove(%s, %q) = %v, want %v", tc.move, tc.promotion, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import "sort"
+
+// pieceRank orders the pieces by value for MVV-LVA, pawn lowest.
+func pieceRank(piece rune) int {
+	switch piece {
+	case 'P', 'p':
+		return 1
+	case 'N', 'n':
+		return 2
+	case 'B', 'b':
+		return 3
+	case 'R', 'r':
+		return 4
+	case 'Q', 'q':
+		return 5
+	case 'K', 'k':
+		return 6
+	}
+	return 0
+}
+
+// noisyScore orders captures and promotions: most valuable victim first and,
+// among equal victims, least valuable attacker first (MVV-LVA). Promoting to a
+// queen counts like capturing one; under-promotions come after all captures.
+func noisyScore(pos *Position, move Move) int {
+	victim := pos.Board[move.ToRow][move.ToCol]
+	if pos.isEnPassantCapture(move) {
+		victim = 'p'
+	}
+	score := 0
+	if victim != 0 {
+		score = 8*pieceRank(victim) - pieceRank(pos.Board[move.FromRow][move.FromCol])
+	}
+	switch move.Promotion {
+	case 0:
+	case 'Q':
+		score += 8 * pieceRank('Q')
+	default:
+		score -= 8*pieceRank('K') - pieceRank(move.Promotion)
+	}
+	return score
+}
+
+// squareGain is what a quiet move gains the side to move on the piece-square
+// tables.
+func squareGain(pos *Position, move Move) int {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	return relativeScore(pos, pieceSquare(piece, move.ToRow, move.ToCol)-pieceSquare(piece, move.FromRow, move.FromCol))
+}
+
+// quietScore orders quiet moves by their history score, with squareGain
+// breaking ties between moves the history knows nothing about.
+func (s *Searcher) quietScore(pos *Position, move Move) int {
+	return s.history[sideIndex(pos)][move.FromRow*8+move.FromCol][move.ToRow*8+move.ToCol] + squareGain(pos, move)
+}
+
+// scoredMoves sorts moves by scores, highest first, keeping the two in step.
+type scoredMoves struct {
+	moves  []Move
+	scores []int
+}
+
+func (m scoredMoves) Len() int           { return len(m.moves) }
+func (m scoredMoves) Less(i, j int) bool { return m.scores[i] > m.scores[j] }
+func (m scoredMoves) Swap(i, j int) {
+	m.moves[i], m.moves[j] = m.moves[j], m.moves[i]
+	m.scores[i], m.scores[j] = m.scores[j], m.scores[i]
+}
+
+// sortByScore sorts moves by scores, which hold one score per move, best first.
+func sortByScore(moves []Move, scores []int) {
+	sort.Sort(scoredMoves{moves, scores})
+}
+
+// rootMoves returns the legal moves of pos in the order a movePicker hands them
+// out, hashMove first if it is legal.
+func (s *Searcher) rootMoves(pos *Position, hashMove Move) []Move {
+	picker := s.newMovePicker(pos, 0, hashMove)
+	var moves []Move
+	for move, ok := picker.nextMove(); ok; move, ok = picker.nextMove() {
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// Stages of a movePicker, in the order it goes through them.
+const (
+	stageHashMove = iota
+	stageNoisy
+	stageSpecial
+	stageQuiet
+	stageDone
+)
+
+// movePicker hands out the legal moves of a node one at a time, in stages: the
+// hash move, then captures and promotions by noisyScore, then the killers and
+// the counter move, then the other quiet moves by quietScore. A stage is only
+// generated and scored once the earlier ones are used up, so a cutoff by the
+// hash move or a capture spares generating the quiet moves at all, and within
+// a stage the next best move is picked rather than the whole stage sorted.
+type movePicker struct {
+	s        *Searcher
+	pos      *Position
+	hashMove Move
+	special  [3]Move // two killers and the counter move
+	stage    int
+	moves    []Move
+	scores   []int
+	next     int
+}
+
+// newMovePicker returns a picker for the moves of pos, ply moves below the root,
+// that tries hashMove first if it is legal there.
+func (s *Searcher) newMovePicker(pos *Position, ply int, hashMove Move) movePicker {
+	mp := movePicker{s: s, pos: pos, hashMove: hashMove}
+	if ply < len(s.killers) {
+		mp.special[0], mp.special[1] = s.killers[ply][0], s.killers[ply][1]
+	}
+	mp.special[2] = s.counterMove(ply)
+	return mp
+}
+
+// nextMove returns the next move, or false once all legal moves have been
+// returned. Every legal move is returned exactly once.
+func (mp *movePicker) nextMove() (Move, bool) {
+	for {
+		switch mp.stage {
+		case stageHashMove:
+			mp.stage = stageNoisy
+			if isPlayable(mp.pos, mp.hashMove) {
+				return mp.hashMove, true
+			}
+
+		case stageNoisy:
+			if mp.moves == nil {
+				mp.moves = generateMoves(mp.pos, genNoisy)
+				mp.scores = make([]int, len(mp.moves))
+				for i, move := range mp.moves {
+					mp.scores[i] = noisyScore(mp.pos, move)
+				}
+			}
+			if move, ok := mp.pickBest(); ok {
+				return move, true
+			}
+			mp.stage, mp.moves, mp.next = stageSpecial, nil, 0
+
+		case stageSpecial:
+			for mp.next < len(mp.special) {
+				move := mp.special[mp.next]
+				mp.next++
+				if move != mp.hashMove && !mp.seenSpecial(move, mp.next-1) &&
+					isQuietMove(mp.pos, move) && isPlayable(mp.pos, move) {
+					return move, true
+				}
+			}
+			mp.stage, mp.next = stageQuiet, 0
+
+		case stageQuiet:
+			if mp.moves == nil {
+				mp.moves = generateMoves(mp.pos, genQuiet)
+				mp.scores = make([]int, len(mp.moves))
+				for i, move := range mp.moves {
+					mp.scores[i] = mp.s.quietScore(mp.pos, move)
+				}
+			}
+			if move, ok := mp.pickBest(); ok {
+				return move, true
+			}
+			mp.stage = stageDone
+
+		default:
+			return Move{}, false
+		}
+	}
+}
+
+// pickBest returns the best scored move of the current stage not returned yet,
+// skipping the hash move and the killers and counter move already tried.
+func (mp *movePicker) pickBest() (Move, bool) {
+	for mp.next < len(mp.moves) {
+		best := mp.next
+		for i := mp.next + 1; i < len(mp.moves); i++ {
+			if mp.scores[i] > mp.scores[best] {
+				best = i
+			}
+		}
+		mp.moves[mp.next], mp.moves[best] = mp.moves[best], mp.moves[mp.next]
+		mp.scores[mp.next], mp.scores[best] = mp.scores[best], mp.scores[mp.next]
+		move := mp.moves[mp.next]
+		mp.next++
+		if move == mp.hashMove || (mp.stage == stageQuiet && mp.seenSpecial(move, len(mp.special))) {
+			continue
+		}
+		return move, true
+	}
+	return Move{}, false
+}
+
+// seenSpecial reports whether move is among the first n killers and counter move.
+func (mp *movePicker) seenSpecial(move Move, n int) bool {
+	for _, m := range mp.special[:n] {
+		if m == move {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlayable reports whether move, which may come from the transposition table
+// or from another node, is legal in pos with a promotion exactly when a pawn
+// reaches the last rank.
+func isPlayable(pos *Position, move Move) bool {
+	if move == (Move{}) {
+		return false
+	}
+	piece := pos.Board[move.FromRow][move.FromCol]
+	if piece == 0 || isWhite(piece) != pos.WhiteToMove {
+		return false
+	}
+	if isPromotionMove(piece, move.ToRow) != (move.Promotion != 0) {
+		return false
+	}
+	var promotion *rune
+	if move.Promotion != 0 {
+		promotion = &move.Promotion
+	}
+	return IsValidMove(pos, move.FromRow, move.FromCol, move.ToRow, move.ToCol, promotion)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import "testing"
+
+// pickAll returns every move a fresh picker for pos hands out, in order.
+func pickAll(s *Searcher, pos *Position, ply int, hashMove Move) []Move {
+	picker := s.newMovePicker(pos, ply, hashMove)
+	var moves []Move
+	for move, ok := picker.nextMove(); ok; move, ok = picker.nextMove() {
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// TestMovePickerMoves checks that the picker returns each legal move exactly
+// once, the hash move first and the captures and promotions before the quiet
+// moves, whatever the killers and counter move hold.
+func TestMovePickerMoves(t *testing.T) {
+	for _, tc := range perftPositions {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		legal := generateLegalMoves(&pos)
+		hashMove := legal[len(legal)/2]
+
+		s := NewSearcher()
+		s.plyMoves[0] = legal[0]
+		s.recordCutoff(&pos, legal[len(legal)-1], 4, 1, nil)
+		s.recordCutoff(&pos, hashMove, 4, 1, nil)
+		// A move from another position that is illegal here.
+		s.killers[1][1] = Move{FromRow: 3, FromCol: 3, ToRow: 0, ToCol: 0}
+
+		moves := pickAll(s, &pos, 1, hashMove)
+		if len(moves) != len(legal) {
+			t.Errorf("%s: picked %d moves, want %d", tc.name, len(moves), len(legal))
+		}
+		seen := make(map[Move]bool)
+		for _, move := range moves {
+			if seen[move] {
+				t.Errorf("%s: picked %v twice", tc.name, move)
+			}
+			seen[move] = true
+		}
+		for _, move := range legal {
+			if !seen[move] {
+				t.Errorf("%s: never picked %v", tc.name, move)
+			}
+		}
+		if moves[0] != hashMove {
+			t.Errorf("%s: picked %v first, want the hash move %v", tc.name, moves[0], hashMove)
+		}
+		quiet := false
+		for _, move := range moves[1:] {
+			if isQuietMove(&pos, move) {
+				quiet = true
+			} else if quiet {
+				t.Errorf("%s: picked %v after a quiet move", tc.name, move)
+			}
+		}
+	}
+}
+
+// TestMovePickerCaptureOrder checks that captures come most valuable victim
+// first and, for the same victim, least valuable attacker first.
+func TestMovePickerCaptureOrder(t *testing.T) {
+	// The queen on d5 can be taken by the pawn on e4 or the rook on d1, the
+	// knight on c6 by the bishop on b5, and the pawn on a7 by the rook on a1.
+	pos, _ := ParseFEN("4k3/p7/2n5/1B1q4/4P3/8/8/R2RK3 w - - 0 1")
+	var want []Move
+	for _, text := range []string{"e4d5", "d1d5", "b5c6", "a1a7"} {
+		move, _ := ParseMove(text)
+		want = append(want, move)
+	}
+	moves := pickAll(NewSearcher(), &pos, 0, Move{})
+	for i, move := range want {
+		if moves[i] != move {
+			t.Fatalf("picked %v, want %v first", moves[:len(want)], want)
+		}
+	}
+	if captures := GenerateCaptureMoves(&pos); len(captures) != len(want) || captures[0] != want[0] {
+		t.Errorf("GenerateCaptureMoves = %v, want %v", captures, want)
+	}
+}
+
+// TestGenerateMovesByKind checks that generating the noisy and the quiet moves
+// separately splits the legal moves between them, and that GenereateAllMoves
+// returns them all, noisy ones first.
+func TestGenerateMovesByKind(t *testing.T) {
+	for _, tc := range perftPositions {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		legal := map[Move]bool{}
+		for _, move := range generateLegalMoves(&pos) {
+			legal[move] = true
+		}
+		noisy, quiet := generateMoves(&pos, genNoisy), generateMoves(&pos, genQuiet)
+		if len(noisy)+len(quiet) != len(legal) {
+			t.Errorf("%s: %d noisy and %d quiet moves, want %d in all", tc.name, len(noisy), len(quiet), len(legal))
+		}
+		for _, move := range noisy {
+			if !legal[move] || isQuietMove(&pos, move) {
+				t.Errorf("%s: %v generated as a noisy move", tc.name, move)
+			}
+		}
+		for _, move := range quiet {
+			if !legal[move] || !isQuietMove(&pos, move) {
+				t.Errorf("%s: %v generated as a quiet move", tc.name, move)
+			}
+		}
+
+		all := GenereateAllMoves(&pos)
+		if len(all) != len(legal) {
+			t.Errorf("%s: GenereateAllMoves returned %d moves, want %d", tc.name, len(all), len(legal))
+		}
+		for i, move := range all {
+			if i < len(noisy) == isQuietMove(&pos, move) {
+				t.Errorf("%s: GenereateAllMoves has %v at %d with %d noisy moves", tc.name, move, i, len(noisy))
+			}
+		}
+	}
+}
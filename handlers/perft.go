@@ -0,0 +1,42 @@
+package handlers
+
+// Perft counts the leaf nodes of the legal move tree below pos to the given depth.
+// Comparing the result with published counts is the standard way to check a move
+// generator; pos is left unchanged.
+func Perft(pos *Position, depth int) int {
+	if depth <= 0 {
+		return 1
+	}
+	moves := generateLegalMoves(pos)
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for _, move := range moves {
+		undo := pos.MakeMove(move)
+		nodes += Perft(pos, depth-1)
+		pos.UnmakeMove(move, undo)
+	}
+	return nodes
+}
+
+// MoveCount is one line of Divide output: a root move and the leaf nodes below it.
+type MoveCount struct {
+	Move  Move
+	Nodes int
+}
+
+// Divide runs Perft(depth-1) after each legal root move, which makes it easy to
+// find the move whose subtree disagrees with a reference engine.
+func Divide(pos *Position, depth int) []MoveCount {
+	if depth <= 0 {
+		return nil
+	}
+	var counts []MoveCount
+	for _, move := range generateLegalMoves(pos) {
+		undo := pos.MakeMove(move)
+		counts = append(counts, MoveCount{Move: move, Nodes: Perft(pos, depth-1)})
+		pos.UnmakeMove(move, undo)
+	}
+	return counts
+}
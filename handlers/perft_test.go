@@ -0,0 +1,60 @@
+package handlers
+
+import "testing"
+
+// Node counts from https://www.chessprogramming.org/Perft_Results.
+var perftPositions = []struct {
+	name   string
+	fen    string
+	counts []int // counts[d-1] is perft(d)
+}{
+	{"startpos", StartFEN,
+		[]int{20, 400, 8902, 197281, 4865609}},
+	{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		[]int{48, 2039, 97862, 4085603}},
+	{"position3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		[]int{14, 191, 2812, 43238, 674624}},
+	{"position4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		[]int{6, 264, 9467, 422333}},
+	{"position5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+		[]int{44, 1486, 62379, 2103487}},
+	{"position6", "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+		[]int{46, 2079, 89890, 3894594}},
+}
+
+func TestPerft(t *testing.T) {
+	for _, tc := range perftPositions {
+		t.Run(tc.name, func(t *testing.T) {
+			pos, err := ParseFEN(tc.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for depth, want := range tc.counts {
+				depth++
+				if testing.Short() && want > 100000 {
+					break
+				}
+				if got := Perft(&pos, depth); got != want {
+					t.Fatalf("perft(%d) = %d, want %d", depth, got, want)
+				}
+			}
+			if got := pos.FEN(); got != tc.fen {
+				t.Errorf("position changed by perft: got %q", got)
+			}
+		})
+	}
+}
+
+func TestDivideSumsToPerft(t *testing.T) {
+	pos, err := ParseFEN(perftPositions[1].fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, mc := range Divide(&pos, 3) {
+		total += mc.Nodes
+	}
+	if want := Perft(&pos, 3); total != want {
+		t.Errorf("divide total = %d, want %d", total, want)
+	}
+}
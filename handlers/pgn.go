@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sevenTagRoster lists the tags every exported game carries, in the order the
+// PGN standard puts them, with the value used when a tag is unknown.
+var sevenTagRoster = []Tag{
+	{"Event", "?"},
+	{"Site", "?"},
+	{"Date", "????.??.??"},
+	{"Round", "?"},
+	{"White", "?"},
+	{"Black", "?"},
+	{"Result", "*"},
+}
+
+// pgnLineLength is the longest line PGN() writes in the movetext.
+const pgnLineLength = 80
+
+// suffixNAGs maps the traditional move annotations to their NAG numbers.
+var suffixNAGs = map[string]int{"!": 1, "?": 2, "!!": 3, "??": 4, "!?": 5, "?!": 6}
+
+// Tag is one PGN header such as [White "Carlsen"].
+type Tag struct {
+	Name, Value string
+}
+
+// GameMove is one move of a game together with the annotations after it.
+type GameMove struct {
+	Move    Move
+	SAN     string
+	NAGs    []int
+	Comment string
+	// Variations are alternatives to this move. Each starts from the position
+	// before it.
+	Variations [][]GameMove
+}
+
+// Game is a game as stored in PGN: its tags, an optional comment before the
+// first move and the main line. The start position is the standard one unless
+// a FEN tag says otherwise.
+type Game struct {
+	Tags    []Tag
+	Comment string
+	Moves   []GameMove
+}
+
+// NewGame returns a game with no moves starting from start, with the Seven Tag
+// Roster filled in with unknown values.
+func NewGame(start *Position) *Game {
+	g := &Game{Tags: append([]Tag(nil), sevenTagRoster...)}
+	if fen := start.FEN(); fen != StartFEN {
+		g.SetTag("SetUp", "1")
+		g.SetTag("FEN", fen)
+	}
+	return g
+}
+
+// Tag returns the value of the named tag, or "" if the game has none.
+func (g *Game) Tag(name string) string {
+	for _, tag := range g.Tags {
+		if tag.Name == name {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag sets the named tag, adding it after the others if it is new.
+func (g *Game) SetTag(name, value string) {
+	for i := range g.Tags {
+		if g.Tags[i].Name == name {
+			g.Tags[i].Value = value
+			return
+		}
+	}
+	g.Tags = append(g.Tags, Tag{name, value})
+}
+
+// Result returns the game result: "1-0", "0-1", "1/2-1/2" or "*" while the game
+// is still going on.
+func (g *Game) Result() string {
+	if result := g.Tag("Result"); result != "" {
+		return result
+	}
+	return "*"
+}
+
+// StartPosition returns the position the game starts from.
+func (g *Game) StartPosition() (Position, error) {
+	if fen := g.Tag("FEN"); fen != "" {
+		return ParseFEN(fen)
+	}
+	return ParseFEN(StartFEN)
+}
+
+// Position returns the position at the end of the main line.
+func (g *Game) Position() (Position, error) {
+	pos, err := g.StartPosition()
+	if err != nil {
+		return pos, err
+	}
+	for _, gm := range g.Moves {
+		pos.MakeMove(gm.Move)
+	}
+	return pos, nil
+}
+
+// Hashes returns the GetZobristValue of every position of the main line before
+// the last one, oldest first, as CheckDraw and Searcher.SetHistory take them.
+func (g *Game) Hashes() ([]uint64, error) {
+	pos, err := g.StartPosition()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]uint64, 0, len(g.Moves))
+	for _, gm := range g.Moves {
+		hashes = append(hashes, GetZobristValue(&pos))
+		pos.MakeMove(gm.Move)
+	}
+	return hashes, nil
+}
+
+// AddMove plays move at the end of the main line. It fails if the move is
+// illegal there.
+func (g *Game) AddMove(move Move) error {
+	pos, err := g.Position()
+	if err != nil {
+		return err
+	}
+	if isPromotionMove(pos.Board[move.FromRow][move.FromCol], move.ToRow) && move.Promotion == 0 {
+		move.Promotion = 'Q'
+	}
+	if !isLegalMove(&pos, move) {
+		return fmt.Errorf("illegal move %s", move)
+	}
+	g.Moves = append(g.Moves, GameMove{Move: move, SAN: MoveToSAN(&pos, move)})
+	return nil
+}
+
+// PGN writes the game in PGN export format: the Seven Tag Roster first, then
+// the other tags, then the movetext wrapped at 80 columns and the result.
+func (g *Game) PGN() string {
+	var sb strings.Builder
+	for _, roster := range sevenTagRoster {
+		value := g.Tag(roster.Name)
+		if value == "" {
+			value = roster.Value
+		}
+		writeTag(&sb, roster.Name, value)
+	}
+	for _, tag := range g.Tags {
+		if !isRosterTag(tag.Name) {
+			writeTag(&sb, tag.Name, tag.Value)
+		}
+	}
+	sb.WriteByte('\n')
+
+	var words []string
+	if g.Comment != "" {
+		words = append(words, commentWords(g.Comment)...)
+	}
+	if start, err := g.StartPosition(); err == nil {
+		words = appendMovetext(words, start, g.Moves, g.Comment != "")
+	}
+	words = append(words, g.Result())
+
+	lineLen := 0
+	for _, word := range words {
+		if lineLen > 0 && lineLen+1+len(word) > pgnLineLength {
+			sb.WriteByte('\n')
+			lineLen = 0
+		}
+		if lineLen > 0 {
+			sb.WriteByte(' ')
+			lineLen++
+		}
+		sb.WriteString(word)
+		lineLen += len(word)
+	}
+	sb.WriteString("\n\n")
+	return sb.String()
+}
+
+func writeTag(sb *strings.Builder, name, value string) {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	fmt.Fprintf(sb, "[%s \"%s\"]\n", name, value)
+}
+
+func isRosterTag(name string) bool {
+	for _, roster := range sevenTagRoster {
+		if roster.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// commentWords splits a comment into words so that long comments can wrap.
+func commentWords(comment string) []string {
+	return strings.Fields("{" + strings.ReplaceAll(comment, "}", ")") + "}")
+}
+
+// appendMovetext appends the words of moves, played from pos, to words. Black
+// moves get a "N..." number at the start of a line and after a comment or a
+// variation interrupts the flow.
+func appendMovetext(words []string, pos Position, moves []GameMove, interrupted bool) []string {
+	for i, gm := range moves {
+		if pos.WhiteToMove {
+			words = append(words, strconv.Itoa(pos.FullmoveNumber)+".")
+		} else if i == 0 || interrupted {
+			words = append(words, strconv.Itoa(pos.FullmoveNumber)+"...")
+		}
+		words = append(words, gm.SAN)
+		for _, nag := range gm.NAGs {
+			words = append(words, "$"+strconv.Itoa(nag))
+		}
+		interrupted = false
+		if gm.Comment != "" {
+			words = append(words, commentWords(gm.Comment)...)
+			interrupted = true
+		}
+		for _, variation := range gm.Variations {
+			if len(variation) == 0 {
+				continue
+			}
+			first := len(words)
+			words = appendMovetext(words, pos, variation, false)
+			words[first] = "(" + words[first]
+			words[len(words)-1] += ")"
+			interrupted = true
+		}
+		pos.MakeMove(gm.Move)
+	}
+	return words
+}
+
+// ParsePGN reads every game in text. Moves may be written in any form ParseSAN
+// accepts; they are stored in strict SAN. Comments before the first move of a
+// variation are dropped, as are escaped "%" lines.
+func ParsePGN(text string) ([]*Game, error) {
+	tokens, err := tokenizePGN(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &pgnParser{tokens: tokens}
+	var games []*Game
+	for p.i < len(p.tokens) {
+		game, err := p.game()
+		if err != nil {
+			return games, err
+		}
+		games = append(games, game)
+	}
+	if len(games) == 0 {
+		return nil, fmt.Errorf("invalid PGN: no games")
+	}
+	return games, nil
+}
+
+type pgnTokenKind int
+
+const (
+	pgnTag pgnTokenKind = iota
+	pgnComment
+	pgnOpen
+	pgnClose
+	pgnNAG
+	pgnResult
+	pgnMove
+)
+
+type pgnToken struct {
+	kind  pgnTokenKind
+	text  string
+	value string // tag value
+	nag   int
+	line  int
+}
+
+// tokenizePGN splits text into tags, comments, parentheses, NAGs, results and
+// moves. Move numbers and periods are dropped.
+func tokenizePGN(text string) ([]pgnToken, error) {
+	var tokens []pgnToken
+	line := 1
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '.':
+			i++
+		case c == '%' && (i == 0 || text[i-1] == '\n'), c == ';':
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			if c == ';' {
+				tokens = append(tokens, pgnToken{kind: pgnComment, text: strings.TrimSpace(text[i+1 : i+end]), line: line})
+			}
+			i += end
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid PGN: unterminated comment on line %d", line)
+			}
+			comment := text[i+1 : i+end]
+			tokens = append(tokens, pgnToken{kind: pgnComment, text: strings.Join(strings.Fields(comment), " "), line: line})
+			line += strings.Count(comment, "\n")
+			i += end + 1
+		case c == '[':
+			tag, n, err := parseTag(text[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid PGN: %v on line %d", err, line)
+			}
+			tag.line = line
+			tokens = append(tokens, tag)
+			i += n
+		case c == '(':
+			tokens = append(tokens, pgnToken{kind: pgnOpen, line: line})
+			i++
+		case c == ')':
+			tokens = append(tokens, pgnToken{kind: pgnClose, line: line})
+			i++
+		case c == '*':
+			tokens = append(tokens, pgnToken{kind: pgnResult, text: "*", line: line})
+			i++
+		case c == '$':
+			j := i + 1
+			for j < len(text) && text[j] >= '0' && text[j] <= '9' {
+				j++
+			}
+			nag, err := strconv.Atoi(text[i+1 : j])
+			if err != nil {
+				return nil, fmt.Errorf("invalid PGN: bad NAG on line %d", line)
+			}
+			tokens = append(tokens, pgnToken{kind: pgnNAG, nag: nag, line: line})
+			i = j
+		case isSymbolChar(c):
+			j := i
+			for j < len(text) && isSymbolChar(text[j]) {
+				j++
+			}
+			tokens = appendSymbol(tokens, text[i:j], line)
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid PGN: unexpected %q on line %d", c, line)
+		}
+	}
+	return tokens, nil
+}
+
+func isSymbolChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("_+#=:-/!?", c) >= 0
+}
+
+// appendSymbol adds the token or tokens for one symbol: a result, a move with
+// any "!?" suffix turned into a NAG, or nothing for a move number.
+func appendSymbol(tokens []pgnToken, symbol string, line int) []pgnToken {
+	switch symbol {
+	case "1-0", "0-1", "1/2-1/2":
+		return append(tokens, pgnToken{kind: pgnResult, text: symbol, line: line})
+	}
+	if strings.Trim(symbol, "0123456789") == "" {
+		return tokens
+	}
+	move := strings.TrimRight(symbol, "!?")
+	if move != "" {
+		tokens = append(tokens, pgnToken{kind: pgnMove, text: move, line: line})
+	}
+	if suffix := symbol[len(move):]; suffix != "" {
+		nag, ok := suffixNAGs[suffix]
+		if !ok {
+			nag = suffixNAGs[suffix[:1]]
+		}
+		tokens = append(tokens, pgnToken{kind: pgnNAG, nag: nag, line: line})
+	}
+	return tokens
+}
+
+// parseTag parses a tag pair at the start of s and returns it with its length.
+func parseTag(s string) (pgnToken, int, error) {
+	end := strings.IndexAny(s, "\"]")
+	if end < 0 || s[end] != '"' {
+		return pgnToken{}, 0, fmt.Errorf("bad tag")
+	}
+	name := strings.TrimSpace(s[1:end])
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return pgnToken{}, 0, fmt.Errorf("bad tag name %q", name)
+	}
+
+	var value strings.Builder
+	i := end + 1
+	for ; i < len(s) && s[i] != '"'; i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		if s[i] == '\n' {
+			return pgnToken{}, 0, fmt.Errorf("unterminated value for tag %s", name)
+		}
+		value.WriteByte(s[i])
+	}
+	rest := strings.TrimLeft(s[min(i+1, len(s)):], " \t")
+	if i >= len(s) || !strings.HasPrefix(rest, "]") {
+		return pgnToken{}, 0, fmt.Errorf("unterminated tag %s", name)
+	}
+	return pgnToken{kind: pgnTag, text: name, value: value.String()}, len(s) - len(rest) + 1, nil
+}
+
+type pgnParser struct {
+	tokens []pgnToken
+	i      int
+}
+
+// game parses one game: its tags, then the movetext up to the result or the
+// tags of the next game.
+func (p *pgnParser) game() (*Game, error) {
+	g := &Game{}
+	for p.i < len(p.tokens) && p.tokens[p.i].kind == pgnTag {
+		g.SetTag(p.tokens[p.i].text, p.tokens[p.i].value)
+		p.i++
+	}
+	start, err := g.StartPosition()
+	if err != nil {
+		return nil, fmt.Errorf("invalid PGN: %v", err)
+	}
+	moves, comment, err := p.line(start, 0)
+	if err != nil {
+		return nil, err
+	}
+	g.Moves, g.Comment = moves, comment
+	if p.i > 0 && p.tokens[p.i-1].kind == pgnResult {
+		g.SetTag("Result", p.tokens[p.i-1].text)
+	}
+	return g, nil
+}
+
+// line parses moves played from pos with their annotations and variations. At
+// depth 0 it stops after the result or before the next game's tags; inside a
+// variation it stops after the closing parenthesis. It also returns the
+// comment found before the first move.
+func (p *pgnParser) line(pos Position, depth int) ([]GameMove, string, error) {
+	var moves []GameMove
+	var comment string
+	var before Position
+	for p.i < len(p.tokens) {
+		tok := p.tokens[p.i]
+		switch tok.kind {
+		case pgnTag:
+			if depth > 0 {
+				return nil, "", fmt.Errorf("invalid PGN: unterminated variation before line %d", tok.line)
+			}
+			return moves, comment, nil
+		case pgnResult:
+			p.i++
+			if depth == 0 {
+				return moves, comment, nil
+			}
+		case pgnClose:
+			if depth == 0 {
+				return nil, "", fmt.Errorf("invalid PGN: unexpected ')' on line %d", tok.line)
+			}
+			p.i++
+			return moves, comment, nil
+		case pgnOpen:
+			if len(moves) == 0 {
+				return nil, "", fmt.Errorf("invalid PGN: variation before any move on line %d", tok.line)
+			}
+			p.i++
+			variation, _, err := p.line(before, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			last := &moves[len(moves)-1]
+			last.Variations = append(last.Variations, variation)
+		case pgnComment:
+			p.i++
+			if len(moves) == 0 {
+				comment = joinComments(comment, tok.text)
+			} else {
+				last := &moves[len(moves)-1]
+				last.Comment = joinComments(last.Comment, tok.text)
+			}
+		case pgnNAG:
+			p.i++
+			if len(moves) > 0 {
+				last := &moves[len(moves)-1]
+				last.NAGs = append(last.NAGs, tok.nag)
+			}
+		case pgnMove:
+			p.i++
+			move, err := ParseSAN(&pos, tok.text)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid PGN: %v on line %d", err, tok.line)
+			}
+			before = pos
+			moves = append(moves, GameMove{Move: move, SAN: MoveToSAN(&pos, move)})
+			pos.MakeMove(move)
+		}
+	}
+	if depth > 0 {
+		return nil, "", fmt.Errorf("invalid PGN: unterminated variation")
+	}
+	return moves, comment, nil
+}
+
+func joinComments(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + " " + b
+}
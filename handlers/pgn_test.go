@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+const pgnSample = `% exported by hand
+[Event "Casual game"]
+[White "Anderssen, \"Adolf\""]
+[Black "Kieseritzky"]
+[Opening "King's Gambit"]
+
+{The Immortal Game, shortened.} 1. e4 e5 2. f4 exf4 3. Bc4 Qh4+ 4. Kf1 b5!?
+; a comment to the end of the line
+5. Bxb5 (5. Bb3 Nf6 (5... Qf6) 6. d3) 5... Nf6 $1 {Black develops.} 6. Nf3 Qh6 1-0
+
+[Event "Second"]
+[SetUp "1"]
+[FEN "4k3/8/8/8/8/8/4P3/4K3 b - - 0 40"]
+
+40... Kd7 41. e4 *
+`
+
+func TestParsePGN(t *testing.T) {
+	games, err := ParsePGN(pgnSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 2 {
+		t.Fatalf("found %d games, want 2", len(games))
+	}
+
+	g := games[0]
+	if got := g.Tag("White"); got != `Anderssen, "Adolf"` {
+		t.Errorf("White tag = %q", got)
+	}
+	if g.Result() != "1-0" || g.Comment != "The Immortal Game, shortened." {
+		t.Errorf("Result() = %q, Comment = %q", g.Result(), g.Comment)
+	}
+	var sans []string
+	for _, gm := range g.Moves {
+		sans = append(sans, gm.SAN)
+	}
+	if got, want := strings.Join(sans, " "), "e4 e5 f4 exf4 Bc4 Qh4+ Kf1 b5 Bxb5 Nf6 Nf3 Qh6"; got != want {
+		t.Errorf("main line = %q, want %q", got, want)
+	}
+
+	b5, bxb5, nf6 := g.Moves[7], g.Moves[8], g.Moves[9]
+	if len(b5.NAGs) != 1 || b5.NAGs[0] != 5 || b5.Comment != "a comment to the end of the line" {
+		t.Errorf("b5 has NAGs %v and comment %q", b5.NAGs, b5.Comment)
+	}
+	if len(nf6.NAGs) != 1 || nf6.NAGs[0] != 1 || nf6.Comment != "Black develops." {
+		t.Errorf("Nf6 has NAGs %v and comment %q", nf6.NAGs, nf6.Comment)
+	}
+	if len(bxb5.Variations) != 1 || len(bxb5.Variations[0]) != 3 ||
+		len(bxb5.Variations[0][1].Variations) != 1 || bxb5.Variations[0][1].Variations[0][0].SAN != "Qf6" {
+		t.Errorf("5. Bxb5 has variations %v", bxb5.Variations)
+	}
+
+	g = games[1]
+	pos, err := g.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pos.FEN(), "8/3k4/8/8/4P3/8/8/4K3 b - e3 0 41"; got != want {
+		t.Errorf("second game ends at %q, want %q", got, want)
+	}
+	if g.Result() != "*" {
+		t.Errorf("second game result = %q, want *", g.Result())
+	}
+}
+
+func TestPGNRoundTrip(t *testing.T) {
+	games, err := ParsePGN(pgnSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[Event "Casual game"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "Anderssen, \"Adolf\""]
+[Black "Kieseritzky"]
+[Result "1-0"]
+[Opening "King's Gambit"]
+
+{The Immortal Game, shortened.} 1. e4 e5 2. f4 exf4 3. Bc4 Qh4+ 4. Kf1 b5 $5 {a
+comment to the end of the line} 5. Bxb5 (5. Bb3 Nf6 (5... Qf6) 6. d3) 5... Nf6
+$1 {Black develops.} 6. Nf3 Qh6 1-0
+
+`
+	if got := games[0].PGN(); got != want {
+		t.Errorf("PGN() =\n%s\nwant\n%s", got, want)
+	}
+
+	for _, g := range games {
+		again, err := ParsePGN(g.PGN())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again[0].PGN() != g.PGN() {
+			t.Errorf("PGN changed after a round trip:\n%s\n%s", g.PGN(), again[0].PGN())
+		}
+	}
+}
+
+func TestGameAddMove(t *testing.T) {
+	start, _ := ParseFEN("4k3/P7/8/8/8/8/8/4K3 w - - 0 1")
+	g := NewGame(&start)
+	if g.Tag("FEN") != start.FEN() || g.Tag("SetUp") != "1" {
+		t.Errorf("NewGame tags = %v", g.Tags)
+	}
+	if err := g.AddMove(Move{FromRow: 1, FromCol: 0, ToRow: 0, ToCol: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddMove(Move{FromRow: 0, FromCol: 4, ToRow: 2, ToCol: 4}); err == nil {
+		t.Error("AddMove accepted an illegal move")
+	}
+	if len(g.Moves) != 1 || g.Moves[0].SAN != "a8=Q+" {
+		t.Errorf("moves = %v", g.Moves)
+	}
+
+	start, _ = ParseFEN(StartFEN)
+	g = NewGame(&start)
+	if len(g.Tags) != 7 || strings.Count(g.PGN(), "\n") != 10 {
+		t.Errorf("a new game from the start position should carry only the Seven Tag Roster:\n%s", g.PGN())
+	}
+}
+
+func TestParsePGNErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"1. e4 e5 2. Nf4",
+		"1. e4 (1. d4 d5",
+		"1. e4 e5)",
+		"1. e4 {unterminated",
+		`[White "unterminated]` + "\n1. e4",
+		`[FEN "not a fen"]` + "\n1. e4",
+	} {
+		if _, err := ParsePGN(text); err == nil {
+			t.Errorf("ParsePGN(%q) succeeded", text)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// Offsets of the non-piece keys in polyglotRandom.
+const (
+	polyglotCastlingOffset  = 768
+	polyglotEnPassantOffset = 772
+	polyglotTurnOffset      = 780
+)
+
+// polyglotPieceKinds lists the pieces in Polyglot's order: black pawn, white
+// pawn, black knight, and so on up to the white king.
+const polyglotPieceKinds = "pPnNbBrRqQkK"
+
+// PolyglotKey returns the Polyglot hash of pos, which is what opening books are
+// keyed by. It differs from GetZobristValue: the keys are Polyglot's, and the
+// en-passant file only counts when a pawn of the side to move can capture on it.
+func PolyglotKey(pos *Position) uint64 {
+	var key uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			piece := pos.Board[row][col]
+			if piece == 0 {
+				continue
+			}
+			for kind, p := range polyglotPieceKinds {
+				if p == piece {
+					key ^= polyglotRandom[64*kind+8*(7-row)+col]
+					break
+				}
+			}
+		}
+	}
+
+	for i, right := range []bool{pos.Castling.WhiteKingSide, pos.Castling.WhiteQueenSide, pos.Castling.BlackKingSide, pos.Castling.BlackQueenSide} {
+		if right {
+			key ^= polyglotRandom[polyglotCastlingOffset+i]
+		}
+	}
+
+	if pos.canCaptureEnPassant() {
+		key ^= polyglotRandom[polyglotEnPassantOffset+pos.EnPassantCol]
+	}
+
+	if pos.WhiteToMove {
+		key ^= polyglotRandom[polyglotTurnOffset]
+	}
+	return key
+}
+
+// bookEntry is one 16-byte record of a Polyglot book.
+type bookEntry struct {
+	key    uint64
+	move   uint16
+	weight uint16
+}
+
+// Book is a Polyglot opening book held in memory. It is safe for concurrent use.
+type Book struct {
+	entries []bookEntry // sorted by key
+}
+
+// BookMove is a book move with its weight; moves are played in proportion to
+// their weights.
+type BookMove struct {
+	Move   Move
+	Weight int
+}
+
+// LoadBook reads the Polyglot book at path.
+func LoadBook(path string) (*Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBook(data)
+}
+
+// ParseBook decodes the contents of a Polyglot .bin file.
+func ParseBook(data []byte) (*Book, error) {
+	if len(data)%16 != 0 {
+		return nil, fmt.Errorf("invalid Polyglot book: size %d is not a multiple of 16", len(data))
+	}
+	entries := make([]bookEntry, len(data)/16)
+	for i := range entries {
+		record := data[i*16:]
+		entries[i] = bookEntry{
+			key:    binary.BigEndian.Uint64(record[0:8]),
+			move:   binary.BigEndian.Uint16(record[8:10]),
+			weight: binary.BigEndian.Uint16(record[10:12]),
+		}
+	}
+	// Books are written sorted, but a stable sort costs little and keeps lookups
+	// correct for files that are not.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return &Book{entries: entries}, nil
+}
+
+// Len returns the number of entries in the book.
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Moves returns the legal book moves for pos, in the order they appear in the book.
+func (b *Book) Moves(pos *Position) []BookMove {
+	key := PolyglotKey(pos)
+	i := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].key >= key })
+
+	var moves []BookMove
+	for ; i < len(b.entries) && b.entries[i].key == key; i++ {
+		move := decodeBookMove(pos, b.entries[i].move)
+		// A key collision or a corrupt book can suggest anything.
+		if !isLegalMove(pos, move) {
+			continue
+		}
+		moves = append(moves, BookMove{Move: move, Weight: int(b.entries[i].weight)})
+	}
+	return moves
+}
+
+// PickMove chooses one of the book moves for pos at random, weighted by the
+// entry weights. It reports false when the book has nothing for pos.
+func (b *Book) PickMove(pos *Position) (Move, bool) {
+	moves := b.Moves(pos)
+	total := 0
+	for _, m := range moves {
+		total += m.Weight
+	}
+	if total == 0 {
+		return Move{}, false
+	}
+	return weightedChoice(moves, rand.Intn(total)), true
+}
+
+// weightedChoice returns the move whose share of the total weight contains r,
+// for 0 <= r < total weight.
+func weightedChoice(moves []BookMove, r int) Move {
+	for _, m := range moves {
+		if r < m.Weight {
+			return m.Move
+		}
+		r -= m.Weight
+	}
+	return moves[len(moves)-1].Move
+}
+
+// decodeBookMove turns a Polyglot move into a Move on pos. Polyglot numbers
+// ranks from 1 at row 0 and writes castling as the king taking its own rook.
+func decodeBookMove(pos *Position, m uint16) Move {
+	move := Move{
+		ToCol:   int(m & 7),
+		ToRow:   7 - int(m>>3&7),
+		FromCol: int(m >> 6 & 7),
+		FromRow: 7 - int(m>>9&7),
+	}
+	if promotion := int(m >> 12 & 7); promotion >= 1 && promotion <= 4 {
+		move.Promotion = rune("NBRQ"[promotion-1])
+	}
+
+	piece := pos.Board[move.FromRow][move.FromCol]
+	if (piece == 'K' || piece == 'k') && move.FromCol == 4 && move.FromRow == move.ToRow {
+		switch move.ToCol {
+		case 7:
+			move.ToCol = 6
+		case 0:
+			move.ToCol = 2
+		}
+	}
+	return move
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestPolyglotKey checks PolyglotKey against the test positions published with
+// the Polyglot book format.
+func TestPolyglotKey(t *testing.T) {
+	tests := []struct {
+		fen string
+		key uint64
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0x463b96181691fc9c},
+		{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", 0x823c9b50fd114196},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", 0x0756b94461c50fb0},
+		{"rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2", 0x662fafb965db29d4},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", 0x22a48b5a8e47ff78},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR b kq - 0 3", 0x652a607ca3f242c1},
+		{"rnbq1bnr/ppp1pkpp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR w - - 0 4", 0x00fdd303c946bdd9},
+		{"rnbqkbnr/p1pppppp/8/8/PpP4P/8/1P1PPPP1/RNBQKBNR b KQkq c3 0 3", 0x3c8123ea7b067637},
+		{"rnbqkbnr/p1pppppp/8/8/P6P/R1p5/1P1PPPP1/1NBQKBNR b Kkq - 0 4", 0x5c3f9b829b279560},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := PolyglotKey(&pos); got != tc.key {
+			t.Errorf("PolyglotKey(%q) = %#016x, want %#016x", tc.fen, got, tc.key)
+		}
+	}
+}
+
+// bookRecord encodes one Polyglot entry for a move given in Polyglot's own
+// coordinates: files 0-7 from a, ranks 0-7 from 1.
+func bookRecord(key uint64, fromFile, fromRank, toFile, toRank int, weight uint16) []byte {
+	record := make([]byte, 16)
+	binary.BigEndian.PutUint64(record[0:8], key)
+	binary.BigEndian.PutUint16(record[8:10], uint16(toFile|toRank<<3|fromFile<<6|fromRank<<9))
+	binary.BigEndian.PutUint16(record[10:12], weight)
+	return record
+}
+
+func TestBookMoves(t *testing.T) {
+	start, _ := ParseFEN(StartFEN)
+	castle, _ := ParseFEN("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+
+	var data []byte
+	// Unsorted on purpose: ParseBook must not rely on the file order.
+	data = append(data, bookRecord(PolyglotKey(&castle), 4, 0, 7, 0, 5)...) // e1h1, i.e. O-O
+	data = append(data, bookRecord(PolyglotKey(&start), 4, 1, 4, 3, 3)...)  // e2e4
+	data = append(data, bookRecord(PolyglotKey(&start), 4, 1, 4, 4, 9)...)  // e2e5 is illegal
+	data = append(data, bookRecord(PolyglotKey(&start), 3, 1, 3, 3, 1)...)  // d2d4
+	data = append(data, bookRecord(PolyglotKey(&castle), 4, 0, 0, 0, 2)...) // e1a1, i.e. O-O-O
+
+	book, err := ParseBook(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", book.Len())
+	}
+
+	want := []BookMove{
+		{Move{FromRow: 6, FromCol: 4, ToRow: 4, ToCol: 4}, 3},
+		{Move{FromRow: 6, FromCol: 3, ToRow: 4, ToCol: 3}, 1},
+	}
+	if got := book.Moves(&start); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Moves(start) = %v, want %v", got, want)
+	}
+
+	want = []BookMove{
+		{Move{FromRow: 7, FromCol: 4, ToRow: 7, ToCol: 6}, 5},
+		{Move{FromRow: 7, FromCol: 4, ToRow: 7, ToCol: 2}, 2},
+	}
+	if got := book.Moves(&castle); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Moves(castle) = %v, want %v", got, want)
+	}
+
+	other, _ := ParseFEN(perftPositions[2].fen)
+	if move, ok := book.PickMove(&other); ok {
+		t.Errorf("PickMove found %v in a position that is not in the book", move)
+	}
+
+	if _, err := ParseBook(data[:20]); err == nil {
+		t.Error("ParseBook accepted a truncated book")
+	}
+}
+
+func TestWeightedChoice(t *testing.T) {
+	a := Move{FromRow: 6, FromCol: 4, ToRow: 4, ToCol: 4}
+	b := Move{FromRow: 6, FromCol: 3, ToRow: 4, ToCol: 3}
+	moves := []BookMove{{a, 3}, {b, 1}}
+	for r, want := range []Move{a, a, a, b} {
+		if got := weightedChoice(moves, r); got != want {
+			t.Errorf("weightedChoice(r=%d) = %v, want %v", r, got, want)
+		}
+	}
+}
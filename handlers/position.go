@@ -0,0 +1,190 @@
+package handlers
+
+import "unicode"
+
+// Position is the complete game state described by the six FEN fields.
+// Board uses the same layout as everywhere else: row 0 is rank 8, row 7 is rank 1.
+type Position struct {
+	Board          [8][8]rune
+	WhiteToMove    bool
+	Castling       CastlingRights
+	EnPassantRow   int // -1 when there is no en-passant target square
+	EnPassantCol   int
+	HalfmoveClock  int
+	FullmoveNumber int
+}
+
+// Undo records everything MakeMove overwrites so UnmakeMove can restore it.
+type Undo struct {
+	Moved          rune
+	Captured       rune
+	CapturedRow    int
+	CapturedCol    int
+	Castling       CastlingRights
+	EnPassantRow   int
+	EnPassantCol   int
+	HalfmoveClock  int
+	FullmoveNumber int
+}
+
+// HasEnPassant reports whether the position has an en-passant target square.
+func (pos *Position) HasEnPassant() bool {
+	return pos.EnPassantRow >= 0 && pos.EnPassantCol >= 0
+}
+
+// canCaptureEnPassant reports whether the side to move has a pawn next to the
+// pawn that just passed the en-passant target square, so that it can capture
+// there. Whether the capture would leave its king in check is not considered.
+func (pos *Position) canCaptureEnPassant() bool {
+	return pos.HasEnPassant() && enPassantCapturer(&pos.Board, pos.EnPassantRow, pos.EnPassantCol, pos.WhiteToMove)
+}
+
+// enPassantCapturer reports whether board has a pawn of the side given by white
+// that could capture onto the en-passant target square row/col.
+func enPassantCapturer(board *[8][8]rune, row, col int, white bool) bool {
+	pawn, pawnRow := 'P', row+1
+	if !white {
+		pawn, pawnRow = 'p', row-1
+	}
+	for _, c := range []int{col - 1, col + 1} {
+		if c >= 0 && c < 8 && board[pawnRow][c] == pawn {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnPassantCapture reports whether move is a pawn capturing onto the en-passant target.
+func (pos *Position) isEnPassantCapture(move Move) bool {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	return (piece == 'P' || piece == 'p') &&
+		move.FromCol != move.ToCol &&
+		pos.Board[move.ToRow][move.ToCol] == 0 &&
+		move.ToRow == pos.EnPassantRow && move.ToCol == pos.EnPassantCol
+}
+
+// MakeMove plays move, which must be legal, updating castling rights, the
+// en-passant target, both clocks and the side to move. The returned Undo
+// must be passed to UnmakeMove to take the move back.
+func (pos *Position) MakeMove(move Move) Undo {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	undo := Undo{
+		Moved:          piece,
+		Captured:       pos.Board[move.ToRow][move.ToCol],
+		CapturedRow:    move.ToRow,
+		CapturedCol:    move.ToCol,
+		Castling:       pos.Castling,
+		EnPassantRow:   pos.EnPassantRow,
+		EnPassantCol:   pos.EnPassantCol,
+		HalfmoveClock:  pos.HalfmoveClock,
+		FullmoveNumber: pos.FullmoveNumber,
+	}
+
+	if pos.isEnPassantCapture(move) {
+		undo.CapturedRow = move.FromRow
+		undo.Captured = pos.Board[move.FromRow][move.ToCol]
+		pos.Board[move.FromRow][move.ToCol] = 0
+	}
+
+	UpdateCastlingRights(pos.Board, move.FromRow, move.FromCol, &pos.Castling)
+	// Capturing a rook on its home square also removes that side's right.
+	UpdateCastlingRights(pos.Board, move.ToRow, move.ToCol, &pos.Castling)
+
+	// Castling: the king moves two squares, bring the rook across.
+	if (piece == 'K' || piece == 'k') && abs(move.ToCol-move.FromCol) == 2 {
+		if move.ToCol > move.FromCol {
+			pos.Board[move.FromRow][5] = pos.Board[move.FromRow][7]
+			pos.Board[move.FromRow][7] = 0
+		} else {
+			pos.Board[move.FromRow][3] = pos.Board[move.FromRow][0]
+			pos.Board[move.FromRow][0] = 0
+		}
+	}
+
+	pos.Board[move.FromRow][move.FromCol] = 0
+	pos.Board[move.ToRow][move.ToCol] = piece
+	if isPromotionMove(piece, move.ToRow) {
+		pos.Board[move.ToRow][move.ToCol] = promotedPiece(piece, move.Promotion)
+	}
+
+	pos.EnPassantRow, pos.EnPassantCol = -1, -1
+	if (piece == 'P' || piece == 'p') && abs(move.ToRow-move.FromRow) == 2 {
+		pos.EnPassantRow = (move.FromRow + move.ToRow) / 2
+		pos.EnPassantCol = move.FromCol
+	}
+
+	if piece == 'P' || piece == 'p' || undo.Captured != 0 {
+		pos.HalfmoveClock = 0
+	} else {
+		pos.HalfmoveClock++
+	}
+	if !pos.WhiteToMove {
+		pos.FullmoveNumber++
+	}
+	pos.WhiteToMove = !pos.WhiteToMove
+
+	return undo
+}
+
+// promotedPiece returns the piece pawn becomes when promoting to promotion,
+// defaulting to a queen when no promotion piece was given.
+func promotedPiece(pawn rune, promotion rune) rune {
+	if promotion == 0 {
+		promotion = 'Q'
+	}
+	if pawn == 'p' {
+		return unicode.ToLower(promotion)
+	}
+	return unicode.ToUpper(promotion)
+}
+
+// UnmakeMove takes back move, restoring the position saved in undo by MakeMove.
+func (pos *Position) UnmakeMove(move Move, undo Undo) {
+	pos.WhiteToMove = !pos.WhiteToMove
+	pos.Castling = undo.Castling
+	pos.EnPassantRow, pos.EnPassantCol = undo.EnPassantRow, undo.EnPassantCol
+	pos.HalfmoveClock = undo.HalfmoveClock
+	pos.FullmoveNumber = undo.FullmoveNumber
+
+	pos.Board[move.FromRow][move.FromCol] = undo.Moved
+	pos.Board[move.ToRow][move.ToCol] = 0
+	pos.Board[undo.CapturedRow][undo.CapturedCol] = undo.Captured
+
+	if (undo.Moved == 'K' || undo.Moved == 'k') && abs(move.ToCol-move.FromCol) == 2 {
+		if move.ToCol > move.FromCol {
+			pos.Board[move.FromRow][7] = pos.Board[move.FromRow][5]
+			pos.Board[move.FromRow][5] = 0
+		} else {
+			pos.Board[move.FromRow][0] = pos.Board[move.FromRow][3]
+			pos.Board[move.FromRow][3] = 0
+		}
+	}
+}
+
+// MakeNullMove passes the move to the other side without moving a piece, which
+// the search uses to test whether a position is good even without a move. It
+// clears the en-passant target and restarts the halfmove clock, so that no
+// repetition is found across the null move. UnmakeNullMove takes it back.
+func (pos *Position) MakeNullMove() Undo {
+	undo := Undo{
+		EnPassantRow:   pos.EnPassantRow,
+		EnPassantCol:   pos.EnPassantCol,
+		HalfmoveClock:  pos.HalfmoveClock,
+		FullmoveNumber: pos.FullmoveNumber,
+	}
+	pos.EnPassantRow, pos.EnPassantCol = -1, -1
+	pos.HalfmoveClock = 0
+	if !pos.WhiteToMove {
+		pos.FullmoveNumber++
+	}
+	pos.WhiteToMove = !pos.WhiteToMove
+	return undo
+}
+
+// UnmakeNullMove takes back a null move, restoring the state saved in undo.
+func (pos *Position) UnmakeNullMove(undo Undo) {
+	pos.WhiteToMove = !pos.WhiteToMove
+	pos.EnPassantRow, pos.EnPassantCol = undo.EnPassantRow, undo.EnPassantCol
+	pos.HalfmoveClock = undo.HalfmoveClock
+	pos.FullmoveNumber = undo.FullmoveNumber
+}
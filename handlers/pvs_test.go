@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// alphaBeta is the search negamax replaced: min/max alpha-beta from White's
+// point of view that searches every move with the full window. It is kept to
+// compare node counts against.
+func (s *Searcher) alphaBeta(ctx context.Context, pos *Position, depth, ply int, alpha, beta int, hash uint64) int {
+	s.nodes.Add(1)
+	s.Stats.Nodes++
+	if repetitions(hash, s.path, pos.HalfmoveClock) > 0 ||
+		(pos.HalfmoveClock >= 100 && !isCheckmate(pos)) {
+		return 0
+	}
+
+	if entry, found := s.tt.probe(hash); found && entry.Depth >= depth {
+		score := scoreFromTT(entry.Score, ply)
+		switch entry.Bound {
+		case BoundExact:
+			return score
+		case BoundLower:
+			alpha = max(alpha, score)
+		case BoundUpper:
+			beta = min(beta, score)
+		}
+		if alpha >= beta {
+			return score
+		}
+	}
+	alphaOrig, betaOrig := alpha, beta
+
+	if depth == 0 {
+		if pos.WhiteToMove {
+			return s.quiescenceSearch(ctx, pos, alpha, beta)
+		}
+		return -s.quiescenceSearch(ctx, pos, -beta, -alpha)
+	}
+
+	picker := s.newMovePicker(pos, ply, Move{})
+	s.path = append(s.path, hash)
+
+	var bestMove Move
+	bestScore := -100000
+	if !pos.WhiteToMove {
+		bestScore = 100000
+	}
+	moveCount := 0
+	for move, ok := picker.nextMove(); ok; move, ok = picker.nextMove() {
+		moveCount++
+		newHash := UpdateHashForMove(hash, move, pos)
+		undo := pos.MakeMove(move)
+		score := s.alphaBeta(ctx, pos, depth-1, ply+1, alpha, beta, newHash)
+		pos.UnmakeMove(move, undo)
+
+		if pos.WhiteToMove {
+			if score > bestScore {
+				bestScore, bestMove = score, move
+			}
+			alpha = max(alpha, score)
+		} else {
+			if score < bestScore {
+				bestScore, bestMove = score, move
+			}
+			beta = min(beta, score)
+		}
+		if alpha >= beta {
+			s.recordCutoff(pos, move, depth, ply, nil)
+			break
+		}
+	}
+	s.path = s.path[:len(s.path)-1]
+	if moveCount == 0 {
+		return relativeScore(pos, matedScore(pos, ply))
+	}
+
+	s.tt.store(HashMap{
+		HashKey:  hash,
+		Score:    scoreToTT(bestScore, ply),
+		Depth:    depth,
+		Bound:    boundFor(bestScore, alphaOrig, betaOrig),
+		BestMove: bestMove,
+	})
+	return bestScore
+}
+
+// searchNodes runs iterative deepening to depth with a fresh Searcher, either
+// with alphaBeta or with the principal variation search, and returns the root
+// score from White's point of view and the nodes searched. The principal
+// variation search runs without the pruning that could change its score.
+func searchNodes(pos Position, depth int, pvs bool) (int, int64) {
+	const infinity = 100000
+	s := NewSearcher()
+	s.exact = true
+	s.beginSearch(context.Background(), time.Now(), 0, 0)
+	s.tt.newSearch()
+	moves := GenereateAllMoves(&pos)
+	hash := GetZobristValue(&pos)
+
+	var score int
+	for d := 1; d <= depth; d++ {
+		if pvs {
+			score, _ = s.searchRootMoves(context.Background(), &pos, d, -infinity, infinity, hash, moves)
+			score = relativeScore(&pos, score)
+			continue
+		}
+		alpha, beta := -infinity, infinity
+		score = -infinity
+		if !pos.WhiteToMove {
+			score = infinity
+		}
+		s.path = append(s.path, hash)
+		for _, move := range moves {
+			undo := pos.MakeMove(move)
+			moveScore := s.alphaBeta(context.Background(), &pos, d, 1, alpha, beta, UpdateHashForMove(hash, move, &pos))
+			pos.UnmakeMove(move, undo)
+			if pos.WhiteToMove {
+				score = max(score, moveScore)
+				alpha = max(alpha, moveScore)
+			} else {
+				score = min(score, moveScore)
+				beta = min(beta, moveScore)
+			}
+		}
+		s.path = s.path[:len(s.path)-1]
+	}
+	return score, s.Stats.Nodes
+}
+
+// TestPVSNodeCount checks that the principal variation search, without null-move
+// pruning and late move reductions and pruning, finds the same root scores as
+// full-window alpha-beta with fewer nodes. The quieter perft positions keep it
+// fast; BenchmarkPVS covers them all.
+func TestPVSNodeCount(t *testing.T) {
+	var pvsNodes, alphaBetaNodes int64
+	for _, tc := range perftPositions {
+		if tc.name != "startpos" && tc.name != "position3" {
+			continue
+		}
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pvsScore, n := searchNodes(pos, 4, true)
+		pvsNodes += n
+		alphaBetaScore, n := searchNodes(pos, 4, false)
+		alphaBetaNodes += n
+		if pvsScore != alphaBetaScore {
+			t.Errorf("%s: PVS scores %d, alpha-beta %d", tc.name, pvsScore, alphaBetaScore)
+		}
+	}
+	if pvsNodes >= alphaBetaNodes {
+		t.Errorf("PVS searched %d nodes, alpha-beta %d", pvsNodes, alphaBetaNodes)
+	}
+}
+
+// BenchmarkPVS reports the nodes each search needs to reach depth 4 on the
+// perft positions. Kiwipete makes one run slow, so -benchtime 1x is enough:
+//
+//	go test ./handlers -run '^$' -bench PVS -benchtime 1x
+func BenchmarkPVS(b *testing.B) {
+	for _, search := range []struct {
+		name string
+		pvs  bool
+	}{{"alphabeta", false}, {"pvs", true}} {
+		b.Run(search.name, func(b *testing.B) {
+			var nodes int64
+			for i := 0; i < b.N; i++ {
+				for _, tc := range perftPositions {
+					pos, err := ParseFEN(tc.fen)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, n := searchNodes(pos, 4, search.pvs)
+					nodes += n
+				}
+			}
+			b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MoveToSAN returns move, which must be legal in pos, in Standard Algebraic
+// Notation such as "Nbd7", "exd5", "e8=Q+" or "O-O-O#". pos is left unchanged.
+func MoveToSAN(pos *Position, move Move) string {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	kind := unicode.ToUpper(piece)
+
+	var sb strings.Builder
+	switch {
+	case kind == 'K' && abs(move.ToCol-move.FromCol) == 2:
+		if move.ToCol > move.FromCol {
+			sb.WriteString("O-O")
+		} else {
+			sb.WriteString("O-O-O")
+		}
+	case kind == 'P':
+		if isCapture(pos, move) {
+			sb.WriteByte(byte('a' + move.FromCol))
+			sb.WriteByte('x')
+		}
+		sb.WriteString(squareName(move.ToRow, move.ToCol))
+		if isPromotionMove(piece, move.ToRow) {
+			sb.WriteByte('=')
+			sb.WriteRune(unicode.ToUpper(promotedPiece(piece, move.Promotion)))
+		}
+	default:
+		sb.WriteRune(kind)
+		sb.WriteString(disambiguation(pos, move))
+		if isCapture(pos, move) {
+			sb.WriteByte('x')
+		}
+		sb.WriteString(squareName(move.ToRow, move.ToCol))
+	}
+
+	undo := pos.MakeMove(move)
+	row, col := findKing(pos.Board, pos.WhiteToMove)
+	if IsInCheck(pos.Board, pos.WhiteToMove, row, col) {
+		if len(generateLegalMoves(pos)) == 0 {
+			sb.WriteByte('#')
+		} else {
+			sb.WriteByte('+')
+		}
+	}
+	pos.UnmakeMove(move, undo)
+	return sb.String()
+}
+
+// disambiguation returns the file, rank or square SAN puts after the piece
+// letter when another piece of the same kind can also reach the target square.
+func disambiguation(pos *Position, move Move) string {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range generateLegalMoves(pos) {
+		if other.ToRow != move.ToRow || other.ToCol != move.ToCol ||
+			(other.FromRow == move.FromRow && other.FromCol == move.FromCol) ||
+			pos.Board[other.FromRow][other.FromCol] != piece {
+			continue
+		}
+		ambiguous = true
+		sameFile = sameFile || other.FromCol == move.FromCol
+		sameRank = sameRank || other.FromRow == move.FromRow
+	}
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return string(rune('a' + move.FromCol))
+	case !sameRank:
+		return string(rune('8' - move.FromRow))
+	}
+	return squareName(move.FromRow, move.FromCol)
+}
+
+// ParseSAN finds the legal move in pos written as san. Besides strict SAN it
+// accepts check marks and annotations ("Nf3+!?"), castling with zeros ("0-0"),
+// needless disambiguation ("Ngf3"), a missing "=" before the promotion piece and
+// a missing promotion piece, which means a queen.
+func ParseSAN(pos *Position, san string) (Move, error) {
+	s := strings.TrimSpace(san)
+	s = strings.TrimSuffix(s, "e.p.")
+	s = strings.TrimRight(s, "+#!? ")
+	if s == "" {
+		return Move{}, fmt.Errorf("invalid SAN: empty move")
+	}
+
+	legal := generateLegalMoves(pos)
+	switch strings.ReplaceAll(s, "0", "O") {
+	case "O-O", "O-O-O":
+		kingSide := len(s) == 3
+		for _, move := range legal {
+			piece := pos.Board[move.FromRow][move.FromCol]
+			if (piece == 'K' || piece == 'k') && abs(move.ToCol-move.FromCol) == 2 && (move.ToCol > move.FromCol) == kingSide {
+				return move, nil
+			}
+		}
+		return Move{}, fmt.Errorf("illegal move %q", san)
+	}
+
+	var promotion rune
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		if len(s) != i+2 || !strings.ContainsRune("QRBN", unicode.ToUpper(rune(s[i+1]))) {
+			return Move{}, fmt.Errorf("invalid SAN: bad promotion in %q", san)
+		}
+		promotion = unicode.ToUpper(rune(s[i+1]))
+		s = s[:i]
+	} else if last := rune(s[len(s)-1]); strings.ContainsRune("QRBN", last) {
+		promotion = last
+		s = s[:len(s)-1]
+	}
+
+	if len(s) < 2 {
+		return Move{}, fmt.Errorf("invalid SAN: %q", san)
+	}
+	toRow, toCol, ok := parseSquare(s[len(s)-2:])
+	if !ok {
+		return Move{}, fmt.Errorf("invalid SAN: bad target square in %q", san)
+	}
+	rest := s[:len(s)-2]
+
+	kind := 'P'
+	if rest != "" && strings.ContainsRune("KQRBNP", rune(rest[0])) {
+		kind = rune(rest[0])
+		rest = rest[1:]
+	}
+	rest = strings.TrimRight(rest, "x:-")
+	fromRow, fromCol := -1, -1
+	for _, c := range rest {
+		switch {
+		case c >= 'a' && c <= 'h':
+			fromCol = int(c - 'a')
+		case c >= '1' && c <= '8':
+			fromRow = int('8' - c)
+		default:
+			return Move{}, fmt.Errorf("invalid SAN: %q", san)
+		}
+	}
+
+	var found []Move
+	for _, move := range legal {
+		if move.ToRow != toRow || move.ToCol != toCol ||
+			unicode.ToUpper(pos.Board[move.FromRow][move.FromCol]) != kind ||
+			(fromRow >= 0 && move.FromRow != fromRow) ||
+			(fromCol >= 0 && move.FromCol != fromCol) {
+			continue
+		}
+		if move.Promotion != promotion && !(promotion == 0 && move.Promotion == 'Q') {
+			continue
+		}
+		found = append(found, move)
+	}
+	switch len(found) {
+	case 0:
+		return Move{}, fmt.Errorf("illegal move %q", san)
+	case 1:
+		return found[0], nil
+	}
+	return Move{}, fmt.Errorf("ambiguous move %q", san)
+}
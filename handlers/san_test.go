@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMoveToSAN(t *testing.T) {
+	tests := []struct {
+		fen  string
+		move string
+		san  string
+	}{
+		{StartFEN, "g1f3", "Nf3"},
+		{StartFEN, "e2e4", "e4"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", "O-O-O"},
+		// Knights on b1 and f3 both reach d2; rooks on a1 and a5 both reach a3.
+		{"4k3/8/8/R7/8/5N2/8/RN2K3 w - - 0 1", "b1d2", "Nbd2"},
+		{"4k3/8/8/R7/8/5N2/8/RN2K3 w - - 0 1", "a5a3", "R5a3"},
+		// Queens on a1, a3 and c1 all reach b2, so a1 needs file and rank.
+		{"4k3/8/8/8/8/Q7/8/Q1Q1K3 w - - 0 1", "a1b2", "Qa1b2"},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", "exd6"},
+		{"3rk3/2P5/8/8/8/8/8/4K3 w - - 0 1", "c7d8n", "cxd8=N"},
+		{"6k1/5ppp/8/8/8/8/8/R3K3 w - - 0 1", "a1a8", "Ra8#"},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		move, _ := ParseMove(tc.move)
+		if got := MoveToSAN(&pos, move); got != tc.san {
+			t.Errorf("MoveToSAN(%q, %s) = %q, want %q", tc.fen, tc.move, got, tc.san)
+		}
+		if got, err := ParseSAN(&pos, tc.san); err != nil || got != move {
+			t.Errorf("ParseSAN(%q, %q) = %v, %v; want %v", tc.fen, tc.san, got, err, move)
+		}
+	}
+}
+
+func TestParseSANVariants(t *testing.T) {
+	pos, _ := ParseFEN("r3k2r/1P6/8/8/8/8/8/R3K1NR w KQkq - 0 1")
+	tests := []struct {
+		san  string
+		move string
+	}{
+		{"0-0-0", "e1c1"},
+		{"Nf3!?", "g1f3"},
+		{"Ng1f3", "g1f3"},
+		{"bxa8Q+", "b7a8q"},
+		{"bxa8=n", "b7a8n"},
+		{"b8", "b7b8q"},
+	}
+	for _, tc := range tests {
+		want, _ := ParseMove(tc.move)
+		if got, err := ParseSAN(&pos, tc.san); err != nil || got != want {
+			t.Errorf("ParseSAN(%q) = %v, %v; want %v", tc.san, got, err, want)
+		}
+	}
+
+	for _, san := range []string{"", "Nf4", "Qd1", "e9", "Kd1d2", "O-O-O-O"} {
+		if move, err := ParseSAN(&pos, san); err == nil {
+			t.Errorf("ParseSAN(%q) = %v, want an error", san, move)
+		}
+	}
+	pos, _ = ParseFEN("4k3/8/8/8/8/8/K7/R6R w - - 0 1")
+	if _, err := ParseSAN(&pos, "Rd1"); err == nil {
+		t.Error("ParseSAN accepted the ambiguous Rd1")
+	}
+}
+
+// TestSANRoundTrip plays random games from the perft positions and checks that
+// every legal move's SAN parses back to the same move.
+func TestSANRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range perftPositions {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ply := 0; ply < 40; ply++ {
+			moves := generateLegalMoves(&pos)
+			if len(moves) == 0 {
+				break
+			}
+			for _, move := range moves {
+				san := MoveToSAN(&pos, move)
+				if got, err := ParseSAN(&pos, san); err != nil || got != move {
+					t.Fatalf("%s: ParseSAN(%q) = %v, %v; want %v", pos.FEN(), san, got, err, move)
+				}
+			}
+			pos.MakeMove(moves[rng.Intn(len(moves))])
+		}
+	}
+}
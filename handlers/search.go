@@ -0,0 +1,1053 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SearchInfo describes one completed iteration of FindBestMoveWithLimits.
+type SearchInfo struct {
+	Depth int
+	Score int // from White's point of view, like Evaluate_board
+	PV    []Move
+	Nodes int64
+	Time  time.Duration
+}
+
+// MaxSearchDepth is the deepest iteration FindBestMoveWithLimits will start.
+const MaxSearchDepth = 64
+
+// MateScore is the score of checkmate on the board. A mate found n plies below
+// the root scores MateScore-n for the winner and -(MateScore-n) for the loser, so
+// that the search prefers the fastest mate and the slowest defeat.
+const MateScore = 99999
+
+// mateThreshold separates mate scores from all others, tablebase wins included.
+const mateThreshold = MateScore - 2*MaxSearchDepth - 100
+
+// MatePlies reports whether score, from either side's point of view, is a mate
+// score and in how many plies the mate comes.
+func MatePlies(score int) (int, bool) {
+	if abs(score) < mateThreshold {
+		return 0, false
+	}
+	return MateScore - abs(score), true
+}
+
+// matedScore is the score, from the side to move's point of view, of pos when
+// it has no legal moves, ply moves below the root: mate if it is in check,
+// otherwise a stalemate and so a draw.
+func matedScore(pos *Position, ply int) int {
+	row, col := findKing(pos.Board, pos.WhiteToMove)
+	if !IsInCheck(pos.Board, pos.WhiteToMove, row, col) {
+		return 0
+	}
+	return -(MateScore - ply)
+}
+
+// relativeScore turns a score from White's point of view into one from the side
+// to move's, and back.
+func relativeScore(pos *Position, score int) int {
+	if pos.WhiteToMove {
+		return score
+	}
+	return -score
+}
+
+// scoreToTT converts a score found ply moves below the root into one relative to
+// the node itself, which is how the transposition table keeps mate and tablebase
+// scores so that they stay right when the position comes up at another ply.
+func scoreToTT(score, ply int) int {
+	switch {
+	case score >= tablebaseThreshold:
+		return score + ply
+	case score <= -tablebaseThreshold:
+		return score - ply
+	}
+	return score
+}
+
+// scoreFromTT undoes scoreToTT for a node ply moves below the root.
+func scoreFromTT(score, ply int) int {
+	switch {
+	case score >= tablebaseThreshold:
+		return score - ply
+	case score <= -tablebaseThreshold:
+		return score + ply
+	}
+	return score
+}
+
+// SearchLimits bounds a search. Zero fields are unlimited, so the zero value
+// searches until the context is cancelled.
+type SearchLimits struct {
+	MaxDepth  int           // deepest iteration to run
+	MoveTime  time.Duration // think exactly this long
+	TimeLeft  time.Duration // time left on our clock
+	Increment time.Duration // our increment per move
+	MovesToGo int           // moves until the next time control; 0 means sudden death
+	Nodes     int64         // node budget
+}
+
+// defaultMovesToGo is assumed for sudden-death time controls.
+const defaultMovesToGo = 30
+
+// moveOverhead is kept in hand for the GUI and process overhead.
+const moveOverhead = 50 * time.Millisecond
+
+// deadlines turns the limits into a soft deadline, after which no new iteration
+// starts, and a hard deadline, at which the running iteration is abandoned. Both
+// are measured from the start of the search; zero means none.
+func (l SearchLimits) deadlines() (soft, hard time.Duration) {
+	if l.MoveTime > 0 {
+		return l.MoveTime, l.MoveTime
+	}
+	if l.TimeLeft <= 0 {
+		return 0, 0
+	}
+
+	movesToGo := l.MovesToGo
+	if movesToGo <= 0 {
+		movesToGo = defaultMovesToGo
+	}
+	soft = l.TimeLeft/time.Duration(movesToGo) + l.Increment*3/4
+	hard = soft * 4
+
+	// Never plan to use more than what is left on the clock.
+	available := l.TimeLeft - moveOverhead
+	if available < 10*time.Millisecond {
+		available = 10 * time.Millisecond
+	}
+	if hard > available {
+		hard = available
+	}
+	if soft > hard {
+		soft = hard
+	}
+	return soft, hard
+}
+
+// SearchStats counts the work done by a Searcher since its last ResetStats.
+type SearchStats struct {
+	Searches        int64         // calls to FindBestMove, FindBestMoveWithLimits and SearchSpecificMoves
+	Time            time.Duration // total time spent in them
+	Nodes           int64         // negamax and quiescence nodes
+	QuiescenceNodes int64         // the part of Nodes spent in quiescence search
+	TTHits          int64         // nodes answered from the transposition table
+	TBHits          int64         // nodes answered from the endgame tablebases
+	NullCutoffs     int64         // nodes cut off by null-move pruning
+	BetaCutoffs     int64
+}
+
+// Searcher holds everything one search needs: the transposition table, the
+// killer and history tables used to order quiet moves, the limits of the search
+// in progress and its statistics. Independent Searchers can run concurrently on
+// different goroutines; a single Searcher runs one search at a time.
+//
+// With SetThreads a Searcher runs a Lazy SMP search: helper threads search the
+// same root alongside it and share its transposition table, so that the main
+// thread finds more of the tree already scored.
+type Searcher struct {
+	tt      *transpositionTable
+	helpers []*Searcher
+	book    *Book
+	tb      *Tablebase
+
+	// verifyNullMoves makes a null-move cutoff at high depth wait for a reduced
+	// search of the node itself; see SetNullMoveVerification.
+	verifyNullMoves bool
+
+	// exact turns off null-move pruning and late move reductions and pruning,
+	// which can change the score found, so that the search returns exactly the
+	// alpha-beta score. Tests compare node counts with it.
+	exact bool
+
+	// gameHistory holds the hashes of the game positions before the root, set
+	// with SetHistory; path adds those of the positions on the way to the node
+	// being searched, so that repetitions can be scored as draws.
+	gameHistory []uint64
+	path        []uint64
+
+	// killers holds the last two quiet moves per ply that caused a beta cutoff.
+	killers [MaxSearchDepth + 2][2]Move
+	// history scores quiet moves by how often they caused beta cutoffs rather
+	// than failing before one, indexed by side to move (0 for White), from
+	// square and to square. Entries stay within ±historyMax.
+	history [2][64][64]int
+	// counterMoves holds, by the from and to square of a move, the quiet move
+	// that last refuted it.
+	counterMoves [64][64]Move
+	// plyMoves holds the move played at each ply of the line being searched; a
+	// null move is stored as the zero Move.
+	plyMoves [MaxSearchDepth + 2]Move
+
+	// State of the search in progress.
+	nodes     atomic.Int64 // negamax and quiescence nodes so far; read by the main thread while helpers run
+	nodeLimit int64        // 0 means no node budget
+	deadline  time.Time    // hard deadline; zero means none
+	aborted   bool
+
+	Stats SearchStats
+}
+
+// NewSearcher returns a single-threaded Searcher with an empty transposition
+// table of DefaultHashMB megabytes.
+func NewSearcher() *Searcher {
+	return &Searcher{tt: newTranspositionTable(DefaultHashMB)}
+}
+
+// SetHashSize replaces the transposition table with an empty one of mb
+// megabytes, clamped to 1..MaxHashMB. It must not be called during a search.
+func (s *Searcher) SetHashSize(mb int) {
+	if mb < 1 {
+		mb = 1
+	}
+	if mb > MaxHashMB {
+		mb = MaxHashMB
+	}
+	s.tt = newTranspositionTable(mb)
+	for _, helper := range s.helpers {
+		helper.tt = s.tt
+	}
+}
+
+// MaxThreads is the largest thread count SetThreads accepts.
+const MaxThreads = 64
+
+// SetThreads sets the number of threads FindBestMoveWithLimits searches with,
+// clamped to 1..MaxThreads. It must not be called during a search.
+func (s *Searcher) SetThreads(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n > MaxThreads {
+		n = MaxThreads
+	}
+	for len(s.helpers) < n-1 {
+		s.helpers = append(s.helpers, &Searcher{tt: s.tt, tb: s.tb, gameHistory: s.gameHistory, verifyNullMoves: s.verifyNullMoves})
+	}
+	s.helpers = s.helpers[:n-1]
+}
+
+// Threads returns the number of threads FindBestMoveWithLimits searches with.
+func (s *Searcher) Threads() int {
+	return len(s.helpers) + 1
+}
+
+// SetBook makes FindBestMove and FindBestMoveWithLimits play from book, when it
+// has a move for the position, instead of searching. A nil book turns this off.
+func (s *Searcher) SetBook(book *Book) {
+	s.book = book
+}
+
+// Book returns the opening book set with SetBook, or nil.
+func (s *Searcher) Book() *Book {
+	return s.book
+}
+
+// SetTablebase makes the search use tb: at the root only moves that keep the
+// tablebase result are played, and the search scores positions tb covers exactly.
+// A nil tb turns this off. It must not be called during a search.
+func (s *Searcher) SetTablebase(tb *Tablebase) {
+	s.tb = tb
+	for _, helper := range s.helpers {
+		helper.tb = tb
+	}
+}
+
+// Tablebase returns the tablebase set with SetTablebase, or nil.
+func (s *Searcher) Tablebase() *Tablebase {
+	return s.tb
+}
+
+// SetNullMoveVerification turns on verification of null-move cutoffs: from
+// depth nullMoveVerifyDepth on, a node is only cut off once a reduced search
+// without a null move confirms it, which costs nodes but catches zugzwangs that
+// the pawn-ending rule misses. It is off by default and must not be changed
+// during a search.
+func (s *Searcher) SetNullMoveVerification(on bool) {
+	s.verifyNullMoves = on
+	for _, helper := range s.helpers {
+		helper.verifyNullMoves = on
+	}
+}
+
+// SetHistory tells the search which positions the game went through before the
+// one it is asked to search: hashes holds their GetZobristValue, oldest first.
+// The search scores a return to any of them, or to a position earlier on the
+// current line, as a draw. It must not be called during a search.
+func (s *Searcher) SetHistory(hashes []uint64) {
+	s.gameHistory = append([]uint64(nil), hashes...)
+	for _, helper := range s.helpers {
+		helper.gameHistory = s.gameHistory
+	}
+}
+
+// NewGame forgets everything learned from earlier searches.
+func (s *Searcher) NewGame() {
+	s.tt.clear()
+	for _, t := range append([]*Searcher{s}, s.helpers...) {
+		t.killers = [MaxSearchDepth + 2][2]Move{}
+		t.history = [2][64][64]int{}
+		t.counterMoves = [64][64]Move{}
+	}
+}
+
+// ResetStats clears the statistics; useful between moves.
+func (s *Searcher) ResetStats() {
+	s.Stats = SearchStats{}
+}
+
+// beginSearch resets the per-search state for a search started at start. The hard
+// deadline is the earlier of start+hard and the deadline of ctx.
+func (s *Searcher) beginSearch(ctx context.Context, start time.Time, hard time.Duration, nodes int64) {
+	s.Stats.Searches++
+	s.nodes.Store(0)
+	s.nodeLimit = nodes
+	s.deadline = time.Time{}
+	if hard > 0 {
+		s.deadline = start.Add(hard)
+	}
+	// The deadline is also checked by hand: under js/wasm a busy search never
+	// yields to the scheduler, so the context's own timer cannot fire.
+	if deadline, ok := ctx.Deadline(); ok && (s.deadline.IsZero() || deadline.Before(s.deadline)) {
+		s.deadline = deadline
+	}
+	s.aborted = false
+	s.path = append(s.path[:0], s.gameHistory...)
+
+	// Age the history so that the last search counts more than older ones.
+	for side := range s.history {
+		for from := range s.history[side] {
+			for to := range s.history[side][from] {
+				s.history[side][from][to] /= 2
+			}
+		}
+	}
+}
+
+// stopped reports whether the search must be abandoned: ctx is done, the node
+// budget is used up or the hard deadline has passed. ctx and the clock are only
+// checked every 1024 nodes.
+func (s *Searcher) stopped(ctx context.Context) bool {
+	if s.aborted {
+		return true
+	}
+	nodes := s.nodes.Load()
+	if s.nodeLimit > 0 && nodes >= s.nodeLimit {
+		s.aborted = true
+	} else if nodes&1023 == 0 {
+		s.aborted = ctx.Err() != nil ||
+			(!s.deadline.IsZero() && time.Now().After(s.deadline))
+	}
+	return s.aborted
+}
+
+// FindBestMove searches pos for the side to move and returns the best move found.
+// Cancelling ctx ends the search early. pos is not modified.
+func (s *Searcher) FindBestMove(ctx context.Context, pos *Position) Move {
+	const targetDepth = 3
+	return s.FindBestMoveWithLimits(ctx, pos, SearchLimits{MaxDepth: targetDepth}, nil)
+}
+
+// FindBestMoveWithLimits returns a book move if the book set with SetBook has one
+// for pos. Otherwise it runs the iterative-deepening search on pos within limits
+// and returns the best move of the last iteration that finished. No new iteration
+// starts once the soft deadline has passed, and the hard deadline, the node budget
+// or cancelling ctx abort the current one. After each completed iteration onInfo, if
+// non-nil, is called with the depth, score and principal variation. pos is not
+// modified, and a zero Move is returned when the side to move has no legal moves.
+//
+// Helper threads set up with SetThreads search until the main thread is done;
+// only the main thread's iterations are reported and decide the move. The node
+// budget applies to the main thread, while reported node counts include helpers.
+func (s *Searcher) FindBestMoveWithLimits(ctx context.Context, pos *Position, limits SearchLimits, onInfo func(SearchInfo)) Move {
+	if s.book != nil {
+		if move, ok := s.book.PickMove(pos); ok {
+			return move
+		}
+	}
+
+	start := time.Now()
+	defer func() { s.Stats.Time += time.Since(start) }()
+	soft, hard := limits.deadlines()
+	s.beginSearch(ctx, start, hard, limits.Nodes)
+	s.tt.newSearch()
+
+	// The best move of an earlier search of the position goes first, and after
+	// every iteration the best move of that iteration.
+	root := *pos
+	initial_hash := GetZobristValue(&root)
+	var hashMove Move
+	if entry, found := s.tt.probe(initial_hash); found {
+		hashMove = entry.BestMove
+	}
+	allMoves := s.rootMoves(&root, hashMove)
+	if len(allMoves) == 0 {
+		return Move{}
+	}
+
+	// In a tablebase position a won or lost game is played by DTZ, which always
+	// makes progress; a drawn one is searched among the moves that hold the draw.
+	if s.tb != nil {
+		if tbMoves, wdl, ok := s.tb.RootMoves(&root); ok {
+			s.Stats.TBHits++
+			if wdl != WDLDraw {
+				if onInfo != nil {
+					onInfo(SearchInfo{
+						Depth: 1,
+						Score: tablebaseScore(&root, wdl, 0),
+						PV:    tbMoves[:1],
+						Nodes: s.totalNodes(),
+						Time:  time.Since(start),
+					})
+				}
+				return tbMoves[0]
+			}
+			allMoves = tbMoves
+		}
+	}
+
+	maxDepth := limits.MaxDepth
+	if maxDepth <= 0 || maxDepth > MaxSearchDepth {
+		maxDepth = MaxSearchDepth
+	}
+
+	if len(s.helpers) > 0 {
+		helperCtx, stopHelpers := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		for i, helper := range s.helpers {
+			wg.Add(1)
+			go func(id int, helper *Searcher, root Position, moves []Move) {
+				defer wg.Done()
+				helper.helperSearch(helperCtx, root, moves, start, hard, maxDepth, id)
+			}(i+1, helper, root, append([]Move(nil), allMoves...))
+		}
+		defer func() {
+			stopHelpers()
+			wg.Wait()
+			s.collectHelperStats()
+		}()
+	}
+
+	// Aspiration Search with Iterative Deepening, with scores from the side to
+	// move's point of view until they are reported
+	const aspirationWindow = 25
+	const infinity = 100000
+	const negInfinity = -100000
+
+	var bestMove Move = allMoves[0]
+	var previousScore int = 0
+
+	for depth := 1; depth <= maxDepth; depth++ {
+		// The next iteration takes several times longer than the last one, so
+		// starting it after the soft deadline would only be cut off by the hard one.
+		if depth > 1 && soft > 0 && time.Since(start) >= soft {
+			break
+		}
+
+		var alpha, beta int
+		var score int
+		var move Move
+
+		if depth > 1 {
+			alpha = previousScore - aspirationWindow
+			beta = previousScore + aspirationWindow
+
+			score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, allMoves)
+
+			if score <= alpha {
+				alpha = negInfinity
+				beta = previousScore + aspirationWindow
+				score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, allMoves)
+			} else if score >= beta {
+				alpha = previousScore - aspirationWindow
+				beta = infinity
+				score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, allMoves)
+			}
+		} else {
+			alpha = negInfinity
+			beta = infinity
+			score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, allMoves)
+		}
+
+		// An interrupted iteration has only looked at some root moves; keep the
+		// result of the last one that finished.
+		if s.stopped(ctx) {
+			break
+		}
+		bestMove = move
+		previousScore = score
+		moveToFront(allMoves, bestMove)
+
+		learnedInfo := HashMap{
+			HashKey:  initial_hash,
+			Score:    score,
+			Depth:    depth,
+			Bound:    boundFor(score, alpha, beta),
+			BestMove: bestMove,
+		}
+		s.tt.store(learnedInfo)
+
+		if onInfo != nil {
+			onInfo(SearchInfo{
+				Depth: depth,
+				Score: relativeScore(&root, score),
+				PV:    s.principalVariation(&root, bestMove, depth+1),
+				Nodes: s.totalNodes(),
+				Time:  time.Since(start),
+			})
+		}
+	}
+
+	return bestMove
+}
+
+// helperSearch is the iterative deepening loop of Lazy SMP helper id over the
+// root moves ordered, the same the main thread searches. Its results only reach
+// the main thread through the shared transposition table. Helpers start at
+// alternating depths and try the root moves in a rotated order so that they fill
+// the table with different parts of the tree than the main thread.
+func (s *Searcher) helperSearch(ctx context.Context, root Position, ordered []Move, start time.Time, hard time.Duration, maxDepth, id int) {
+	const infinity = 100000
+	const negInfinity = -100000
+
+	s.beginSearch(ctx, start, hard, 0)
+	shift := id % len(ordered)
+	moves := append(append([]Move{}, ordered[shift:]...), ordered[:shift]...)
+	hash := GetZobristValue(&root)
+
+	for depth := 1 + id%2; depth <= maxDepth; depth++ {
+		s.searchRootMoves(ctx, &root, depth, negInfinity, infinity, hash, moves)
+		if s.stopped(ctx) {
+			return
+		}
+	}
+}
+
+// totalNodes returns the nodes searched so far by s and its helpers.
+func (s *Searcher) totalNodes() int64 {
+	nodes := s.nodes.Load()
+	for _, helper := range s.helpers {
+		nodes += helper.nodes.Load()
+	}
+	return nodes
+}
+
+// collectHelperStats adds the work of the helpers to s.Stats once they have stopped.
+func (s *Searcher) collectHelperStats() {
+	for _, helper := range s.helpers {
+		s.Stats.Nodes += helper.Stats.Nodes
+		s.Stats.QuiescenceNodes += helper.Stats.QuiescenceNodes
+		s.Stats.TTHits += helper.Stats.TTHits
+		s.Stats.TBHits += helper.Stats.TBHits
+		s.Stats.NullCutoffs += helper.Stats.NullCutoffs
+		s.Stats.BetaCutoffs += helper.Stats.BetaCutoffs
+		helper.ResetStats()
+	}
+}
+
+// SearchSpecificMoves searches only movesToSearch from pos for the side to move.
+// If ctx is cancelled or its deadline passes, the best move and score of the last
+// completed iteration are returned. pos is not modified.
+func (s *Searcher) SearchSpecificMoves(ctx context.Context, pos *Position, movesToSearch []Move) (Move, int) {
+	if len(movesToSearch) == 0 {
+		return Move{}, 0
+	}
+	start := time.Now()
+	defer func() { s.Stats.Time += time.Since(start) }()
+	s.beginSearch(ctx, start, 0, 0)
+	s.tt.newSearch()
+
+	const targetDepth = 3
+	const aspirationWindow = 25
+	const infinity = 100000
+	const negInfinity = -100000
+
+	root := *pos
+	initial_hash := GetZobristValue(&root)
+	moves := append([]Move(nil), movesToSearch...)
+	var bestMove Move = moves[0]
+	var bestScore int
+	var previousScore int = 0
+
+	// Iterative deepening for this subset
+	for depth := 1; depth <= targetDepth; depth++ {
+		var alpha, beta int
+		var score int
+		var move Move
+
+		if depth > 1 {
+			alpha = previousScore - aspirationWindow
+			beta = previousScore + aspirationWindow
+
+			score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, moves)
+
+			if score <= alpha {
+				alpha = negInfinity
+				beta = previousScore + aspirationWindow
+				score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, moves)
+			} else if score >= beta {
+				alpha = previousScore - aspirationWindow
+				beta = infinity
+				score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, moves)
+			}
+		} else {
+			alpha = negInfinity
+			beta = infinity
+			score, move = s.searchRootMoves(ctx, &root, depth, alpha, beta, initial_hash, moves)
+		}
+
+		if s.stopped(ctx) {
+			break
+		}
+		bestMove = move
+		bestScore = score
+		previousScore = score
+		moveToFront(moves, bestMove)
+	}
+
+	return bestMove, relativeScore(&root, bestScore)
+}
+
+// searchRootMoves searches each of moves from pos to depth and returns the best
+// score, from the side to move's point of view, and move within alpha/beta. Like
+// negamax it searches the first move with the full window and the others with a
+// zero window first.
+func (s *Searcher) searchRootMoves(ctx context.Context, pos *Position, depth int, alpha, beta int, initial_hash uint64, moves []Move) (int, Move) {
+	const negInfinity = -100000
+
+	var bestMove Move = moves[0]
+	bestScore := negInfinity
+
+	s.path = append(s.path, initial_hash)
+	defer func() { s.path = s.path[:len(s.path)-1] }()
+	for i, move := range moves {
+		new_hash := UpdateHashForMove(initial_hash, move, pos)
+		undo := pos.MakeMove(move)
+		s.plyMoves[0] = move
+		score := s.searchMove(ctx, pos, i == 0, depth, 0, 1, alpha, beta, new_hash)
+		pos.UnmakeMove(move, undo)
+
+		if score > bestScore {
+			bestScore = score
+			bestMove = move
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break // Beta cutoff
+		}
+	}
+
+	return bestScore, bestMove
+}
+
+// searchMove scores the position reached by a move, searched to depth, for the
+// side that made it. Unless it is the first move it gets a zero window above
+// alpha, which is cheap to refute, and only a move that beats alpha without
+// reaching beta is searched again with the full window to find its exact score.
+// A late move is first searched reduction plies shallower, and again to the
+// full depth if it beats alpha all the same.
+func (s *Searcher) searchMove(ctx context.Context, pos *Position, first bool, depth, reduction, ply int, alpha, beta int, hash uint64) int {
+	if first {
+		return -s.negamax(ctx, pos, depth, ply, -beta, -alpha, hash, true)
+	}
+	score := -s.negamax(ctx, pos, depth-reduction, ply, -alpha-1, -alpha, hash, true)
+	if reduction > 0 && score > alpha {
+		score = -s.negamax(ctx, pos, depth, ply, -alpha-1, -alpha, hash, true)
+	}
+	if score > alpha && score < beta {
+		score = -s.negamax(ctx, pos, depth, ply, -beta, -alpha, hash, true)
+	}
+	return score
+}
+
+// quiescenceSearch searches captures until the position is quiet and returns
+// its score from the side to move's point of view, which may stand pat on the
+// static evaluation.
+func (s *Searcher) quiescenceSearch(ctx context.Context, pos *Position, alpha, beta int) int {
+	s.nodes.Add(1)
+	s.Stats.Nodes++
+	s.Stats.QuiescenceNodes++
+	if s.stopped(ctx) {
+		return 0
+	}
+	base_score := relativeScore(pos, Evaluate_board(pos.Board))
+	if base_score >= beta {
+		return beta
+	}
+	if base_score > alpha {
+		alpha = base_score
+	}
+	for _, move := range GenerateCaptureMoves(pos) {
+		undo := pos.MakeMove(move)
+		score := -s.quiescenceSearch(ctx, pos, -beta, -alpha)
+		pos.UnmakeMove(move, undo)
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha
+}
+
+// negamax searches pos to depth, ply moves below the root, and returns its score
+// from the side to move's point of view. It is a principal variation search: see
+// searchMove. The transposition table keeps scores the same way. allowNull is
+// false right after a null move, so that two never follow each other.
+func (s *Searcher) negamax(ctx context.Context, pos *Position, depth, ply int, alpha int, beta int, current_hash uint64, allowNull bool) int {
+	s.nodes.Add(1)
+	s.Stats.Nodes++
+	if s.stopped(ctx) {
+		return 0
+	}
+
+	// Repeating a position, or reaching the fifty-move limit, lets the side that
+	// is worse off claim a draw, so neither side can expect more than that.
+	if repetitions(current_hash, s.path, pos.HalfmoveClock) > 0 ||
+		(pos.HalfmoveClock >= 100 && !isCheckmate(pos)) {
+		return 0
+	}
+
+	// A stored score settles this node if it is exact, or if its bound already
+	// falls outside the window; otherwise it can still narrow the window. The
+	// stored move is tried first either way.
+	var hashMove Move
+	if entry, found := s.tt.probe(current_hash); found {
+		hashMove = entry.BestMove
+		if entry.Depth >= depth {
+			score := scoreFromTT(entry.Score, ply)
+			switch entry.Bound {
+			case BoundExact:
+				s.Stats.TTHits++
+				return score
+			case BoundLower:
+				if score > alpha {
+					alpha = score
+				}
+			case BoundUpper:
+				if score < beta {
+					beta = score
+				}
+			}
+			if alpha >= beta {
+				s.Stats.TTHits++
+				return score
+			}
+		}
+	}
+	alphaOrig, betaOrig := alpha, beta
+
+	// Right after a capture or pawn move the tablebase result is exact; later on
+	// the fifty-move counter it does not know about could matter.
+	if s.tb != nil && pos.HalfmoveClock == 0 {
+		if wdl, ok := s.tb.ProbeWDL(pos); ok {
+			s.Stats.TBHits++
+			return relativeScore(pos, tablebaseScore(pos, wdl, ply))
+		}
+	}
+
+	if depth == 0 {
+		return s.quiescenceSearch(ctx, pos, alpha, beta)
+	}
+
+	// Null-move pruning, only in zero-window nodes and never in check, where
+	// passing is illegal, or with only pawns left, where zugzwang is common.
+	kingRow, kingCol := findKing(pos.Board, pos.WhiteToMove)
+	inCheck := IsInCheck(pos.Board, pos.WhiteToMove, kingRow, kingCol)
+	if allowNull && !s.exact && !inCheck && depth >= nullMoveMinDepth && beta-alpha == 1 &&
+		abs(beta) < mateThreshold && hasPieces(pos.Board, pos.WhiteToMove) {
+		if eval := relativeScore(pos, Evaluate_board(pos.Board)); eval >= beta {
+			if score, ok := s.nullMoveCutoff(ctx, pos, depth, ply, beta, eval, current_hash); ok {
+				return score
+			}
+		}
+	}
+
+	// The picker only generates quiet moves once the hash move and the captures
+	// have failed to cut off.
+	picker := s.newMovePicker(pos, ply, hashMove)
+	s.path = append(s.path, current_hash)
+
+	var killers [2]Move
+	if ply < len(s.killers) {
+		killers = s.killers[ply]
+	}
+	pvNode := beta-alpha > 1
+
+	// The quiet moves searched before a cutoff lose history score.
+	var quietsTried [64]Move
+	triedCount := 0
+
+	var bestMove Move
+	bestScore := -100000
+	moveCount := 0
+	for move, ok := picker.nextMove(); ok; move, ok = picker.nextMove() {
+		i := moveCount
+		moveCount++
+		isQuiet := isQuietMove(pos, move)
+		quiet := isQuiet && move != killers[0] && move != killers[1]
+		new_hash := UpdateHashForMove(current_hash, move, pos)
+		undo := pos.MakeMove(move)
+		checkRow, checkCol := findKing(pos.Board, pos.WhiteToMove)
+		givesCheck := IsInCheck(pos.Board, pos.WhiteToMove, checkRow, checkCol)
+
+		// Quiet moves that are neither killers nor checks come last in the
+		// ordering and seldom matter: near the leaves, once a move has kept the
+		// side to move out of a forced loss, the latest are skipped, and deeper
+		// down they are searched to a reduced depth first.
+		late := i > 0 && quiet && !inCheck && !givesCheck && !s.exact
+		if late && !pvNode && depth <= lmpMaxDepth && i >= lateMoveCount(depth) && bestScore > -mateThreshold {
+			pos.UnmakeMove(move, undo)
+			continue
+		}
+		reduction := 0
+		if late && depth >= lmrMinDepth && i >= lmrMinMoves {
+			reduction = lmrReductions[min(depth, MaxSearchDepth)][min(i, 63)]
+			if pvNode {
+				reduction--
+			}
+			reduction = max(min(reduction, depth-2), 0)
+		}
+
+		if ply < len(s.plyMoves) {
+			s.plyMoves[ply] = move
+		}
+		score := s.searchMove(ctx, pos, i == 0, depth-1, reduction, ply+1, alpha, beta, new_hash)
+		pos.UnmakeMove(move, undo)
+
+		if score > bestScore {
+			bestScore = score
+			bestMove = move
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			s.recordCutoff(pos, move, depth, ply, quietsTried[:triedCount])
+			break
+		}
+		if isQuiet && triedCount < len(quietsTried) {
+			quietsTried[triedCount] = move
+			triedCount++
+		}
+	}
+
+	s.path = s.path[:len(s.path)-1]
+	if moveCount == 0 {
+		return matedScore(pos, ply)
+	}
+
+	// Scores from an interrupted search are not trustworthy; keep them out of the table.
+	if s.stopped(ctx) {
+		return 0
+	}
+
+	s.tt.store(HashMap{
+		HashKey:  current_hash,
+		Score:    scoreToTT(bestScore, ply),
+		Depth:    depth,
+		Bound:    boundFor(bestScore, alphaOrig, betaOrig),
+		BestMove: bestMove,
+	})
+
+	return bestScore
+}
+
+// Late move reductions start at lmrMinDepth with the lmrMinMoves-th move (counted
+// from 0); late move pruning works up to lmpMaxDepth.
+const (
+	lmrMinDepth = 3
+	lmrMinMoves = 3
+	lmpMaxDepth = 3
+)
+
+// lmrReductions[depth][i] is the number of plies the i-th move of a node searched
+// to depth is reduced by. It grows with the logarithm of both, so late moves of
+// deep nodes lose the most.
+var lmrReductions [MaxSearchDepth + 1][64]int
+
+func init() {
+	for depth := 1; depth <= MaxSearchDepth; depth++ {
+		for i := 1; i < 64; i++ {
+			lmrReductions[depth][i] = int(0.75 + math.Log(float64(depth))*math.Log(float64(i))/2.25)
+		}
+	}
+}
+
+// lateMoveCount is the number of moves tried at depth before late move pruning
+// starts skipping quiet ones.
+func lateMoveCount(depth int) int {
+	return 3 + depth*depth
+}
+
+// Null-move pruning starts at nullMoveMinDepth; verification, when turned on
+// with SetNullMoveVerification, at nullMoveVerifyDepth.
+const (
+	nullMoveMinDepth    = 3
+	nullMoveVerifyDepth = 5
+)
+
+// nullMoveCutoff lets the opponent move twice in a row and searches the result
+// to a reduced depth with a zero window at beta. If the side to move still
+// reaches beta without moving, a real move would almost always do better, so the
+// node fails high with the returned score. The reduction grows with depth and
+// with the margin of the static evaluation eval over beta.
+func (s *Searcher) nullMoveCutoff(ctx context.Context, pos *Position, depth, ply, beta, eval int, hash uint64) (int, bool) {
+	reduction := 2 + depth/4
+	if eval-beta > 200 {
+		reduction++
+	}
+	reduced := max(depth-1-reduction, 0)
+
+	nullHash := UpdateHashForNullMove(hash, pos)
+	undo := pos.MakeNullMove()
+	if ply < len(s.plyMoves) {
+		s.plyMoves[ply] = Move{}
+	}
+	s.path = append(s.path, hash)
+	score := -s.negamax(ctx, pos, reduced, ply+1, -beta, -beta+1, nullHash, false)
+	s.path = s.path[:len(s.path)-1]
+	pos.UnmakeNullMove(undo)
+
+	if score < beta {
+		return 0, false
+	}
+	// A mate found after passing is no proof of a mate with a real move.
+	if score >= mateThreshold {
+		score = beta
+	}
+	if s.verifyNullMoves && depth >= nullMoveVerifyDepth &&
+		s.negamax(ctx, pos, reduced, ply, beta-1, beta, hash, false) < beta {
+		return 0, false
+	}
+	s.Stats.NullCutoffs++
+	return score, true
+}
+
+// hasPieces reports whether the side given by white has anything besides its
+// king and pawns.
+func hasPieces(board [8][8]rune, white bool) bool {
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			switch piece := board[row][col]; piece {
+			case 0, 'K', 'k', 'P', 'p':
+			default:
+				if isWhite(piece) == white {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isQuietMove reports whether move neither captures nor promotes.
+func isQuietMove(pos *Position, move Move) bool {
+	return pos.Board[move.ToRow][move.ToCol] == 0 && move.Promotion == 0 && !pos.isEnPassantCapture(move)
+}
+
+// sideIndex is the first index into the history table for the side to move.
+func sideIndex(pos *Position) int {
+	if pos.WhiteToMove {
+		return 0
+	}
+	return 1
+}
+
+// historyMax bounds the history scores; see updateHistory.
+const historyMax = 16384
+
+// updateHistory adds bonus, which may be negative, to a history score. The
+// gravity term shrinks the change as the score nears ±historyMax, so a score
+// never leaves that range and moves that stop working lose their rank quickly.
+func updateHistory(score *int, bonus int) {
+	*score += bonus - *score*abs(bonus)/historyMax
+}
+
+// counterMove returns the quiet move that last refuted the move played just
+// before ply, or the zero Move.
+func (s *Searcher) counterMove(ply int) Move {
+	if ply == 0 || ply > len(s.plyMoves) {
+		return Move{}
+	}
+	prev := s.plyMoves[ply-1]
+	return s.counterMoves[prev.FromRow*8+prev.FromCol][prev.ToRow*8+prev.ToCol]
+}
+
+// recordCutoff is called when move caused a beta cutoff at ply after the quiet
+// moves in tried failed to. A quiet move becomes a killer for ply and the
+// counter move to the move before it, and gains history score, more so the
+// deeper the search, while the moves in tried lose as much.
+func (s *Searcher) recordCutoff(pos *Position, move Move, depth, ply int, tried []Move) {
+	s.Stats.BetaCutoffs++
+	if !isQuietMove(pos, move) {
+		return
+	}
+	if ply < len(s.killers) && s.killers[ply][0] != move {
+		s.killers[ply][1] = s.killers[ply][0]
+		s.killers[ply][0] = move
+	}
+	if ply > 0 && ply <= len(s.plyMoves) {
+		if prev := s.plyMoves[ply-1]; prev != (Move{}) {
+			s.counterMoves[prev.FromRow*8+prev.FromCol][prev.ToRow*8+prev.ToCol] = move
+		}
+	}
+
+	bonus := min(32*depth*depth, historyMax/4)
+	history := &s.history[sideIndex(pos)]
+	updateHistory(&history[move.FromRow*8+move.FromCol][move.ToRow*8+move.ToCol], bonus)
+	for _, m := range tried {
+		updateHistory(&history[m.FromRow*8+m.FromCol][m.ToRow*8+m.ToCol], -bonus)
+	}
+}
+
+// moveToFront moves move, if it is among moves, to the front and keeps the
+// order of the others. The principal variation search relies on its first move
+// being the best, and the best move of the last iteration usually is.
+func moveToFront(moves []Move, move Move) {
+	for i, m := range moves {
+		if m == move {
+			copy(moves[1:i+1], moves[:i])
+			moves[0] = m
+			return
+		}
+	}
+}
+
+// isLegalMove reports whether move is one of the legal moves in pos.
+func isLegalMove(pos *Position, move Move) bool {
+	for _, m := range generateLegalMoves(pos) {
+		if m == move {
+			return true
+		}
+	}
+	return false
+}
+
+// principalVariation starts with first and follows the best moves stored in the
+// transposition table, stopping at maxLen moves or at the first missing or illegal
+// entry. pos is not modified.
+func (s *Searcher) principalVariation(pos *Position, first Move, maxLen int) []Move {
+	p := *pos
+	pv := []Move{first}
+	p.MakeMove(first)
+	for len(pv) < maxLen {
+		hash := GetZobristValue(&p)
+		entry, found := s.tt.probe(hash)
+		if !found || !isLegalMove(&p, entry.BestMove) {
+			break
+		}
+		pv = append(pv, entry.BestMove)
+		p.MakeMove(entry.BestMove)
+	}
+	return pv
+}
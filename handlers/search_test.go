@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentSearchers runs one Searcher per position on its own goroutine and
+// checks that each finds the same move as a search run on its own. Run it with
+// -race to check that Searchers share no mutable state.
+func TestConcurrentSearchers(t *testing.T) {
+	limits := SearchLimits{MaxDepth: 3, Nodes: 2000}
+	var positions []Position
+	var want []Move
+	for _, tc := range perftPositions {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, pos)
+		want = append(want, NewSearcher().FindBestMoveWithLimits(context.Background(), &pos, limits, nil))
+	}
+
+	got := make([]Move, len(positions))
+	var wg sync.WaitGroup
+	for i := range positions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = NewSearcher().FindBestMoveWithLimits(context.Background(), &positions[i], limits, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, tc := range perftPositions {
+		if got[i] != want[i] {
+			t.Errorf("%s: concurrent search played %v, sequential search %v", tc.name, got[i], want[i])
+		}
+	}
+}
+
+// TestLazySMP runs a multi-threaded search under the race detector and checks
+// that it still returns a legal move and counts the helpers' nodes.
+func TestLazySMP(t *testing.T) {
+	pos, err := ParseFEN(perftPositions[0].fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher()
+	s.SetThreads(4)
+	var infoNodes int64
+	move := s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 2}, func(info SearchInfo) {
+		infoNodes = info.Nodes
+	})
+	if !isLegalMove(&pos, move) {
+		t.Fatalf("search returned illegal move %v", move)
+	}
+	if s.Stats.Nodes < infoNodes {
+		t.Errorf("Stats.Nodes = %d, want at least the %d nodes reported during the search", s.Stats.Nodes, infoNodes)
+	}
+}
+
+// BenchmarkSearchThreads reports the nodes per second of a fixed-depth search
+// for several thread counts; with Lazy SMP nps should grow with the threads as
+// long as there are cores for them.
+func BenchmarkSearchThreads(b *testing.B) {
+	pos, err := ParseFEN("r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			var nodes int64
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				s := NewSearcher()
+				s.SetThreads(threads)
+				s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 3}, nil)
+				nodes += s.Stats.Nodes
+			}
+			b.ReportMetric(float64(nodes)/time.Since(start).Seconds(), "nps")
+		})
+	}
+}
+
+// TestNullMovePruning checks that null-move pruning cuts off nodes in a
+// middlegame, that verification keeps the move, and that it never fires in a
+// pawn ending, where zugzwang is common.
+func TestNullMovePruning(t *testing.T) {
+	pos, _ := ParseFEN("r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3")
+	s := NewSearcher()
+	s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 4}, nil)
+	if s.Stats.NullCutoffs == 0 {
+		t.Error("no null-move cutoffs in a middlegame search")
+	}
+
+	// Verified cutoffs play the same move in a rook ending.
+	pos, _ = ParseFEN("6k1/5pp1/7p/8/8/7P/5PP1/3R2K1 w - - 0 1")
+	want := NewSearcher().FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 6}, nil)
+	s = NewSearcher()
+	s.SetNullMoveVerification(true)
+	if got := s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 6}, nil); got != want {
+		t.Errorf("with verification the search plays %v, without %v", got, want)
+	}
+
+	pos, _ = ParseFEN("8/5k2/3p4/1p1P1p2/1P3P2/4K3/8/8 w - - 0 1")
+	s = NewSearcher()
+	s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 6}, nil)
+	if s.Stats.NullCutoffs != 0 {
+		t.Errorf("%d null-move cutoffs in a pawn ending", s.Stats.NullCutoffs)
+	}
+}
+
+// TestLateMoveReductions checks that a middlegame search gets deeper on a fixed
+// node budget than it did before late move reductions and pruning, when it
+// reached depth 4.
+func TestLateMoveReductions(t *testing.T) {
+	pos, _ := ParseFEN("r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3")
+	depth := 0
+	NewSearcher().FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{Nodes: 40000}, func(info SearchInfo) {
+		depth = info.Depth
+	})
+	if depth < 5 {
+		t.Errorf("reached depth %d on 40000 nodes, want at least 5", depth)
+	}
+}
+
+// TestRecordCutoff checks the move-ordering tables after a quiet cutoff: the
+// move becomes a killer and the counter move to the move before it, and gains
+// the history score the quiet moves tried before it lose.
+func TestRecordCutoff(t *testing.T) {
+	pos, _ := ParseFEN(StartFEN)
+	prev, _ := ParseMove("e7e5")
+	cutoff, _ := ParseMove("g1f3")
+	tried, _ := ParseMove("a2a3")
+
+	s := NewSearcher()
+	s.plyMoves[2] = prev
+	s.recordCutoff(&pos, cutoff, 4, 3, []Move{tried})
+	if s.killers[3][0] != cutoff {
+		t.Errorf("killers at ply 3 = %v, want %v first", s.killers[3], cutoff)
+	}
+	if got := s.counterMove(3); got != cutoff {
+		t.Errorf("counter move to %v = %v, want %v", prev, got, cutoff)
+	}
+	good := s.history[0][cutoff.FromRow*8+cutoff.FromCol][cutoff.ToRow*8+cutoff.ToCol]
+	bad := s.history[0][tried.FromRow*8+tried.FromCol][tried.ToRow*8+tried.ToCol]
+	if good <= 0 || bad != -good {
+		t.Errorf("history of the cutoff move = %d and of the move tried before = %d", good, bad)
+	}
+
+	picker := s.newMovePicker(&pos, 3, Move{})
+	var moves []Move
+	for move, ok := picker.nextMove(); ok; move, ok = picker.nextMove() {
+		moves = append(moves, move)
+	}
+	if moves[0] != cutoff || moves[len(moves)-1] != tried {
+		t.Errorf("picked moves start with %v and end with %v", moves[0], moves[len(moves)-1])
+	}
+}
+
+// TestHistoryGravity checks that history scores stay within ±historyMax however
+// often a move is rewarded or punished.
+func TestHistoryGravity(t *testing.T) {
+	score := 0
+	for i := 0; i < 1000; i++ {
+		updateHistory(&score, historyMax/4)
+	}
+	if score <= 0 || score > historyMax {
+		t.Errorf("after many bonuses the score is %d, want at most %d", score, historyMax)
+	}
+	for i := 0; i < 1000; i++ {
+		updateHistory(&score, -historyMax/4)
+	}
+	if score >= 0 || score < -historyMax {
+		t.Errorf("after many penalties the score is %d, want at least %d", score, -historyMax)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+// GameStatus tells whether the side to move can still play.
+type GameStatus int
+
+const (
+	Ongoing GameStatus = iota
+	Checkmate
+	Stalemate
+)
+
+func (s GameStatus) String() string {
+	switch s {
+	case Checkmate:
+		return "checkmate"
+	case Stalemate:
+		return "stalemate"
+	}
+	return "ongoing"
+}
+
+// Status reports whether the side to move is checkmated, stalemated or has a
+// legal move. Draws by rule are found by CheckDraw.
+func (pos *Position) Status() GameStatus {
+	if len(generateLegalMoves(pos)) > 0 {
+		return Ongoing
+	}
+	row, col := findKing(pos.Board, pos.WhiteToMove)
+	if IsInCheck(pos.Board, pos.WhiteToMove, row, col) {
+		return Checkmate
+	}
+	return Stalemate
+}
+
+// Result returns the PGN result of a game that has reached pos: "1-0" or "0-1"
+// after a checkmate, "1/2-1/2" after a stalemate or a draw CheckDraw finds in
+// pos and history, and "*" while it goes on.
+func Result(pos *Position, history []uint64) string {
+	switch pos.Status() {
+	case Checkmate:
+		if pos.WhiteToMove {
+			return "0-1"
+		}
+		return "1-0"
+	case Stalemate:
+		return "1/2-1/2"
+	}
+	if CheckDraw(pos, history) != NotDrawn {
+		return "1/2-1/2"
+	}
+	return "*"
+}
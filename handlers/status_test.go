@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		fen    string
+		status GameStatus
+		result string
+	}{
+		{StartFEN, Ongoing, "*"},
+		{"R5k1/5ppp/8/8/8/8/8/4K3 b - - 1 1", Checkmate, "1-0"},
+		{"4k3/8/8/8/8/8/5PPP/r5K1 w - - 1 1", Checkmate, "0-1"},
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 1 1", Stalemate, "1/2-1/2"},
+		{"4k3/8/8/8/8/8/8/2N1K3 w - - 0 1", Ongoing, "1/2-1/2"},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pos.Status(); got != tc.status {
+			t.Errorf("Status(%q) = %v, want %v", tc.fen, got, tc.status)
+		}
+		if got := Result(&pos, nil); got != tc.result {
+			t.Errorf("Result(%q) = %q, want %q", tc.fen, got, tc.result)
+		}
+	}
+}
+
+func TestMateScores(t *testing.T) {
+	search := func(fen, move string) int {
+		pos, err := ParseFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mv, _ := ParseMove(move)
+		_, score := NewSearcher().SearchSpecificMoves(context.Background(), &pos, []Move{mv})
+		return score
+	}
+
+	// Qf8 mates; Qf7 stalemates.
+	if got := search("7k/8/6K1/8/8/8/8/5Q2 w - - 0 1", "f1f8"); got != MateScore-1 {
+		t.Errorf("White's mate in one scores %d, want %d", got, MateScore-1)
+	}
+	if got := search("7k/8/6K1/8/8/8/8/5Q2 w - - 0 1", "f1f7"); got != 0 {
+		t.Errorf("stalemate scores %d, want 0", got)
+	}
+	if got := search("r5k1/8/8/8/8/8/5PPP/6K1 b - - 0 1", "a8a1"); got != -(MateScore - 1) {
+		t.Errorf("Black's mate in one scores %d, want %d", got, -(MateScore - 1))
+	}
+	if plies, ok := MatePlies(-(MateScore - 3)); !ok || plies != 3 {
+		t.Errorf("MatePlies(-(MateScore-3)) = %d, %v", plies, ok)
+	}
+	if _, ok := MatePlies(tablebaseWinScore); ok {
+		t.Error("a tablebase win counts as a mate score")
+	}
+
+	// Mate and tablebase scores count plies from the root; the transposition
+	// table must hand them back for the ply the position comes up at again.
+	for _, score := range []int{MateScore - 5, -(MateScore - 5), tablebaseWinScore - 5, -(tablebaseWinScore - 5)} {
+		stored := scoreToTT(score, 3)
+		want := score - 4
+		if score < 0 {
+			want = score + 4
+		}
+		if got := scoreFromTT(stored, 7); got != want {
+			t.Errorf("score %d stored at ply 3 reads back as %d at ply 7, want %d", score, got, want)
+		}
+	}
+	if got := scoreFromTT(scoreToTT(350, 3), 7); got != 350 {
+		t.Errorf("an evaluation stored at ply 3 reads back as %d at ply 7", got)
+	}
+}
+
+// TestSearchPrefersFasterMate gives White a mate in one next to slower wins and
+// checks that the search takes it.
+func TestSearchPrefersFasterMate(t *testing.T) {
+	pos, _ := ParseFEN("6k1/5ppp/8/8/8/8/1Q6/R3K3 w - - 0 1")
+	var score int
+	move := NewSearcher().FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 4}, func(info SearchInfo) {
+		score = info.Score
+	})
+	after := pos
+	after.MakeMove(move)
+	if after.Status() != Checkmate {
+		t.Errorf("search played %v, want a mate in one", move)
+	}
+	if score != MateScore-1 {
+		t.Errorf("search scored %d, want %d", score, MateScore-1)
+	}
+}
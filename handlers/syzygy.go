@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// WDL is a tablebase result for the side to move. Cursed wins and blessed losses
+// are wins and losses that the fifty-move rule turns into draws.
+type WDL int
+
+const (
+	WDLLoss        WDL = -2
+	WDLBlessedLoss WDL = -1
+	WDLDraw        WDL = 0
+	WDLCursedWin   WDL = 1
+	WDLWin         WDL = 2
+)
+
+// tablebaseWinScore is what the search scores a tablebase win as: above any
+// evaluation, below a mate found by the search.
+const tablebaseWinScore = 50000
+
+// tablebaseThreshold is the smallest score that can be a tablebase win found
+// within MaxSearchDepth plies. Like mate scores, such scores depend on the ply
+// they are found at, and the transposition table keeps them relative to the
+// node; see scoreToTT.
+const tablebaseThreshold = tablebaseWinScore - 2*MaxSearchDepth - 100
+
+// Tablebase probes Syzygy endgame tablebases: WDL (.rtbw) files for the result
+// of a position and DTZ (.rtbz) files for the distance to the next capture or
+// pawn move. Files are read when first probed and kept in memory. A Tablebase
+// is safe for concurrent use.
+type Tablebase struct {
+	tables    map[string]*tbEntry // by material, e.g. "KRvK" and "KvKR"
+	count     int
+	maxPieces int
+}
+
+// tbEntry is the WDL and, if present, DTZ table of one material combination.
+type tbEntry struct {
+	name string // with the stronger side as White, as in the file name
+	wdl  *tbTable
+	dtz  *tbTable
+}
+
+// OpenTablebase finds the Syzygy tables in the directories listed in paths,
+// separated by os.PathListSeparator as in $PATH. It does not read them yet.
+func OpenTablebase(paths string) (*Tablebase, error) {
+	wdlFiles := map[string]string{}
+	dtzFiles := map[string]string{}
+	for _, dir := range filepath.SplitList(paths) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			files := wdlFiles
+			switch filepath.Ext(name) {
+			case ".rtbw":
+			case ".rtbz":
+				files = dtzFiles
+			default:
+				continue
+			}
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if _, seen := files[name]; !seen {
+				files[name] = filepath.Join(dir, name+filepath.Ext(entry.Name()))
+			}
+		}
+	}
+
+	tb := &Tablebase{tables: map[string]*tbEntry{}}
+	for name, path := range wdlFiles {
+		white, black, ok := parseTableName(name)
+		if !ok {
+			continue
+		}
+		entry := &tbEntry{name: name, wdl: newTBTable(path, white, black, false)}
+		if dtzPath, ok := dtzFiles[name]; ok {
+			entry.dtz = newTBTable(dtzPath, white, black, true)
+		}
+		tb.tables[name] = entry
+		tb.tables[black+"v"+white] = entry
+		tb.count++
+		if n := entry.wdl.pieceCount; n > tb.maxPieces {
+			tb.maxPieces = n
+		}
+	}
+	return tb, nil
+}
+
+// parseTableName splits a table name such as "KRPvKR" into the pieces of
+// each side.
+func parseTableName(name string) (white, black string, ok bool) {
+	white, black, ok = strings.Cut(name, "v")
+	valid := func(side string) bool {
+		return strings.HasPrefix(side, "K") && strings.Count(side, "K") == 1 &&
+			strings.Trim(side, "KQRBNP") == ""
+	}
+	if !ok || !valid(white) || !valid(black) || len(white)+len(black) > tbMaxPieces {
+		return "", "", false
+	}
+	return white, black, true
+}
+
+func newTBTable(path, white, black string, dtz bool) *tbTable {
+	t := &tbTable{
+		path:       path,
+		dtz:        dtz,
+		symmetric:  white == black,
+		pieceCount: len(white) + len(black),
+	}
+	for _, piece := range "QRBNP" {
+		if strings.Count(white, string(piece)) == 1 || strings.Count(black, string(piece)) == 1 {
+			t.hasUniquePieces = true
+		}
+	}
+	whitePawns, blackPawns := strings.Count(white, "P"), strings.Count(black, "P")
+	t.hasPawns = whitePawns+blackPawns > 0
+	// The side with fewer pawns leads, as that compresses better; White when equal.
+	if blackPawns == 0 || (whitePawns > 0 && blackPawns >= whitePawns) {
+		t.pawnCount = [2]int{whitePawns, blackPawns}
+	} else {
+		t.pawnCount = [2]int{blackPawns, whitePawns}
+	}
+	return t
+}
+
+// Len returns the number of tables found.
+func (tb *Tablebase) Len() int {
+	return tb.count
+}
+
+// MaxPieces returns the number of pieces, kings included, of the largest table.
+func (tb *Tablebase) MaxPieces() int {
+	return tb.maxPieces
+}
+
+// materialName returns the material of pos in table-name form, White first.
+func materialName(pos *Position) (name string, pieces int) {
+	var white, black strings.Builder
+	white.WriteByte('K')
+	black.WriteByte('K')
+	for _, piece := range "QRBNP" {
+		for row := 0; row < 8; row++ {
+			for col := 0; col < 8; col++ {
+				switch pos.Board[row][col] {
+				case piece:
+					white.WriteRune(piece)
+				case unicode.ToLower(piece):
+					black.WriteRune(piece)
+				}
+			}
+		}
+	}
+	return white.String() + "v" + black.String(), white.Len() + black.Len()
+}
+
+// covers reports whether pos can be probed: few enough pieces and no castling
+// rights, which tables do not know about.
+func (tb *Tablebase) covers(pos *Position) bool {
+	_, pieces := materialName(pos)
+	return pieces <= tb.maxPieces && pos.Castling == CastlingRights{}
+}
+
+// probeTable looks up the WDL, or for dtz the DTZ, of pos itself. Captures,
+// en passant and the fifty-move rule are left to the callers.
+func (tb *Tablebase) probeTable(pos *Position, dtz bool, wdl WDL) (int, error) {
+	name, pieces := materialName(pos)
+	if pieces == 2 {
+		return int(WDLDraw), nil
+	}
+	entry := tb.tables[name]
+	if entry == nil {
+		return 0, fmt.Errorf("no Syzygy table for %s", name)
+	}
+	table := entry.wdl
+	if dtz {
+		table = entry.dtz
+		if table == nil {
+			return 0, fmt.Errorf("no Syzygy DTZ table for %s", entry.name)
+		}
+	}
+	return table.probe(pos, name != entry.name, wdl)
+}
+
+func isCapture(pos *Position, move Move) bool {
+	return pos.Board[move.ToRow][move.ToCol] != 0 || pos.isEnPassantCapture(move)
+}
+
+func isPawnMove(pos *Position, move Move) bool {
+	piece := pos.Board[move.FromRow][move.FromCol]
+	return piece == 'P' || piece == 'p'
+}
+
+func isCheckmate(pos *Position) bool {
+	row, col := findKing(pos.Board, pos.WhiteToMove)
+	return IsInCheck(pos.Board, pos.WhiteToMove, row, col) && len(generateLegalMoves(pos)) == 0
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// dtzBeforeZeroing is the DTZ of a position whose best move captures or moves a
+// pawn, which DTZ tables do not store, given its WDL.
+func dtzBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case WDLWin:
+		return 1
+	case WDLCursedWin:
+		return 101
+	case WDLBlessedLoss:
+		return -101
+	case WDLLoss:
+		return -1
+	}
+	return 0
+}
+
+// search returns the WDL of pos. Tables may store any value for a position
+// where a capture is at least as good, so the captures (and with zeroing the
+// pawn moves too) are tried first. zeroingBest reports that one of them is the
+// best move, in which case DTZ tables cannot be trusted for pos.
+func (tb *Tablebase) search(pos *Position, zeroing bool) (wdl WDL, zeroingBest bool, err error) {
+	best := WDLLoss
+	moves := generateLegalMoves(pos)
+	tried := 0
+	for _, move := range moves {
+		if !isCapture(pos, move) && (!zeroing || !isPawnMove(pos, move)) {
+			continue
+		}
+		tried++
+		undo := pos.MakeMove(move)
+		value, _, err := tb.search(pos, false)
+		pos.UnmakeMove(move, undo)
+		if err != nil {
+			return WDLDraw, false, err
+		}
+		if -value > best {
+			best = -value
+			if best >= WDLWin {
+				return best, true, nil
+			}
+		}
+	}
+
+	// With every move tried the table is not needed, and it may not hold
+	// the right value: tables ignore en passant, for one.
+	allTried := tried > 0 && tried == len(moves)
+	value := best
+	if !allTried {
+		stored, err := tb.probeTable(pos, false, WDLDraw)
+		if err != nil {
+			return WDLDraw, false, err
+		}
+		value = WDL(stored)
+	}
+	if best >= value {
+		return best, best > WDLDraw || allTried, nil
+	}
+	return value, false, nil
+}
+
+// probeDTZ returns the DTZ of pos; see ProbeDTZ.
+func (tb *Tablebase) probeDTZ(pos *Position) (int, error) {
+	wdl, zeroingBest, err := tb.search(pos, true)
+	if err != nil || wdl == WDLDraw {
+		return 0, err
+	}
+	if zeroingBest {
+		return dtzBeforeZeroing(wdl), nil
+	}
+
+	dtz, err := tb.probeTable(pos, true, wdl)
+	if err == nil {
+		if wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+			dtz += 100
+		}
+		return dtz * sign(int(wdl)), nil
+	}
+	if err != errChangeSTM {
+		return 0, err
+	}
+
+	// The table only has the other side to move: take the best DTZ a move
+	// away, one ply further.
+	best := 0xffff
+	for _, move := range generateLegalMoves(pos) {
+		zeroingMove := isCapture(pos, move) || isPawnMove(pos, move)
+		undo := pos.MakeMove(move)
+		var dtz int
+		if zeroingMove {
+			var value WDL
+			value, _, err = tb.search(pos, false)
+			dtz = -dtzBeforeZeroing(value)
+		} else {
+			dtz, err = tb.probeDTZ(pos)
+			dtz = -dtz
+		}
+		if dtz == 1 && isCheckmate(pos) {
+			best = 1
+		}
+		pos.UnmakeMove(move, undo)
+		if err != nil {
+			return 0, err
+		}
+		if !zeroingMove {
+			dtz += sign(dtz)
+		}
+		if dtz < best && sign(dtz) == sign(int(wdl)) {
+			best = dtz
+		}
+	}
+	if best == 0xffff {
+		return -1, nil
+	}
+	return best, nil
+}
+
+// ProbeWDL returns the tablebase result of pos for the side to move. It reports
+// false if pos has castling rights or no table covers it.
+func (tb *Tablebase) ProbeWDL(pos *Position) (WDL, bool) {
+	if !tb.covers(pos) {
+		return WDLDraw, false
+	}
+	p := *pos
+	wdl, _, err := tb.search(&p, false)
+	return wdl, err == nil
+}
+
+// ProbeDTZ returns the distance to zeroing of pos in plies: the number of plies,
+// with best play, to the next capture or pawn move of the winning side, with
+// the sign of the result for the side to move. A value beyond ±100 is a win or
+// loss that the fifty-move rule turns into a draw; 0 is a draw. The value may be
+// one ply too long. It reports false if pos has castling rights or no WDL and
+// DTZ tables cover it.
+func (tb *Tablebase) ProbeDTZ(pos *Position) (int, bool) {
+	if !tb.covers(pos) {
+		return 0, false
+	}
+	p := *pos
+	dtz, err := tb.probeDTZ(&p)
+	return dtz, err == nil
+}
+
+// RootMoves returns the legal moves of pos that keep its tablebase result,
+// taking the fifty-move counter into account, and that result. Winning and
+// losing moves are sorted by DTZ, quickest win or slowest loss first, so the
+// first one always makes progress; drawing moves are left for the search to
+// choose between. It reports false if any move cannot be probed.
+func (tb *Tablebase) RootMoves(pos *Position) ([]Move, WDL, bool) {
+	if !tb.covers(pos) {
+		return nil, WDLDraw, false
+	}
+	root := *pos
+	moves := generateLegalMoves(&root)
+	if len(moves) == 0 {
+		return nil, WDLDraw, false
+	}
+
+	// Each move gets the DTZ counted from the root and the result that gives
+	// with the fifty-move counter as it stands.
+	dtzs := make([]int, len(moves))
+	results := make([]WDL, len(moves))
+	best := WDLLoss
+	for i, move := range moves {
+		undo := root.MakeMove(move)
+		var dtz int
+		var err error
+		if root.HalfmoveClock == 0 {
+			var wdl WDL
+			wdl, _, err = tb.search(&root, false)
+			dtz = dtzBeforeZeroing(-wdl)
+		} else {
+			dtz, err = tb.probeDTZ(&root)
+			dtz = -dtz
+			dtz += sign(dtz)
+		}
+		if isCheckmate(&root) {
+			dtz = 1
+		}
+		root.UnmakeMove(move, undo)
+		if err != nil {
+			return nil, WDLDraw, false
+		}
+
+		dtzs[i] = dtz
+		switch {
+		case dtz > 0 && dtz+pos.HalfmoveClock <= 99:
+			results[i] = WDLWin
+		case dtz > 0:
+			results[i] = WDLCursedWin
+		case dtz < 0 && -dtz+pos.HalfmoveClock <= 99:
+			results[i] = WDLLoss
+		case dtz < 0:
+			results[i] = WDLBlessedLoss
+		}
+		if results[i] > best {
+			best = results[i]
+		}
+	}
+
+	var keep []int
+	for i := range moves {
+		if results[i] == best {
+			keep = append(keep, i)
+		}
+	}
+	// The smallest DTZ is the quickest win or, being negative, the slowest loss.
+	sort.SliceStable(keep, func(a, b int) bool { return dtzs[keep[a]] < dtzs[keep[b]] })
+	kept := make([]Move, len(keep))
+	for i, k := range keep {
+		kept[i] = moves[k]
+	}
+	return kept, best, true
+}
+
+// tablebaseScore is the search score, from White's point of view, of a
+// position ply moves below the root with tablebase result wdl.
+func tablebaseScore(pos *Position, wdl WDL, ply int) int {
+	var score int
+	switch wdl {
+	case WDLWin:
+		score = tablebaseWinScore - ply
+	case WDLLoss:
+		score = -tablebaseWinScore + ply
+	default:
+		// Cursed wins and blessed losses are draws, but only just.
+		score = int(wdl)
+	}
+	if !pos.WhiteToMove {
+		score = -score
+	}
+	return score
+}
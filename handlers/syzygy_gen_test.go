@@ -0,0 +1,839 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// The tables in testdata/syzygy are written by TestGenerateSyzygyTables, which
+// solves each endgame by retrograde analysis with the engine's own move
+// generator and stores the results in the Syzygy format. They are not copies of
+// the published tables: they check the decoder against the layout and index
+// encoding in syzygy_tables.go, and TestSyzygyFiles checks the results they
+// hold against endgame theory. Regenerate them with
+//
+//	go test ./handlers -run TestGenerateSyzygyTables -update-syzygy -timeout 0
+var updateSyzygy = flag.Bool("update-syzygy", false, "regenerate the Syzygy tables in testdata/syzygy")
+
+// syzygyTestDir holds the generated tables.
+var syzygyTestDir = filepath.Join("testdata", "syzygy")
+
+// syzygyTestTables are the generated tables, each after the ones its captures
+// and promotions lead to. The 3-piece ones also get a DTZ table.
+var syzygyTestTables = []string{"KQvK", "KRvK", "KBvK", "KNvK", "KPvK", "KBNvK", "KRvKR"}
+
+// Results of genTable.wdl besides the WDLs.
+const (
+	genUnknown int8 = -128 // not solved yet, or no position has this index
+	genNoMove  int8 = -3   // worse than any result: no move leaves the table
+)
+
+const genNone = ^uint32(0) // no position has this index
+
+// genTable is an endgame being solved. Its states are the indexes of the
+// sub-tables of its WDL table, one after another.
+type genTable struct {
+	name  string
+	t     *tbTable // only the pieces and groups are set
+	runes []rune   // the pieces in the order the table encodes them
+	base  [2][4]int
+	size  int
+	rep   []uint32 // a position per state: 6 bits per square and White to move at bit 31
+	wdl   []int8
+	dtz   []int16 // plies to zeroing of a won or lost state, -1 for none
+}
+
+func newGenTable(name string) *genTable {
+	white, black, _ := parseTableName(name)
+	g := &genTable{name: name, t: newTBTable("", white, black, false)}
+	order := genPieceOrder(g.t, white, black)
+	for _, code := range order {
+		g.runes = append(g.runes, genPieceRune(code))
+	}
+	groupOrder := [2]int{0, 0xf}
+	if g.t.pawnCount[1] > 0 {
+		groupOrder[1] = 1
+	}
+	for f := 0; f <= g.maxFile(); f++ {
+		for i := 0; i < g.t.sides(); i++ {
+			d := &g.t.items[i][f]
+			copy(d.pieces[:], order)
+			g.t.setGroups(d, groupOrder, f)
+			g.base[i][f] = g.size
+			g.size += int(d.size())
+		}
+	}
+	return g
+}
+
+// genPieceOrder returns the pieces of t in the order they are encoded in: the
+// leading pawns and the other side's pawns, or the kings and a unique piece,
+// then the other pieces grouped by kind.
+func genPieceOrder(t *tbTable, white, black string) []uint8 {
+	var codes []uint8
+	for _, piece := range white {
+		codes = append(codes, tbPieceCode(piece))
+	}
+	for _, piece := range black {
+		codes = append(codes, tbPieceCode(unicode.ToLower(piece)))
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	var first []uint8
+	if t.hasPawns {
+		first = []uint8{1, 9}
+		if t.pawnCount[0] != strings.Count(white, "P") {
+			first = []uint8{9, 1}
+		}
+	} else {
+		first = []uint8{6, 14}
+		for _, code := range codes {
+			if code&7 != 6 && bytes.Count(codes, []byte{code}) == 1 {
+				first = append(first, code)
+				break
+			}
+		}
+	}
+
+	var order []uint8
+	taken := make([]bool, len(codes))
+	for _, code := range first {
+		for i := range codes {
+			if !taken[i] && codes[i] == code {
+				taken[i] = true
+				order = append(order, code)
+				// Pawns go together; the kings and the unique piece alone.
+				if !t.hasPawns {
+					break
+				}
+			}
+		}
+	}
+	for i, code := range codes {
+		if !taken[i] {
+			order = append(order, code)
+		}
+	}
+	return order
+}
+
+// genPieceRune is the board piece with Syzygy code code.
+func genPieceRune(code uint8) rune {
+	piece := rune(" PNBRQK"[code&7])
+	if code&8 != 0 {
+		piece = unicode.ToLower(piece)
+	}
+	return piece
+}
+
+func (g *genTable) maxFile() int {
+	if g.t.hasPawns {
+		return 3
+	}
+	return 0
+}
+
+// state returns the state of pos, which must have the table's material.
+func (g *genTable) state(pos *Position) int {
+	name, _ := materialName(pos)
+	_, stm, file, idx := g.t.index(pos, name != g.name)
+	return g.base[stm][file] + int(idx)
+}
+
+// position returns the position rep packs.
+func (g *genTable) position(rep uint32) Position {
+	pos := Position{WhiteToMove: rep>>31 != 0, EnPassantRow: -1, EnPassantCol: -1}
+	for i, piece := range g.runes {
+		sq := int(rep >> (6 * i) & 63)
+		pos.Board[7-sq>>3][sq&7] = piece
+	}
+	return pos
+}
+
+// forEachPosition calls fn with every legal position of the table whose first
+// piece needs no mirroring: a pawn on files a-d, or otherwise a piece in the
+// a1-d1-d4 triangle. Every position is equivalent to one of these.
+func (g *genTable) forEachPosition(fn func(pos *Position, rep uint32)) {
+	var place func(k int, occupied uint64, rep uint32)
+	place = func(k int, occupied uint64, rep uint32) {
+		if k == len(g.runes) {
+			for _, white := range []bool{true, false} {
+				pos := g.position(rep)
+				pos.WhiteToMove = white
+				row, col := findKing(pos.Board, !white)
+				if IsInCheck(pos.Board, !white, row, col) {
+					continue
+				}
+				if white {
+					fn(&pos, rep|1<<31)
+				} else {
+					fn(&pos, rep)
+				}
+			}
+			return
+		}
+		pawn := g.runes[k] == 'P' || g.runes[k] == 'p'
+		for sq := 0; sq < 64; sq++ {
+			switch {
+			case occupied&(1<<sq) != 0:
+			case pawn && (sq < 8 || sq >= 56):
+			case k == 0 && sq&7 > 3:
+			case k == 0 && !pawn && (sq>>3 > sq&7):
+			default:
+				place(k+1, occupied|1<<sq, rep|uint32(sq)<<(6*k))
+			}
+		}
+	}
+	place(0, 0, 0)
+}
+
+// syzygyGenerator solves the tables, keeping the ones solved for captures and
+// promotions.
+type syzygyGenerator struct {
+	t      *testing.T
+	tables map[string]*genTable // by material, both ways round
+}
+
+// wdl returns the solved result of pos for the side to move.
+func (gen *syzygyGenerator) wdl(pos *Position) int8 {
+	name, pieces := materialName(pos)
+	if pieces == 2 {
+		return int8(WDLDraw)
+	}
+	g := gen.tables[name]
+	if g == nil {
+		gen.t.Fatalf("no table for %s", name)
+	}
+	wdl := g.wdl[g.state(pos)]
+	if wdl == genUnknown {
+		gen.t.Fatalf("%s: %s has no result", name, pos.FEN())
+	}
+	return wdl
+}
+
+// solve works out the WDL and DTZ of every position of g, ignoring the
+// fifty-move rule: none of the generated tables has a win that needs more
+// than 100 plies to the next capture or pawn move.
+func (gen *syzygyGenerator) solve(g *genTable) {
+	white, black, _ := parseTableName(g.name)
+	gen.tables[g.name] = g
+	gen.tables[black+"v"+white] = g
+
+	g.rep = make([]uint32, g.size)
+	for i := range g.rep {
+		g.rep[i] = genNone
+	}
+	g.forEachPosition(func(pos *Position, rep uint32) {
+		if id := g.state(pos); g.rep[id] == genNone {
+			g.rep[id] = rep
+		}
+	})
+
+	// The moves within the table are edges to the states they lead to, with
+	// bit 0 set for pawn moves; ext holds the best result of the others.
+	edgeStart := make([]int, g.size+1)
+	var edges []uint32
+	ext := make([]int8, g.size)
+	g.wdl = make([]int8, g.size)
+	for id := range g.rep {
+		edgeStart[id] = len(edges)
+		g.wdl[id] = genUnknown
+		if g.rep[id] == genNone {
+			continue
+		}
+		pos := g.position(g.rep[id])
+		ext[id] = genNoMove
+		moves := generateLegalMoves(&pos)
+		if len(moves) == 0 {
+			g.wdl[id] = int8(WDLDraw)
+			if isCheckmate(&pos) {
+				g.wdl[id] = int8(WDLLoss)
+			}
+			continue
+		}
+		for _, move := range moves {
+			pawnMove := isPawnMove(&pos, move)
+			undo := pos.MakeMove(move)
+			if name, _ := materialName(&pos); gen.tables[name] == g {
+				edge := uint32(g.state(&pos)) << 1
+				if pawnMove {
+					edge |= 1
+				}
+				edges = append(edges, edge)
+			} else if v := -gen.wdl(&pos); v > ext[id] {
+				ext[id] = v
+			}
+			pos.UnmakeMove(move, undo)
+		}
+	}
+	edgeStart[g.size] = len(edges)
+	movesOf := func(id int) []uint32 { return edges[edgeStart[id]:edgeStart[id+1]] }
+
+	// A state is won once a move reaches a lost one, and decided once all its
+	// moves are; what is still open when nothing changes is a draw.
+	for changed := true; changed; {
+		changed = false
+		for id := range g.wdl {
+			if g.wdl[id] != genUnknown || g.rep[id] == genNone {
+				continue
+			}
+			best, known := ext[id], true
+			for _, edge := range movesOf(id) {
+				if v := g.wdl[edge>>1]; v == genUnknown {
+					known = false
+				} else if -v > best {
+					best = -v
+				}
+			}
+			if best == int8(WDLWin) || known {
+				g.wdl[id], changed = best, true
+			}
+		}
+	}
+	for id := range g.wdl {
+		if g.wdl[id] == genUnknown && g.rep[id] != genNone {
+			g.wdl[id] = int8(WDLDraw)
+		}
+	}
+
+	// DTZ in plies: a capture, pawn move or mate is 1; otherwise a win takes
+	// its quickest winning move and a loss its slowest move. Round n settles
+	// the wins of DTZ n, so each gets its quickest, and the losses once all
+	// their moves are settled.
+	g.dtz = make([]int16, g.size)
+	for id := range g.dtz {
+		g.dtz[id] = -1
+		if g.wdl[id] == int8(WDLLoss) && ext[id] == genNoMove && len(movesOf(id)) == 0 {
+			g.dtz[id] = 0
+		}
+	}
+	for round := int16(1); ; round++ {
+		changed, pending := false, false
+		for id := range g.dtz {
+			if g.dtz[id] >= 0 || g.rep[id] == genNone {
+				continue
+			}
+			switch g.wdl[id] {
+			case int8(WDLWin):
+				best := int16(-1)
+				if ext[id] == int8(WDLWin) {
+					best = 1
+				}
+				for _, edge := range movesOf(id) {
+					child := edge >> 1
+					if g.wdl[child] != int8(WDLLoss) {
+						continue
+					}
+					if edge&1 != 0 {
+						best = 1
+					} else if d := g.dtz[child]; d >= 0 && (best < 0 || d+1 < best) {
+						best = d + 1
+					}
+				}
+				if best >= 0 && best <= round {
+					g.dtz[id], changed = best, true
+				} else if best >= 0 {
+					pending = true
+				}
+			case int8(WDLLoss):
+				worst, known := int16(0), true
+				if ext[id] != genNoMove {
+					worst = 1
+				}
+				for _, edge := range movesOf(id) {
+					d := int16(1)
+					if edge&1 == 0 {
+						if d = g.dtz[edge>>1] + 1; d == 0 {
+							known = false
+							break
+						}
+					}
+					worst = max(worst, d)
+				}
+				if known {
+					g.dtz[id], changed = worst, true
+				}
+			}
+		}
+		if !changed && !pending {
+			break
+		}
+	}
+	for id, d := range g.dtz {
+		pos := g.position(g.rep[id])
+		switch {
+		case g.rep[id] == genNone:
+		case d < 0 && g.wdl[id] != int8(WDLDraw):
+			gen.t.Fatalf("%s: %s has no DTZ", g.name, pos.FEN())
+		case d > 100:
+			gen.t.Fatalf("%s: %s needs %d plies to zeroing; the fifty-move rule is not handled",
+				g.name, pos.FEN(), d)
+		}
+	}
+
+	// Every position, not only the one kept per state, must agree with its
+	// moves; two that differ but share an index would not.
+	g.forEachPosition(func(pos *Position, _ uint32) {
+		best := genNoMove
+		moves := generateLegalMoves(pos)
+		for _, move := range moves {
+			undo := pos.MakeMove(move)
+			best = max(best, -gen.wdl(pos))
+			pos.UnmakeMove(move, undo)
+		}
+		if len(moves) == 0 {
+			best = int8(WDLDraw)
+			if isCheckmate(pos) {
+				best = int8(WDLLoss)
+			}
+		}
+		if got := g.wdl[g.state(pos)]; got != best {
+			gen.t.Fatalf("%s: %s is stored as %d but its moves give %d", g.name, pos.FEN(), got, best)
+		}
+	})
+}
+
+// genFill gives the indexes no position has, marked -1, the value before
+// them, which compresses best.
+func genFill(values []int) []uint8 {
+	out := make([]uint8, len(values))
+	last := 0
+	for i, v := range values {
+		if v >= 0 {
+			last = v
+		}
+		out[i] = uint8(last)
+	}
+	return out
+}
+
+// genSubTable is one compressed sub-table: its header as setSizes reads it,
+// its sparse index, its block lengths and its data.
+type genSubTable struct {
+	header, sparseIndex, blockLength, data []byte
+}
+
+// writeWDL writes the WDL table of g to dir.
+func (g *genTable) writeWDL(dir string) error {
+	var subs []genSubTable
+	for f := 0; f <= g.maxFile(); f++ {
+		for i := 0; i < g.t.sides(); i++ {
+			values := make([]int, g.t.items[i][f].size())
+			for idx := range values {
+				values[idx] = -1
+				if v := g.wdl[g.base[i][f]+idx]; v != genUnknown {
+					values[idx] = int(v) + 2
+				}
+			}
+			subs = append(subs, genCompress(genFill(values), 0))
+		}
+	}
+	return g.writeFile(filepath.Join(dir, g.name+".rtbw"), tbWDLMagic, subs, nil)
+}
+
+// writeDTZ writes the DTZ table of g to dir, with White to move. The values,
+// DTZ minus one in plies, are mapped for wins and losses separately.
+func (g *genTable) writeDTZ(dir string) error {
+	var subs []genSubTable
+	var maps []byte
+	for f := 0; f <= g.maxFile(); f++ {
+		size := int(g.t.items[0][f].size())
+		// The maps in the order mapScore reads them: wins, losses, cursed
+		// wins and blessed losses.
+		var used [4]map[int]bool
+		for i := range used {
+			used[i] = map[int]bool{}
+		}
+		mapOf := func(id int) int {
+			switch {
+			case g.dtz[id] <= 0:
+				return -1
+			case g.wdl[id] == int8(WDLWin):
+				return 0
+			case g.wdl[id] == int8(WDLLoss):
+				return 1
+			}
+			return -1
+		}
+		for idx := 0; idx < size; idx++ {
+			if id := g.base[0][f] + idx; mapOf(id) >= 0 {
+				used[mapOf(id)][int(g.dtz[id])-1] = true
+			}
+		}
+		var symbol [4]map[int]int
+		for i := range used {
+			var dtzs []int
+			for d := range used[i] {
+				dtzs = append(dtzs, d)
+			}
+			sort.Ints(dtzs)
+			symbol[i] = map[int]int{}
+			maps = append(maps, uint8(len(dtzs)))
+			for n, d := range dtzs {
+				symbol[i][d] = n
+				maps = append(maps, uint8(d))
+			}
+		}
+
+		values := make([]int, size)
+		for idx := range values {
+			values[idx] = -1
+			if id := g.base[0][f] + idx; mapOf(id) >= 0 {
+				values[idx] = symbol[mapOf(id)][int(g.dtz[id])-1]
+			}
+		}
+		subs = append(subs, genCompress(genFill(values), tbMapped|tbWinPlies|tbLossPlies))
+	}
+	return g.writeFile(filepath.Join(dir, g.name+".rtbz"), tbDTZMagic, subs, maps)
+}
+
+// writeFile lays a table out as tbTable.setup reads it.
+func (g *genTable) writeFile(path string, magic [4]byte, subs []genSubTable, dtzMaps []byte) error {
+	file := append([]byte{}, magic[:]...)
+	var flags byte
+	if !g.t.symmetric {
+		flags |= 1
+	}
+	if g.t.hasPawns {
+		flags |= 2
+	}
+	file = append(file, flags)
+	for f := 0; f <= g.maxFile(); f++ {
+		file = append(file, 0)
+		if g.t.pawnCount[1] > 0 {
+			file = append(file, 0x11)
+		}
+		for k := 0; k < g.t.pieceCount; k++ {
+			piece := g.t.items[0][f].pieces[k]
+			file = append(file, piece|piece<<4)
+		}
+	}
+	file = append(file, make([]byte, len(file)&1)...)
+
+	for _, sub := range subs {
+		file = append(file, sub.header...)
+	}
+	if dtzMaps != nil {
+		file = append(file, dtzMaps...)
+		file = append(file, make([]byte, len(file)&1)...)
+	}
+	for _, sub := range subs {
+		file = append(file, sub.sparseIndex...)
+	}
+	for _, sub := range subs {
+		file = append(file, sub.blockLength...)
+	}
+	for _, sub := range subs {
+		if sub.data != nil {
+			file = append(file, make([]byte, -len(file)&0x3f)...)
+			file = append(file, sub.data...)
+		}
+	}
+	file = append(file, make([]byte, (16-len(file))&0x3f)...)
+	return os.WriteFile(path, file, 0o644)
+}
+
+// Parameters of the compressed sub-tables.
+const (
+	genBlockSizeLog = 6
+	genSpanLog      = 10
+	genMaxSymValues = 4096 // values a symbol may stand for
+	genMinPairCount = 16   // occurrences a pair needs to get a symbol
+	genMaxCodeLen   = 24
+)
+
+// genCompress compresses values into a sub-table with the given flags. Runs
+// of values become symbols standing for pairs of symbols, found by repeatedly
+// replacing the most frequent pair (Re-Pair), and the symbols get a canonical
+// Huffman code.
+func genCompress(values []uint8, flags uint8) genSubTable {
+	single := true
+	for _, v := range values {
+		single = single && v == values[0]
+	}
+	if single {
+		return genSubTable{header: []byte{flags | tbSingleValue, values[0]}}
+	}
+
+	type symbol struct{ left, right int }
+	var syms []symbol
+	var count []int // values per symbol
+	leaf := map[uint8]uint16{}
+	seq := make([]uint16, len(values))
+	for i, v := range values {
+		s, ok := leaf[v]
+		if !ok {
+			s = uint16(len(syms))
+			leaf[v] = s
+			syms = append(syms, symbol{int(v), 0xfff})
+			count = append(count, 1)
+		}
+		seq[i] = s
+	}
+
+	pairs := make([]int32, 1<<24)
+	var touched []int32
+	for len(syms) < 0xfff {
+		touched = touched[:0]
+		for i := 0; i+1 < len(seq); i++ {
+			key := int32(seq[i])<<12 | int32(seq[i+1])
+			if pairs[key] == 0 {
+				touched = append(touched, key)
+			}
+			pairs[key]++
+			if seq[i] == seq[i+1] {
+				i++ // a run of one symbol holds only every other pair
+			}
+		}
+		best, bestCount := int32(-1), int32(genMinPairCount-1)
+		for _, key := range touched {
+			if n := pairs[key]; n > bestCount && count[key>>12]+count[key&0xfff] <= genMaxSymValues {
+				best, bestCount = key, n
+			}
+			pairs[key] = 0
+		}
+		if best < 0 {
+			break
+		}
+		a, b, n := uint16(best>>12), uint16(best&0xfff), uint16(len(syms))
+		syms = append(syms, symbol{int(a), int(b)})
+		count = append(count, count[a]+count[b])
+		out := seq[:0]
+		for i := 0; i < len(seq); i++ {
+			if i+1 < len(seq) && seq[i] == a && seq[i+1] == b {
+				out = append(out, n)
+				i++
+			} else {
+				out = append(out, seq[i])
+			}
+		}
+		seq = out
+	}
+
+	freq := make([]int, len(syms))
+	for _, s := range seq {
+		freq[s]++
+	}
+	codeLen := genHuffmanLengths(freq)
+
+	// Canonical code: longer codes come first, both in symbol numbers and as
+	// values, and the unused symbols last.
+	order := make([]int, len(syms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		li, lj := codeLen[order[i]], codeLen[order[j]]
+		return li > lj && lj > 0 || li > 0 && lj == 0
+	})
+	id := make([]int, len(syms))
+	for n, s := range order {
+		id[s] = n
+	}
+	minLen, maxLen := genMaxCodeLen, 0
+	for _, l := range codeLen {
+		if l > 0 {
+			minLen, maxLen = min(minLen, l), max(maxLen, l)
+		}
+	}
+	levels := maxLen - minLen + 1
+	perLen := make([]int, levels)
+	for _, l := range codeLen {
+		if l > 0 {
+			perLen[l-minLen]++
+		}
+	}
+	lowestSym := make([]int, levels)
+	base := make([]uint64, levels)
+	for i := levels - 2; i >= 0; i-- {
+		lowestSym[i] = lowestSym[i+1] + perLen[i+1]
+		base[i] = (base[i+1] + uint64(perLen[i+1])) / 2
+	}
+	code := func(s int) (uint64, int) {
+		i := codeLen[s] - minLen
+		return base[i] + uint64(id[s]-lowestSym[i]), codeLen[s]
+	}
+
+	header := []byte{flags, genBlockSizeLog, genSpanLog, 0, 0, 0, 0, 0, byte(maxLen), byte(minLen)}
+	for _, sym := range lowestSym {
+		header = binary.LittleEndian.AppendUint16(header, uint16(sym))
+	}
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(syms)))
+	btree := make([]byte, 3*len(syms))
+	for s, sym := range syms {
+		left, right := sym.left, sym.right
+		if right != 0xfff {
+			left, right = id[left], id[right]
+		}
+		n := 3 * id[s]
+		btree[n] = byte(left)
+		btree[n+1] = byte(left>>8&0xf) | byte(right&0xf)<<4
+		btree[n+2] = byte(right >> 4)
+	}
+	header = append(header, btree...)
+	header = append(header, make([]byte, len(syms)&1)...)
+
+	// Fill the blocks with whole symbols.
+	const blockSize, span = 1 << genBlockSizeLog, 1 << genSpanLog
+	var data []byte
+	var blockStart, blockValues []int
+	bits, total := 8*blockSize, 0
+	for _, s := range seq {
+		c, l := code(int(s))
+		if bits+l > 8*blockSize || blockValues[len(blockValues)-1]+count[s] > 0x10000-span {
+			data = append(data, make([]byte, blockSize)...)
+			blockStart = append(blockStart, total)
+			blockValues = append(blockValues, 0)
+			bits = 0
+		}
+		block := data[len(data)-blockSize:]
+		for k := l - 1; k >= 0; k-- {
+			if c>>k&1 != 0 {
+				block[bits>>3] |= 0x80 >> (bits & 7)
+			}
+			bits++
+		}
+		blockValues[len(blockValues)-1] += count[s]
+		total += count[s]
+	}
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(blockStart)))
+
+	var blockLength []byte
+	for _, n := range blockValues {
+		blockLength = binary.LittleEndian.AppendUint16(blockLength, uint16(n-1))
+	}
+
+	// The sparse index gives the block and offset of the middle value of each
+	// span; past the end it continues the last block.
+	var sparseIndex []byte
+	block := 0
+	for k := 0; k*span < len(values); k++ {
+		v := k*span + span/2
+		for block+1 < len(blockStart) && blockStart[block+1] <= v {
+			block++
+		}
+		sparseIndex = binary.LittleEndian.AppendUint32(sparseIndex, uint32(block))
+		sparseIndex = binary.LittleEndian.AppendUint16(sparseIndex, uint16(v-blockStart[block]))
+	}
+	return genSubTable{header, sparseIndex, blockLength, data}
+}
+
+// genHuffmanLengths returns the Huffman code length of each symbol, 0 for
+// those with no occurrences, no longer than genMaxCodeLen.
+func genHuffmanLengths(freq []int) []int {
+	for {
+		var nodes []int // symbols, then merged nodes
+		weight := append([]int{}, freq...)
+		parent := make([]int, len(freq))
+		for s, f := range freq {
+			if f > 0 {
+				nodes = append(nodes, s)
+			}
+		}
+		lengths := make([]int, len(freq))
+		if len(nodes) == 1 {
+			lengths[nodes[0]] = 1
+			return lengths
+		}
+		sort.Slice(nodes, func(i, j int) bool { return weight[nodes[i]] < weight[nodes[j]] })
+
+		// Two queues: the sorted symbols and the merged nodes, which come out
+		// in order of weight.
+		var merged []int
+		take := func() int {
+			if len(merged) == 0 || len(nodes) > 0 && weight[nodes[0]] <= weight[merged[0]] {
+				n := nodes[0]
+				nodes = nodes[1:]
+				return n
+			}
+			n := merged[0]
+			merged = merged[1:]
+			return n
+		}
+		for len(nodes)+len(merged) > 1 {
+			a, b := take(), take()
+			n := len(weight)
+			weight = append(weight, weight[a]+weight[b])
+			parent = append(parent, -1)
+			parent[a], parent[b] = n, n
+			merged = append(merged, n)
+		}
+
+		fits := true
+		for s, f := range freq {
+			if f == 0 {
+				continue
+			}
+			for n := s; parent[n] >= 0; n = parent[n] {
+				lengths[s]++
+			}
+			fits = fits && lengths[s] <= genMaxCodeLen
+		}
+		if fits {
+			return lengths
+		}
+		for s := range freq {
+			if freq[s] > 0 {
+				freq[s] = freq[s]/2 + 1
+			}
+		}
+	}
+}
+
+func TestGenerateSyzygyTables(t *testing.T) {
+	if !*updateSyzygy {
+		t.Skip("run with -update-syzygy to regenerate the tables")
+	}
+	if err := os.MkdirAll(syzygyTestDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gen := &syzygyGenerator{t: t, tables: map[string]*genTable{}}
+	for _, name := range syzygyTestTables {
+		g := newGenTable(name)
+		gen.solve(g)
+		if err := g.writeWDL(syzygyTestDir); err != nil {
+			t.Fatal(err)
+		}
+		if g.t.pieceCount == 3 {
+			if err := g.writeDTZ(syzygyTestDir); err != nil {
+				t.Fatal(err)
+			}
+		}
+		g.checkFiles(t, syzygyTestDir)
+		t.Logf("%s: %d states", name, g.size)
+	}
+}
+
+// checkFiles probes every state of g through the files written for it.
+func (g *genTable) checkFiles(t *testing.T, dir string) {
+	white, black, _ := parseTableName(g.name)
+	wdlTable := newTBTable(filepath.Join(dir, g.name+".rtbw"), white, black, false)
+	var dtzTable *tbTable
+	if g.t.pieceCount == 3 {
+		dtzTable = newTBTable(filepath.Join(dir, g.name+".rtbz"), white, black, true)
+	}
+	for id, rep := range g.rep {
+		if rep == genNone {
+			continue
+		}
+		pos := g.position(rep)
+		wdl, err := wdlTable.probe(&pos, false, WDLDraw)
+		if err != nil || int8(wdl) != g.wdl[id] {
+			t.Fatalf("%s: %s reads back as %d, %v; want %d", g.name, pos.FEN(), wdl, err, g.wdl[id])
+		}
+		if dtzTable == nil || !pos.WhiteToMove || g.dtz[id] <= 0 {
+			continue
+		}
+		dtz, err := dtzTable.probe(&pos, false, WDL(wdl))
+		if err != nil || dtz != int(g.dtz[id]) {
+			t.Fatalf("%s: DTZ of %s reads back as %d, %v; want %d", g.name, pos.FEN(), dtz, err, g.dtz[id])
+		}
+	}
+}
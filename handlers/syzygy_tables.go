@@ -0,0 +1,771 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// This file decodes Syzygy table files. The format is undocumented apart from
+// the generator and the reference probing code; the layout and index encoding
+// here follow those exactly, so the names below match the usual ones.
+//
+// Syzygy numbers squares from a1 = 0 to h8 = 63 and pieces as 1-6 for the
+// white pawn, knight, bishop, rook, queen and king, and 9-14 for black.
+
+const tbMaxPieces = 7
+
+var (
+	tbWDLMagic = [4]byte{0x71, 0xe8, 0x23, 0x5d}
+	tbDTZMagic = [4]byte{0xd7, 0x66, 0x0c, 0xa5}
+)
+
+// Flags of a pairsData. All but tbSingleValue only appear in DTZ tables.
+const (
+	tbSTM         = 1
+	tbMapped      = 2
+	tbWinPlies    = 4
+	tbLossPlies   = 8
+	tbWide        = 16
+	tbSingleValue = 128
+)
+
+// Index tables shared by all tables, filled in by init.
+var (
+	tbMapPawns      [64]int
+	tbMapB1H1H7     [64]int
+	tbMapA1D1D4     [64]int
+	tbMapKK         [10][64]int
+	tbBinomial      [tbMaxPieces][64]uint64
+	tbLeadPawnIdx   [tbMaxPieces][64]uint64
+	tbLeadPawnsSize [tbMaxPieces][4]uint64
+)
+
+// offA1H8 is positive above the a1-h8 diagonal, zero on it and negative below.
+func offA1H8(sq int) int {
+	return sq>>3 - sq&7
+}
+
+func init() {
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if offA1H8(sq) < 0 {
+			tbMapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	// The a1-d1-d4 triangle, with the diagonal squares numbered last.
+	var diagonal []int
+	code = 0
+	for sq := 0; sq <= 27; sq++ {
+		if offA1H8(sq) < 0 && sq&7 <= 3 {
+			tbMapA1D1D4[sq] = code
+			code++
+		} else if offA1H8(sq) == 0 && sq&7 <= 3 {
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		tbMapA1D1D4[sq] = code
+		code++
+	}
+
+	// The 462 legal placements of two kings with the first in the triangle and,
+	// if it is on the diagonal, the second not above it. Placements with both
+	// kings on the diagonal come last.
+	type kkPair struct{ idx, sq int }
+	var bothOnDiagonal []kkPair
+	code = 0
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 <= 27; s1++ {
+			if tbMapA1D1D4[s1] != idx || (idx == 0 && s1 != 1) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				switch {
+				case abs(s1>>3-s2>>3) <= 1 && abs(s1&7-s2&7) <= 1:
+					// Same or adjacent squares.
+				case offA1H8(s1) == 0 && offA1H8(s2) > 0:
+				case offA1H8(s1) == 0 && offA1H8(s2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, kkPair{idx, s2})
+				default:
+					tbMapKK[idx][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, p := range bothOnDiagonal {
+		tbMapKK[p.idx][p.sq] = code
+		code++
+	}
+
+	tbBinomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < tbMaxPieces && k <= n; k++ {
+			if k > 0 {
+				tbBinomial[k][n] += tbBinomial[k-1][n-1]
+			}
+			if k < n {
+				tbBinomial[k][n] += tbBinomial[k][n-1]
+			}
+		}
+	}
+
+	// tbMapPawns numbers a2-h7 so that the pawn nearest the edge and, on the
+	// same file, the lowest one has the highest number: that pawn leads.
+	available := 47
+	for leadPawns := 1; leadPawns < tbMaxPieces; leadPawns++ {
+		for file := 0; file < 4; file++ {
+			var idx uint64
+			for rank := 1; rank <= 6; rank++ {
+				sq := rank*8 + file
+				if leadPawns == 1 {
+					tbMapPawns[sq] = available
+					available--
+					tbMapPawns[sq^7] = available
+					available--
+				}
+				tbLeadPawnIdx[leadPawns][sq] = idx
+				idx += tbBinomial[leadPawns-1][tbMapPawns[sq]]
+			}
+			tbLeadPawnsSize[leadPawns][file] = idx
+		}
+	}
+}
+
+// pairsData describes one compressed sub-table: a side to move and, for
+// tables with pawns, a file of the leading pawn.
+type pairsData struct {
+	flags           uint8
+	maxSymLen       int
+	minSymLen       int
+	numBlocks       uint64
+	blockSize       uint64
+	span            uint64
+	lowestSym       []byte // little-endian uint16 per symbol length
+	btree           []byte // 3 bytes per symbol: its left and right halves
+	blockLength     []byte // little-endian uint16 per block: values in it minus one
+	blockLengthSize uint64
+	sparseIndex     []byte // 6 bytes per span: a block and an offset in it
+	sparseIndexSize uint64
+	data            []byte // the Huffman-coded blocks
+	base64          []uint64
+	symlen          []int // values represented by a symbol, minus one
+
+	pieces   [tbMaxPieces]uint8
+	groupIdx [tbMaxPieces + 1]uint64
+	groupLen [tbMaxPieces + 1]int
+	mapIdx   [4]int // where the DTZ map of each result starts
+}
+
+func (d *pairsData) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xf)<<8 | int(d.btree[3*sym])
+}
+
+func (d *pairsData) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+func (d *pairsData) blockLen(block int) int {
+	return int(binary.LittleEndian.Uint16(d.blockLength[2*block:]))
+}
+
+// bigEndian32 reads 4 bytes at the start of b, padding with zeros past its end.
+func bigEndian32(b []byte) uint32 {
+	var buf [4]byte
+	copy(buf[:], b)
+	return binary.BigEndian.Uint32(buf[:])
+}
+
+// decompress returns the value stored at index idx.
+func (d *pairsData) decompress(idx uint64) int {
+	if d.flags&tbSingleValue != 0 {
+		return d.minSymLen
+	}
+
+	// The sparse index points at the block holding the value at the middle of
+	// each span; walk from there to the block holding idx.
+	k := idx / d.span
+	block := int(binary.LittleEndian.Uint32(d.sparseIndex[6*k:]))
+	offset := int(binary.LittleEndian.Uint16(d.sparseIndex[6*k+4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+	for offset < 0 {
+		block--
+		offset += d.blockLen(block) + 1
+	}
+	for offset > d.blockLen(block) {
+		offset -= d.blockLen(block) + 1
+		block++
+	}
+
+	// Read symbols of the canonical Huffman code until one covers offset.
+	ptr := d.data[uint64(block)*d.blockSize:]
+	buf64 := uint64(bigEndian32(ptr))<<32 | uint64(bigEndian32(ptr[4:]))
+	ptr = ptr[8:]
+	buf64Size := 64
+	var sym int
+	for {
+		l := 0
+		for buf64 < d.base64[l] {
+			l++
+		}
+		sym = int((buf64 - d.base64[l]) >> uint(64-l-d.minSymLen))
+		sym += int(binary.LittleEndian.Uint16(d.lowestSym[2*l:]))
+		if offset < d.symlen[sym]+1 {
+			break
+		}
+		offset -= d.symlen[sym] + 1
+		l += d.minSymLen
+		buf64 <<= uint(l)
+		buf64Size -= l
+		if buf64Size <= 32 {
+			buf64Size += 32
+			buf64 |= uint64(bigEndian32(ptr)) << uint(64-buf64Size)
+			if len(ptr) > 4 {
+				ptr = ptr[4:]
+			} else {
+				ptr = nil
+			}
+		}
+	}
+
+	// The symbol stands for a pair of symbols, recursively; descend to the one
+	// holding offset.
+	for d.symlen[sym] != 0 {
+		left := d.left(sym)
+		if offset < d.symlen[left]+1 {
+			sym = left
+		} else {
+			offset -= d.symlen[left] + 1
+			sym = d.right(sym)
+		}
+	}
+	return d.left(sym)
+}
+
+// setSymlen works out how many values symbol s stands for.
+func (d *pairsData) setSymlen(s int, visited []bool) int {
+	visited[s] = true
+	right := d.right(s)
+	if right == 0xfff {
+		return 0
+	}
+	left := d.left(s)
+	if !visited[left] {
+		d.symlen[left] = d.setSymlen(left, visited)
+	}
+	if !visited[right] {
+		d.symlen[right] = d.setSymlen(right, visited)
+	}
+	return d.symlen[left] + d.symlen[right] + 1
+}
+
+// size returns the number of positions the sub-table has an index for.
+func (d *pairsData) size() uint64 {
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	return d.groupIdx[n]
+}
+
+// setSizes reads the header of the sub-table at off and returns the offset after it.
+func (d *pairsData) setSizes(file []byte, off int) int {
+	d.flags = file[off]
+	off++
+	if d.flags&tbSingleValue != 0 {
+		d.minSymLen = int(file[off])
+		return off + 1
+	}
+
+	tbSize := d.size()
+	d.blockSize = 1 << file[off]
+	d.span = 1 << file[off+1]
+	d.sparseIndexSize = (tbSize + d.span - 1) / d.span
+	padding := uint64(file[off+2])
+	d.numBlocks = uint64(binary.LittleEndian.Uint32(file[off+3:]))
+	d.blockLengthSize = d.numBlocks + padding
+	d.maxSymLen = int(file[off+7])
+	d.minSymLen = int(file[off+8])
+	off += 9
+	d.lowestSym = file[off:]
+
+	// Longer codes have lower values; base64[l] is the lowest code of length
+	// l+minSymLen, left-aligned in 64 bits.
+	d.base64 = make([]uint64, d.maxSymLen-d.minSymLen+1)
+	for i := len(d.base64) - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + uint64(binary.LittleEndian.Uint16(d.lowestSym[2*i:])) -
+			uint64(binary.LittleEndian.Uint16(d.lowestSym[2*i+2:]))) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= uint(64 - i - d.minSymLen)
+	}
+	off += 2 * len(d.base64)
+
+	symbols := int(binary.LittleEndian.Uint16(file[off:]))
+	off += 2
+	d.btree = file[off:]
+	d.symlen = make([]int, symbols)
+	visited := make([]bool, symbols)
+	for s := 0; s < symbols; s++ {
+		if !visited[s] {
+			d.symlen[s] = d.setSymlen(s, visited)
+		}
+	}
+	return off + 3*symbols + symbols&1
+}
+
+// tbTable is one WDL or DTZ file. Its sub-tables are read when it is first probed.
+type tbTable struct {
+	path            string
+	dtz             bool
+	symmetric       bool // both sides have the same pieces
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool
+	pawnCount       [2]int // leading colour, other colour
+
+	once   sync.Once
+	err    error
+	items  [2][4]pairsData // [side to move][file of the leading pawn]
+	dtzMap []byte
+}
+
+// sides is the number of sides to move the file has sub-tables for.
+func (t *tbTable) sides() int {
+	if t.dtz || t.symmetric {
+		return 1
+	}
+	return 2
+}
+
+func (t *tbTable) item(stm, file int) *pairsData {
+	if t.dtz {
+		stm = 0
+	}
+	if !t.hasPawns {
+		file = 0
+	}
+	return &t.items[stm][file]
+}
+
+// load reads the file and sets up its sub-tables, once.
+func (t *tbTable) load() error {
+	t.once.Do(func() {
+		file, err := os.ReadFile(t.path)
+		if err != nil {
+			t.err = err
+			return
+		}
+		magic := tbWDLMagic
+		if t.dtz {
+			magic = tbDTZMagic
+		}
+		if len(file)%64 != 16 || [4]byte(file[:4]) != magic {
+			t.err = fmt.Errorf("invalid Syzygy table %s: bad size or magic", t.path)
+			return
+		}
+		defer func() {
+			if recover() != nil {
+				t.err = fmt.Errorf("invalid Syzygy table %s: truncated or corrupt", t.path)
+			}
+		}()
+		t.err = t.setup(file)
+	})
+	return t.err
+}
+
+func (t *tbTable) setup(file []byte) error {
+	const split, hasPawns = 1, 2
+	flags := file[4]
+	if (flags&hasPawns != 0) != t.hasPawns || (!t.dtz && (flags&split != 0) == t.symmetric) {
+		return fmt.Errorf("invalid Syzygy table %s: header does not match its name", t.path)
+	}
+	off := 5
+
+	maxFile := 0
+	if t.hasPawns {
+		maxFile = 3
+	}
+	bothPawns := t.hasPawns && t.pawnCount[1] > 0
+	for f := 0; f <= maxFile; f++ {
+		order := [2][2]int{{int(file[off] & 0xf), 0xf}, {int(file[off] >> 4), 0xf}}
+		if bothPawns {
+			order[0][1], order[1][1] = int(file[off+1]&0xf), int(file[off+1]>>4)
+			off++
+		}
+		off++
+		for k := 0; k < t.pieceCount; k++ {
+			t.items[0][f].pieces[k] = file[off] & 0xf
+			t.items[1][f].pieces[k] = file[off] >> 4
+			off++
+		}
+		for i := 0; i < t.sides(); i++ {
+			t.setGroups(&t.items[i][f], order[i], f)
+		}
+	}
+	off += off & 1
+
+	for f := 0; f <= maxFile; f++ {
+		for i := 0; i < t.sides(); i++ {
+			off = t.items[i][f].setSizes(file, off)
+		}
+	}
+
+	if t.dtz {
+		mapStart := off
+		t.dtzMap = file[off:]
+		for f := 0; f <= maxFile; f++ {
+			d := &t.items[0][f]
+			if d.flags&tbMapped == 0 {
+				continue
+			}
+			if d.flags&tbWide != 0 {
+				off += off & 1
+				for i := range d.mapIdx {
+					d.mapIdx[i] = (off-mapStart)/2 + 1
+					off += 2*int(binary.LittleEndian.Uint16(file[off:])) + 2
+				}
+			} else {
+				for i := range d.mapIdx {
+					d.mapIdx[i] = off - mapStart + 1
+					off += int(file[off]) + 1
+				}
+			}
+		}
+		off += off & 1
+	}
+
+	for f := 0; f <= maxFile; f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := &t.items[i][f]
+			d.sparseIndex = file[off:]
+			off += 6 * int(d.sparseIndexSize)
+		}
+	}
+	for f := 0; f <= maxFile; f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := &t.items[i][f]
+			d.blockLength = file[off:]
+			off += 2 * int(d.blockLengthSize)
+		}
+	}
+	for f := 0; f <= maxFile; f++ {
+		for i := 0; i < t.sides(); i++ {
+			d := &t.items[i][f]
+			off = (off + 0x3f) &^ 0x3f
+			d.data = file[off:]
+			off += int(d.numBlocks * d.blockSize)
+		}
+	}
+	if off > len(file) {
+		return fmt.Errorf("invalid Syzygy table %s: truncated", t.path)
+	}
+	return nil
+}
+
+// setGroups splits the pieces of d into the groups that are encoded together:
+// the leading pawns, or the kings plus one more unique piece if there is one,
+// and then runs of identical pieces. order gives the order the groups are
+// multiplied into the index in.
+func (t *tbTable) setGroups(d *pairsData, order [2]int, file int) {
+	firstLen := 2
+	if t.hasPawns {
+		firstLen = 0
+	} else if t.hasUniquePieces {
+		firstLen = 3
+	}
+	n := 0
+	d.groupLen[0] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	bothPawns := t.hasPawns && t.pawnCount[1] > 0
+	next := 1
+	freeSquares := 64 - d.groupLen[0]
+	if bothPawns {
+		next = 2
+		freeSquares -= d.groupLen[1]
+	}
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch {
+		case k == order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= tbLeadPawnsSize[d.groupLen[0]][file]
+			case t.hasUniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case k == order[1]:
+			d.groupIdx[1] = idx
+			idx *= tbBinomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= tbBinomial[d.groupLen[next]][freeSquares]
+			freeSquares -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+// tbPieceCode returns the Syzygy code of piece.
+func tbPieceCode(piece rune) uint8 {
+	var code uint8
+	switch piece {
+	case 'P', 'p':
+		code = 1
+	case 'N', 'n':
+		code = 2
+	case 'B', 'b':
+		code = 3
+	case 'R', 'r':
+		code = 4
+	case 'Q', 'q':
+		code = 5
+	case 'K', 'k':
+		code = 6
+	}
+	if !isWhite(piece) {
+		code |= 8
+	}
+	return code
+}
+
+// tbBoard returns the piece on Syzygy square sq.
+func tbBoard(pos *Position, sq int) rune {
+	return pos.Board[7-sq>>3][sq&7]
+}
+
+// errChangeSTM means a DTZ table only has the other side to move.
+var errChangeSTM = fmt.Errorf("DTZ table stores the other side to move")
+
+// probe looks pos up in t. blackStronger says the position has the table's
+// material with the colours swapped. For WDL tables the result is a WDL; for
+// DTZ tables it is the distance to zeroing in plies for a position whose WDL,
+// which must be given, is not a draw.
+func (t *tbTable) probe(pos *Position, blackStronger bool, wdl WDL) (result int, err error) {
+	if err := t.load(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("invalid Syzygy table %s: index out of range", t.path)
+		}
+	}()
+
+	d, stm, file, idx := t.index(pos, blackStronger)
+	if t.dtz && int(d.flags&tbSTM) != stm && !(t.symmetric && !t.hasPawns) {
+		return 0, errChangeSTM
+	}
+	value := d.decompress(idx)
+	if !t.dtz {
+		return value - 2, nil
+	}
+	return t.mapScore(file, value, wdl), nil
+}
+
+// index returns the sub-table of t that holds pos and the index of pos in it,
+// with the side to move and the file of the leading pawn the sub-table is for.
+// The pieces of t must have been read.
+func (t *tbTable) index(pos *Position, blackStronger bool) (d *pairsData, stm, file int, idx uint64) {
+	// Tables are stored with the stronger side as White, and symmetric ones
+	// with White to move; otherwise swap the colours and mirror the board.
+	flip := blackStronger || (t.symmetric && !pos.WhiteToMove)
+	var flipColor uint8
+	var flipSquares int
+	if !pos.WhiteToMove {
+		stm = 1
+	}
+	if flip {
+		flipColor, flipSquares = 8, 56
+		stm ^= 1
+	}
+
+	var squares [tbMaxPieces]int
+	var pieces [tbMaxPieces]uint8
+	size, leadPawns := 0, 0
+	var leadPawnSquares uint64
+
+	// With pawns there is a sub-table per file of the leading pawn: the pawn of
+	// the stronger side with the highest tbMapPawns.
+	if t.hasPawns {
+		pawn := rune('P')
+		if (t.items[0][0].pieces[0]^flipColor)&8 != 0 {
+			pawn = 'p'
+		}
+		for sq := 0; sq < 64; sq++ {
+			if tbBoard(pos, sq) == pawn {
+				squares[size] = sq ^ flipSquares
+				size++
+				leadPawnSquares |= 1 << sq
+			}
+		}
+		leadPawns = size
+		lead := 0
+		for i := 1; i < leadPawns; i++ {
+			if tbMapPawns[squares[i]] > tbMapPawns[squares[lead]] {
+				lead = i
+			}
+		}
+		squares[0], squares[lead] = squares[lead], squares[0]
+		file = squares[0] & 7
+		if file > 3 {
+			file = (squares[0] ^ 7) & 7
+		}
+	}
+
+	for sq := 0; sq < 64; sq++ {
+		piece := tbBoard(pos, sq)
+		if piece == 0 || leadPawnSquares&(1<<sq) != 0 {
+			continue
+		}
+		squares[size] = sq ^ flipSquares
+		pieces[size] = tbPieceCode(piece) ^ flipColor
+		size++
+	}
+
+	d = t.item(stm, file)
+
+	// Put the pieces in the order the table encodes them in.
+	for i := leadPawns; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror so that the leading piece is on files a-d.
+	if squares[0]&7 > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 7
+		}
+	}
+
+	if t.hasPawns {
+		idx = tbLeadPawnIdx[leadPawns][squares[0]]
+		rest := squares[1:leadPawns]
+		sort.SliceStable(rest, func(i, j int) bool { return tbMapPawns[rest[i]] < tbMapPawns[rest[j]] })
+		for i := 1; i < leadPawns; i++ {
+			idx += tbBinomial[i][tbMapPawns[squares[i]]]
+		}
+	} else {
+		// Without pawns, also mirror the leading piece onto ranks 1-4 and, for
+		// the first piece of the leading group off the diagonal, below it.
+		if squares[0]>>3 > 3 {
+			for i := 0; i < size; i++ {
+				squares[i] ^= 56
+			}
+		}
+		for i := 0; i < d.groupLen[0]; i++ {
+			if offA1H8(squares[i]) == 0 {
+				continue
+			}
+			if offA1H8(squares[i]) > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = (squares[j]>>3 | squares[j]<<3) & 63
+				}
+			}
+			break
+		}
+		idx = t.encodeLeadingPieces(squares[:])
+	}
+
+	// Every other group is encoded as a combination of squares, skipping those
+	// taken by earlier groups.
+	idx *= d.groupIdx[0]
+	groupStart := d.groupLen[0]
+	remainingPawns := t.hasPawns && t.pawnCount[1] > 0
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[groupStart : groupStart+d.groupLen[next]]
+		sort.Ints(group)
+		var n uint64
+		for i, sq := range group {
+			adjust := 0
+			for _, s := range squares[:groupStart] {
+				if sq > s {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += tbBinomial[i+1][sq-adjust]
+		}
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		groupStart += d.groupLen[next]
+	}
+
+	return d, stm, file, idx
+}
+
+// encodeLeadingPieces encodes the leading group of a pawnless table: three
+// unique pieces, or just the two kings.
+func (t *tbTable) encodeLeadingPieces(sq []int) uint64 {
+	if !t.hasUniquePieces {
+		return uint64(tbMapKK[tbMapA1D1D4[sq[0]]][sq[1]])
+	}
+
+	adjust1, adjust2 := 0, 0
+	if sq[1] > sq[0] {
+		adjust1 = 1
+	}
+	if sq[2] > sq[0] {
+		adjust2++
+	}
+	if sq[2] > sq[1] {
+		adjust2++
+	}
+	switch {
+	case offA1H8(sq[0]) != 0:
+		return uint64((tbMapA1D1D4[sq[0]]*63+sq[1]-adjust1)*62 + sq[2] - adjust2)
+	case offA1H8(sq[1]) != 0:
+		return uint64((6*63+(sq[0]>>3)*28+tbMapB1H1H7[sq[1]])*62 + sq[2] - adjust2)
+	case offA1H8(sq[2]) != 0:
+		return uint64(6*63*62 + 4*28*62 + (sq[0]>>3)*7*28 + (sq[1]>>3-adjust1)*28 + tbMapB1H1H7[sq[2]])
+	default:
+		return uint64(6*63*62 + 4*28*62 + 4*7*28 + (sq[0]>>3)*7*6 + (sq[1]>>3-adjust1)*6 + sq[2]>>3 - adjust2)
+	}
+}
+
+// mapScore turns a stored DTZ value into plies.
+func (t *tbTable) mapScore(file, value int, wdl WDL) int {
+	// Where each result's map starts: Win, Loss, CursedWin, BlessedLoss.
+	wdlMap := [5]int{1, 3, 0, 2, 0}
+	d := t.item(0, file)
+	if d.flags&tbMapped != 0 {
+		i := d.mapIdx[wdlMap[wdl+2]] + value
+		if d.flags&tbWide != 0 {
+			value = int(binary.LittleEndian.Uint16(t.dtzMap[2*i:]))
+		} else {
+			value = int(t.dtzMap[i])
+		}
+	}
+	if (wdl == WDLWin && d.flags&tbWinPlies == 0) ||
+		(wdl == WDLLoss && d.flags&tbLossPlies == 0) ||
+		wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+		value *= 2
+	}
+	return value + 1
+}
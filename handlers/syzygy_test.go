@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyzygyIndexTables(t *testing.T) {
+	// The two kings have 462 placements, numbered 0-461.
+	seen := map[int]bool{}
+	for _, row := range tbMapKK {
+		for _, code := range row {
+			seen[code] = true
+		}
+	}
+	for code := 0; code < 462; code++ {
+		if !seen[code] {
+			t.Fatalf("no king placement has code %d", code)
+		}
+	}
+	if seen[462] {
+		t.Error("king placement codes go past 461")
+	}
+
+	// Pawns on a2-h7 are numbered 0-47.
+	seen = map[int]bool{}
+	for sq := 8; sq < 56; sq++ {
+		seen[tbMapPawns[sq]] = true
+	}
+	if len(seen) != 48 || seen[48] {
+		t.Errorf("tbMapPawns numbers a2-h7 with %d distinct values, want 0-47", len(seen))
+	}
+
+	if got := tbBinomial[2][5]; got != 10 {
+		t.Errorf("tbBinomial[2][5] = %d, want 10", got)
+	}
+	for file := 0; file < 4; file++ {
+		if got := tbLeadPawnsSize[1][file]; got != 6 {
+			t.Errorf("a single leading pawn on file %d has %d squares, want 6", file, got)
+		}
+	}
+}
+
+// writeTableFiles creates empty files with the given names in a new directory.
+// They are only opened when probed.
+func writeTableFiles(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestOpenTablebase(t *testing.T) {
+	dir := writeTableFiles(t, "KQvK.rtbw", "KQvK.rtbz", "KRPvKR.rtbw", "KXvK.rtbw", "KQvK.txt")
+	other := writeTableFiles(t, "KRvK.rtbw", "KQvK.rtbw")
+
+	tb, err := OpenTablebase(dir + string(os.PathListSeparator) + other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tb.Len() != 3 || tb.MaxPieces() != 5 {
+		t.Errorf("found %d tables of up to %d pieces, want 3 of up to 5", tb.Len(), tb.MaxPieces())
+	}
+	if tb.tables["KvKQ"] != tb.tables["KQvK"] || tb.tables["KQvK"].dtz == nil {
+		t.Error("KQvK is not found for both colours with its DTZ table")
+	}
+
+	if _, err := OpenTablebase(filepath.Join(dir, "missing")); err == nil {
+		t.Error("OpenTablebase accepted a missing directory")
+	}
+}
+
+func TestTablebaseWithoutTables(t *testing.T) {
+	dir := writeTableFiles(t, "KQvK.rtbw", "KRvK.rtbw")
+	// A file of the right size with a bad header must not crash the prober.
+	bad := append(append([]byte{}, tbWDLMagic[:]...), make([]byte, 76)...)
+	if err := os.WriteFile(filepath.Join(dir, "KRvK.rtbw"), bad, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tb, err := OpenTablebase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fen string
+		ok  bool
+		wdl WDL
+	}{
+		// Bare kings need no table.
+		{"8/8/8/4k3/8/8/8/4K3 w - - 0 1", true, WDLDraw},
+		// Empty and corrupt files, and material with no table at all.
+		{"8/8/8/4k3/8/8/8/3QK3 w - - 0 1", false, WDLDraw},
+		{"8/8/8/4k3/8/8/8/3RK3 b - - 0 1", false, WDLDraw},
+		{"8/8/8/4k3/8/8/8/3BK3 w - - 0 1", false, WDLDraw},
+		// Tables know nothing of castling.
+		{"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", false, WDLDraw},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wdl, ok := tb.ProbeWDL(&pos); ok != tc.ok || wdl != tc.wdl {
+			t.Errorf("ProbeWDL(%q) = %v, %v; want %v, %v", tc.fen, wdl, ok, tc.wdl, tc.ok)
+		}
+	}
+}
+
+// TestSyzygySingleValueTable probes a hand-made KQvK table whose sub-tables
+// store a single value each: a win with White to move and a loss with Black.
+func TestSyzygySingleValueTable(t *testing.T) {
+	file := make([]byte, 80)
+	copy(file, tbWDLMagic[:])
+	copy(file[4:], []byte{
+		1,                // not symmetric, no pawns
+		0,                // group order
+		0x66, 0x55, 0xee, // pieces K, Q, k for both sides to move
+		0,                // padding
+		tbSingleValue, 4, // White to move: WDLWin + 2
+		tbSingleValue, 0, // Black to move: WDLLoss + 2
+	})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "KQvK.rtbw"), file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tb, err := OpenTablebase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fen string
+		wdl WDL
+	}{
+		{"4k3/8/8/8/8/8/8/3QK3 w - - 0 1", WDLWin},
+		{"4k3/8/8/8/8/8/8/3QK3 b - - 0 1", WDLLoss},
+		// Colours swapped: the table is read for the other side.
+		{"3qk3/8/8/8/8/8/8/4K3 b - - 0 1", WDLWin},
+		{"3qk3/8/8/8/8/8/8/4K3 w - - 0 1", WDLLoss},
+		// Taking the loose queen draws, which beats the stored loss.
+		{"8/8/8/8/8/8/3k4/3Q3K b - - 0 1", WDLDraw},
+	}
+	for _, tc := range tests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wdl, ok := tb.ProbeWDL(&pos); !ok || wdl != tc.wdl {
+			t.Errorf("ProbeWDL(%q) = %v, %v; want %v", tc.fen, wdl, ok, tc.wdl)
+		}
+	}
+}
+
+// TestSyzygyFiles probes the tables in testdata/syzygy; see syzygy_gen_test.go.
+func TestSyzygyFiles(t *testing.T) {
+	tb, err := OpenTablebase(syzygyTestDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tb.Len() != len(syzygyTestTables) {
+		t.Fatalf("found %d tables in %s, want %d", tb.Len(), syzygyTestDir, len(syzygyTestTables))
+	}
+
+	wdlTests := []struct {
+		fen string
+		wdl WDL
+	}{
+		{"4k3/8/8/8/8/8/8/3QK3 w - - 0 1", WDLWin},
+		{"4k3/8/8/8/8/8/8/3QK3 b - - 0 1", WDLLoss},
+		{"4k3/8/8/8/3q4/8/8/4K3 w - - 0 1", WDLLoss},
+		{"3rk3/8/8/8/8/8/8/3RK3 w - - 0 1", WDLDraw},
+		{"8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", WDLDraw},
+		{"4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", WDLWin},
+		{"4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", WDLLoss},
+		{"8/8/8/4k3/8/8/8/2BNK3 w - - 0 1", WDLWin},
+		// Taking the loose knight leaves a bishop that cannot mate.
+		{"K7/8/8/8/8/8/2k5/3N3B b - - 0 1", WDLDraw},
+		// The rook pawn cannot drive the king out of the corner.
+		{"k7/8/K7/P7/8/8/8/8 w - - 0 1", WDLDraw},
+	}
+	for _, tc := range wdlTests {
+		pos, err := ParseFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wdl, ok := tb.ProbeWDL(&pos); !ok || wdl != tc.wdl {
+			t.Errorf("ProbeWDL(%q) = %v, %v; want %v", tc.fen, wdl, ok, tc.wdl)
+		}
+	}
+
+	// Qc8 mates at once.
+	pos, _ := ParseFEN("k7/8/1K6/8/8/8/8/2Q5 w - - 0 1")
+	mate := Move{FromRow: 7, FromCol: 2, ToRow: 0, ToCol: 2}
+	if dtz, ok := tb.ProbeDTZ(&pos); !ok || dtz != 1 {
+		t.Errorf("ProbeDTZ of a mate in one = %d, %v; want 1", dtz, ok)
+	}
+	// The same with the colours swapped, and the side that is mated, whose
+	// DTZ is read through the moves of the side the table stores.
+	flipped, _ := ParseFEN("K7/8/1k6/8/8/8/8/2q5 b - - 0 1")
+	if dtz, ok := tb.ProbeDTZ(&flipped); !ok || dtz != 1 {
+		t.Errorf("ProbeDTZ of Black's mate in one = %d, %v; want 1", dtz, ok)
+	}
+	losing, _ := ParseFEN("4k3/8/8/8/8/8/8/3RK3 b - - 0 1")
+	if dtz, ok := tb.ProbeDTZ(&losing); !ok || dtz >= 0 {
+		t.Errorf("ProbeDTZ of KRvK with Black to move = %d, %v; want a loss", dtz, ok)
+	}
+	if moves, wdl, ok := tb.RootMoves(&pos); !ok || wdl != WDLWin || moves[0] != mate {
+		t.Errorf("RootMoves = %v, %v, %v; want Qc8 first and a win", moves, wdl, ok)
+	}
+
+	s := NewSearcher()
+	s.SetTablebase(tb)
+	if got := s.FindBestMoveWithLimits(context.Background(), &pos, SearchLimits{MaxDepth: 1}, nil); got != mate {
+		t.Errorf("search with tablebases played %v, want %v", got, mate)
+	}
+}
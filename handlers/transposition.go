@@ -0,0 +1,163 @@
+package handlers
+
+import "sync/atomic"
+
+// Bound tells how a stored score relates to the true score of the position.
+type Bound uint8
+
+const (
+	BoundNone  Bound = iota
+	BoundExact       // the score is exact
+	BoundLower       // the search failed high: the true score is at least Score
+	BoundUpper       // the search failed low: the true score is at most Score
+)
+
+// boundFor classifies score from a search of the window alpha..beta.
+func boundFor(score, alpha, beta int) Bound {
+	switch {
+	case score <= alpha:
+		return BoundUpper
+	case score >= beta:
+		return BoundLower
+	}
+	return BoundExact
+}
+
+// HashMap is one transposition table entry as seen by the search.
+type HashMap struct {
+	HashKey  uint64
+	Score    int
+	Depth    int
+	Bound    Bound
+	Age      uint8 // search generation that stored the entry
+	BestMove Move
+}
+
+// DefaultHashMB is the transposition table size of a new Searcher in megabytes.
+const DefaultHashMB = 16
+
+// MaxHashMB is the largest table SetHashSize accepts.
+const MaxHashMB = 4096
+
+// ttSlot stores a HashMap packed into data, with key holding the hash XORed
+// with data. A slot torn by two threads writing it at once then fails the key
+// check instead of returning another position's entry, so the table can be
+// shared without locks.
+type ttSlot struct {
+	key  atomic.Uint64
+	data atomic.Uint64
+}
+
+// ttBucket holds two entries for the positions that map to it: the first is
+// only replaced by a search at least as deep or by a newer search, the second
+// takes whatever the first turns away.
+type ttBucket [2]ttSlot
+
+// transpositionTable is safe for concurrent use by the threads of a search.
+type transpositionTable struct {
+	buckets []ttBucket
+	age     uint8 // generation of the current search, 6 bits
+}
+
+// newTranspositionTable returns an empty table of at most mb megabytes.
+func newTranspositionTable(mb int) *transpositionTable {
+	const bucketBytes = 32
+	count := 1
+	for count*2*bucketBytes <= mb<<20 {
+		count *= 2
+	}
+	return &transpositionTable{buckets: make([]ttBucket, count)}
+}
+
+func (t *transpositionTable) bucket(hash uint64) *ttBucket {
+	return &t.buckets[hash&uint64(len(t.buckets)-1)]
+}
+
+// newSearch starts a new generation, so entries of earlier searches are the
+// first to be replaced. It must not run during a search.
+func (t *transpositionTable) newSearch() {
+	t.age = (t.age + 1) & 63
+}
+
+// probe returns the entry stored for hash, if any.
+func (t *transpositionTable) probe(hash uint64) (HashMap, bool) {
+	b := t.bucket(hash)
+	for i := range b {
+		data := b[i].data.Load()
+		if data != 0 && b[i].key.Load()^data == hash {
+			return unpackEntry(hash, data), true
+		}
+	}
+	return HashMap{}, false
+}
+
+// store saves entry, which must have a bound, in the bucket for entry.HashKey.
+// The depth-preferred slot takes it if it holds the same position, an entry
+// from an older search or one searched no deeper; otherwise the entry goes to
+// the always-replace slot.
+func (t *transpositionTable) store(entry HashMap) {
+	entry.Age = t.age
+	data := packEntry(entry)
+	b := t.bucket(entry.HashKey)
+
+	slot := &b[1]
+	oldData := b[0].data.Load()
+	if oldData == 0 {
+		slot = &b[0]
+	} else {
+		old := unpackEntry(entry.HashKey, oldData)
+		sameKey := b[0].key.Load()^oldData == entry.HashKey
+		if sameKey || old.Age != entry.Age || entry.Depth >= old.Depth {
+			slot = &b[0]
+		}
+	}
+	slot.key.Store(entry.HashKey ^ data)
+	slot.data.Store(data)
+}
+
+// clear empties the table. It must not run during a search.
+func (t *transpositionTable) clear() {
+	for i := range t.buckets {
+		for j := range t.buckets[i] {
+			t.buckets[i][j].key.Store(0)
+			t.buckets[i][j].data.Store(0)
+		}
+	}
+	t.age = 0
+}
+
+// packEntry packs the move into bits 0-14 (three bits per coordinate and the
+// promotion), the depth into bits 16-23, the bound into bits 24-25, the age into
+// bits 26-31 and the score into bits 32-63.
+func packEntry(e HashMap) uint64 {
+	m := e.BestMove
+	promotion := 0
+	for i, p := range promotionPieces {
+		if m.Promotion == p {
+			promotion = i + 1
+		}
+	}
+	move := uint64(m.FromRow)<<9 | uint64(m.FromCol)<<6 | uint64(m.ToRow)<<3 | uint64(m.ToCol) | uint64(promotion)<<12
+	return move | uint64(uint8(e.Depth))<<16 | uint64(e.Bound&3)<<24 | uint64(e.Age&63)<<26 |
+		uint64(uint32(int32(e.Score)))<<32
+}
+
+func unpackEntry(hash, data uint64) HashMap {
+	move := Move{
+		FromRow: int(data >> 9 & 7),
+		FromCol: int(data >> 6 & 7),
+		ToRow:   int(data >> 3 & 7),
+		ToCol:   int(data & 7),
+	}
+	if promotion := int(data >> 12 & 7); promotion > 0 {
+		move.Promotion = promotionPieces[promotion-1]
+	}
+	return HashMap{
+		HashKey:  hash,
+		Score:    int(int32(uint32(data >> 32))),
+		Depth:    int(uint8(data >> 16)),
+		Bound:    Bound(data >> 24 & 3),
+		Age:      uint8(data >> 26 & 63),
+		BestMove: move,
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import "testing"
+
+func TestTranspositionTableRoundTrip(t *testing.T) {
+	tt := newTranspositionTable(1)
+	want := HashMap{
+		HashKey:  0x9d39247e33776d41,
+		Score:    -12345,
+		Depth:    7,
+		Bound:    BoundLower,
+		BestMove: Move{FromRow: 1, FromCol: 4, ToRow: 0, ToCol: 5, Promotion: 'N'},
+	}
+	tt.store(want)
+
+	got, found := tt.probe(want.HashKey)
+	if !found || got != want {
+		t.Fatalf("probe = %+v, %v; want %+v", got, found, want)
+	}
+	if _, found := tt.probe(want.HashKey ^ 1<<40); found {
+		t.Error("probe found an entry for a different key in the same bucket")
+	}
+}
+
+func TestTranspositionTableReplacement(t *testing.T) {
+	tt := newTranspositionTable(1)
+	buckets := uint64(len(tt.buckets))
+	deep := HashMap{HashKey: 1, Depth: 6, Bound: BoundExact}
+	shallow := HashMap{HashKey: 1 + buckets, Depth: 2, Bound: BoundExact}
+	other := HashMap{HashKey: 1 + 2*buckets, Depth: 3, Bound: BoundExact}
+
+	// A shallower entry must not push out a deeper one from the same search.
+	tt.store(deep)
+	tt.store(shallow)
+	tt.store(other)
+	if _, found := tt.probe(deep.HashKey); !found {
+		t.Error("deep entry was replaced by shallower ones")
+	}
+	if _, found := tt.probe(other.HashKey); !found {
+		t.Error("latest shallow entry was not kept in the always-replace slot")
+	}
+
+	// Once a new search starts, the old deep entry gives way.
+	tt.newSearch()
+	tt.store(shallow)
+	if got, found := tt.probe(shallow.HashKey); !found || got.Age != tt.age {
+		t.Error("entry from an older search was not replaced")
+	}
+}
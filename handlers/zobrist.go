@@ -0,0 +1,158 @@
+package handlers
+
+// Zobrist keys: one per piece and square, one for Black to move, one per
+// castling right (K, Q, k, q) and one per en-passant file. The en-passant key
+// is only used when the side to move has a pawn that can capture there:
+// otherwise the target square changes nothing, and a position repeated across
+// a double pawn push must hash the same.
+var (
+	zobristTable       [12][64]uint64
+	zobristBlackToMove uint64
+	zobristCastling    [4]uint64
+	zobristEnPassant   [8]uint64
+)
+
+// DefaultZobristSeed generates the keys the engine uses unless SeedZobrist picks
+// others. The keys, and so every hash, are the same in every run, build and
+// WASM worker.
+const DefaultZobristSeed uint64 = 0x5eed0f2c4e55b0a7
+
+func init() {
+	InitZobrist()
+}
+
+// InitZobrist resets the keys to those generated from DefaultZobristSeed.
+func InitZobrist() {
+	SeedZobrist(DefaultZobristSeed)
+}
+
+// SeedZobrist regenerates the keys from seed. Hashes computed with other keys,
+// including the contents of every transposition table, become meaningless, so it
+// must not be called while a search is running.
+func SeedZobrist(seed uint64) {
+	rng := splitMix64(seed)
+	for p := 0; p < 12; p++ {
+		for sq := 0; sq < 64; sq++ {
+			zobristTable[p][sq] = rng.next()
+		}
+	}
+	zobristBlackToMove = rng.next()
+	for i := range zobristCastling {
+		zobristCastling[i] = rng.next()
+	}
+	for i := range zobristEnPassant {
+		zobristEnPassant[i] = rng.next()
+	}
+}
+
+// splitMix64 is a small, well-mixed generator; unlike math/rand its sequence is
+// fixed by its definition here and cannot change with the Go release.
+type splitMix64 uint64
+
+func (r *splitMix64) next() uint64 {
+	*r += 0x9e3779b97f4a7c15
+	z := uint64(*r)
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+var pieceToIndex = map[rune]int{
+	'P': 0, 'N': 1, 'B': 2, 'R': 3, 'Q': 4, 'K': 5,
+	'p': 6, 'n': 7, 'b': 8, 'r': 9, 'q': 10, 'k': 11,
+}
+
+// pieceKey returns the key for piece on row/col, or 0 for an empty square.
+func pieceKey(piece rune, row, col int) uint64 {
+	if pieceIdx, ok := pieceToIndex[piece]; ok {
+		return zobristTable[pieceIdx][row*8+col]
+	}
+	return 0
+}
+
+// castlingKey returns the combined key of the castling rights in c.
+func castlingKey(c CastlingRights) uint64 {
+	var key uint64
+	for i, right := range []bool{c.WhiteKingSide, c.WhiteQueenSide, c.BlackKingSide, c.BlackQueenSide} {
+		if right {
+			key ^= zobristCastling[i]
+		}
+	}
+	return key
+}
+
+// GetZobristValue computes the hash of pos from scratch: pieces, side to move,
+// castling rights and, when a capture there is possible, en-passant file.
+func GetZobristValue(pos *Position) uint64 {
+	var hash uint64 = 0
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash ^= pieceKey(pos.Board[row][col], row, col)
+		}
+	}
+	if !pos.WhiteToMove {
+		hash ^= zobristBlackToMove
+	}
+	hash ^= castlingKey(pos.Castling)
+	if pos.canCaptureEnPassant() {
+		hash ^= zobristEnPassant[pos.EnPassantCol]
+	}
+	return hash
+}
+
+// UpdateHashForMove returns the hash of the position after move, given the hash
+// currentHash of pos before it. It must be called before move is made on pos,
+// and handles captures, promotions, castling, en passant and the change in
+// castling rights and side to move exactly as MakeMove does.
+func UpdateHashForMove(currentHash uint64, move Move, pos *Position) uint64 {
+	newHash := currentHash
+	board := &pos.Board
+
+	fromPiece := board[move.FromRow][move.FromCol]
+	newHash ^= pieceKey(fromPiece, move.FromRow, move.FromCol)
+
+	if pos.isEnPassantCapture(move) {
+		newHash ^= pieceKey(board[move.FromRow][move.ToCol], move.FromRow, move.ToCol)
+	} else {
+		newHash ^= pieceKey(board[move.ToRow][move.ToCol], move.ToRow, move.ToCol)
+	}
+
+	placed := fromPiece
+	if isPromotionMove(fromPiece, move.ToRow) {
+		placed = promotedPiece(fromPiece, move.Promotion)
+	}
+	newHash ^= pieceKey(placed, move.ToRow, move.ToCol)
+
+	if (fromPiece == 'K' || fromPiece == 'k') && abs(move.ToCol-move.FromCol) == 2 {
+		rookFrom, rookTo := 7, 5
+		if move.ToCol < move.FromCol {
+			rookFrom, rookTo = 0, 3
+		}
+		rook := board[move.FromRow][rookFrom]
+		newHash ^= pieceKey(rook, move.FromRow, rookFrom) ^ pieceKey(rook, move.FromRow, rookTo)
+	}
+
+	castling := pos.Castling
+	UpdateCastlingRights(*board, move.FromRow, move.FromCol, &castling)
+	UpdateCastlingRights(*board, move.ToRow, move.ToCol, &castling)
+	newHash ^= castlingKey(pos.Castling) ^ castlingKey(castling)
+
+	if pos.canCaptureEnPassant() {
+		newHash ^= zobristEnPassant[pos.EnPassantCol]
+	}
+	if (fromPiece == 'P' || fromPiece == 'p') && abs(move.ToRow-move.FromRow) == 2 &&
+		enPassantCapturer(board, (move.FromRow+move.ToRow)/2, move.FromCol, !pos.WhiteToMove) {
+		newHash ^= zobristEnPassant[move.FromCol]
+	}
+
+	return newHash ^ zobristBlackToMove
+}
+
+// UpdateHashForNullMove returns the hash of the position after a null move
+// (MakeNullMove) given the hash currentHash of pos before it.
+func UpdateHashForNullMove(currentHash uint64, pos *Position) uint64 {
+	if pos.canCaptureEnPassant() {
+		currentHash ^= zobristEnPassant[pos.EnPassantCol]
+	}
+	return currentHash ^ zobristBlackToMove
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestIncrementalHash plays random games from the perft positions, which between
+// them cover castling, en passant and promotions, and checks after every move
+// that UpdateHashForMove agrees with hashing the new position from scratch.
+func TestIncrementalHash(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, tc := range perftPositions {
+		for game := 0; game < 20; game++ {
+			pos, err := ParseFEN(tc.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := GetZobristValue(&pos)
+			for ply := 0; ply < 200; ply++ {
+				moves := generateLegalMoves(&pos)
+				if len(moves) == 0 {
+					break
+				}
+				move := moves[rng.Intn(len(moves))]
+				before := pos.FEN()
+				hash = UpdateHashForMove(hash, move, &pos)
+				pos.MakeMove(move)
+				if want := GetZobristValue(&pos); hash != want {
+					t.Fatalf("%s: after %v from %q the incremental hash is %x, want %x", tc.name, move, before, hash, want)
+				}
+			}
+		}
+	}
+}
+
+// TestNullMoveHash checks UpdateHashForNullMove against hashing from scratch,
+// and that UnmakeNullMove restores the position, with and without an
+// en-passant target.
+func TestNullMoveHash(t *testing.T) {
+	for _, fen := range []string{StartFEN, "r3k2r/8/8/8/4Pp2/8/8/R3K2R b KQkq e3 0 1"} {
+		pos, err := ParseFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := UpdateHashForNullMove(GetZobristValue(&pos), &pos)
+		undo := pos.MakeNullMove()
+		if want := GetZobristValue(&pos); hash != want {
+			t.Errorf("%q: null-move hash is %x, want %x", fen, hash, want)
+		}
+		pos.UnmakeNullMove(undo)
+		if got := pos.FEN(); got != fen {
+			t.Errorf("after UnmakeNullMove the position is %q, want %q", got, fen)
+		}
+	}
+}
+
+func TestHashIncludesSideCastlingAndEnPassant(t *testing.T) {
+	fens := []string{
+		"r3k2r/8/8/8/4Pp2/8/8/R3K2R b KQkq e3 0 1",
+		"r3k2r/8/8/8/4Pp2/8/8/R3K2R w KQkq e3 0 1",
+		"r3k2r/8/8/8/4Pp2/8/8/R3K2R b Kkq e3 0 1",
+		"r3k2r/8/8/8/4Pp2/8/8/R3K2R b KQkq - 0 1",
+	}
+	seen := map[uint64]string{}
+	for _, fen := range fens {
+		pos, err := ParseFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := GetZobristValue(&pos)
+		if other, ok := seen[hash]; ok {
+			t.Errorf("%q and %q hash to the same key", fen, other)
+		}
+		seen[hash] = fen
+	}
+}
+
+// TestEnPassantHashNeedsCapture checks that an en-passant target only changes
+// the hash when the side to move can capture there.
+func TestEnPassantHashNeedsCapture(t *testing.T) {
+	tests := []struct {
+		withTarget, without string
+		same                bool
+	}{
+		{"4k3/8/8/8/4P3/8/8/4K3 b - e3 0 1", "4k3/8/8/8/4P3/8/8/4K3 b - - 0 1", true},
+		{"4k3/8/8/8/4Pp2/8/8/4K3 b - e3 0 1", "4k3/8/8/8/4Pp2/8/8/4K3 b - - 0 1", false},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "4k3/8/8/3pP3/8/8/8/4K3 w - - 0 1", false},
+		// A white pawn next to the target square cannot capture for Black.
+		{"4k3/8/8/8/3PP3/8/8/4K3 b - e3 0 1", "4k3/8/8/8/3PP3/8/8/4K3 b - - 0 1", true},
+	}
+	for _, tc := range tests {
+		a, _ := ParseFEN(tc.withTarget)
+		b, _ := ParseFEN(tc.without)
+		if same := GetZobristValue(&a) == GetZobristValue(&b); same != tc.same {
+			t.Errorf("%q and %q hash the same: %v, want %v", tc.withTarget, tc.without, same, tc.same)
+		}
+	}
+}
+
+// TestZobristKeysAreStable pins the default keys: hashes stored in books or
+// saved tables must not change between runs, builds or Go releases.
+func TestZobristKeysAreStable(t *testing.T) {
+	defer InitZobrist()
+
+	want := map[string]uint64{
+		StartFEN:              0xda17743e7a157747,
+		perftPositions[1].fen: 0xc12fb0004788939e,
+	}
+	for fen, hash := range want {
+		pos, err := ParseFEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		InitZobrist()
+		if got := GetZobristValue(&pos); got != hash {
+			t.Errorf("hash of %q = %#x, want %#x", fen, got, hash)
+		}
+
+		SeedZobrist(42)
+		if got := GetZobristValue(&pos); got == hash {
+			t.Errorf("SeedZobrist(42) left the hash of %q unchanged", fen)
+		}
+	}
+}
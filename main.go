@@ -4,13 +4,22 @@ package main
 
 import (
 	"chess-engine/handlers"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"syscall/js"
+	"time"
 )
 
-var currentBoard [8][8]rune
+var currentPos handlers.Position
+
+// gameHistory holds the hashes of the game positions before currentPos, so that
+// repetitions are found and searched as draws.
+var gameHistory []uint64
+
+// searcher keeps its transposition table between the moves of a game.
+var searcher = handlers.NewSearcher()
 
 type MoveRequest struct {
 	Fen string `json:"fen"`
@@ -35,8 +44,6 @@ type ValidateResponse struct {
 }
 
 func main() {
-	handlers.InitZobrist()
-
 	js.Global().Set("init_board_wasm", js.FuncOf(init_board_wasm))
 	js.Global().Set("validate_move_string_wasm", js.FuncOf(validate_move_string_wasm))
 	js.Global().Set("get_ai_move_string_wasm", js.FuncOf(get_ai_move_string_wasm))
@@ -46,6 +53,10 @@ func main() {
 	js.Global().Set("search_subset_wasm", js.FuncOf(search_subset_wasm))
 	js.Global().Set("apply_move_wasm", js.FuncOf(apply_move_wasm))
 
+	// Opening book: the page fetches the .bin file and passes its bytes in
+	js.Global().Set("load_book_wasm", js.FuncOf(load_book_wasm))
+	js.Global().Set("book_move_wasm", js.FuncOf(book_move_wasm))
+
 	// Keep old functions for backward compatibility
 	js.Global().Set("validate_move_wasm", js.FuncOf(validate_move_wasm))
 	js.Global().Set("get_ai_move_wasm", js.FuncOf(get_ai_move_wasm))
@@ -55,50 +66,85 @@ func main() {
 	<-c
 }
 
+// init_board_wasm sets the board from a FEN. An optional second argument is a
+// JSON array with the FENs of the earlier positions of the game, oldest first.
 func init_board_wasm(this js.Value, args []js.Value) interface{} {
-	fen := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR"
+	fen := handlers.StartFEN
 	if len(args) > 0 {
 		fen = args[0].String()
 	}
-	currentBoard = parseFEN(fen)
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
+	}
+	history, err := parseHistory(args, 1)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
+	}
+	currentPos = pos
+	gameHistory = history
+	searcher.SetHistory(gameHistory)
 	return nil
 }
 
+// parseHistory reads the optional JSON array of FENs in args[i] and returns the
+// hashes of those positions.
+func parseHistory(args []js.Value, i int) ([]uint64, error) {
+	if len(args) <= i || args[i].Type() != js.TypeString {
+		return nil, nil
+	}
+	var fens []string
+	if err := json.Unmarshal([]byte(args[i].String()), &fens); err != nil {
+		return nil, fmt.Errorf("invalid history JSON: %v", err)
+	}
+	hashes := make([]uint64, len(fens))
+	for j, fen := range fens {
+		pos, err := handlers.ParseFEN(fen)
+		if err != nil {
+			return nil, err
+		}
+		hashes[j] = handlers.GetZobristValue(&pos)
+	}
+	return hashes, nil
+}
+
+// drawReason returns why the game that reached pos is drawn, or "" if it is not.
+func drawReason(pos *handlers.Position, history []uint64) string {
+	if reason := handlers.CheckDraw(pos, history); reason != handlers.NotDrawn {
+		return reason.String()
+	}
+	return ""
+}
+
 func get_ai_move_wasm(this js.Value, args []js.Value) interface{} {
-	bestMove := handlers.FindBestMove(currentBoard, false)
+	currentPos.WhiteToMove = false
+	bestMove := searcher.FindBestMove(context.Background(), &currentPos)
 
 	if bestMove.FromRow == 0 && bestMove.FromCol == 0 &&
 		bestMove.ToRow == 0 && bestMove.ToCol == 0 {
 		return js.ValueOf(map[string]interface{}{
 			"valid":  false,
-			"newFen": boardToFEN(currentBoard),
+			"newFen": currentPos.FEN(),
 		})
 	}
 
-	move := Move{
-		FromRow: bestMove.FromRow,
-		FromCol: bestMove.FromCol,
-		ToRow:   bestMove.ToRow,
-		ToCol:   bestMove.ToCol,
-	}
-
-	currentBoard = applyMove(currentBoard, move)
+	move := bestMove
+	san := handlers.MoveToSAN(&currentPos, move)
+	currentPos.MakeMove(move)
 
-	isPossibleMove := handlers.FindBestMove(currentBoard, true)
-	isPossible := true
-	if isPossibleMove.FromRow == 0 && isPossibleMove.FromCol == 0 &&
-		isPossibleMove.ToRow == 0 && isPossibleMove.ToCol == 0 {
-		isPossible = false
-	}
+	status := currentPos.Status()
 
 	return js.ValueOf(map[string]interface{}{
-		"gamestatus": isPossible,
+		"gamestatus": status == handlers.Ongoing,
+		"status":     status.String(),
 		"valid":      true,
 		"fromR":      move.FromRow,
 		"fromC":      move.FromCol,
 		"toR":        move.ToRow,
 		"toC":        move.ToCol,
-		"newFen":     boardToFEN(currentBoard),
+		"promotion":  promotionLetter(move),
+		"san":        san,
+		"newFen":     currentPos.FEN(),
 	})
 }
 
@@ -113,8 +159,7 @@ func validate_move_wasm(this js.Value, args []js.Value) interface{} {
 	toCol := args[3].Int()
 
 	valid := handlers.IsValidMove(
-		currentBoard,
-		currentBoard[fromRow][fromCol],
+		&currentPos,
 		fromRow,
 		fromCol,
 		toRow,
@@ -123,11 +168,11 @@ func validate_move_wasm(this js.Value, args []js.Value) interface{} {
 	)
 
 	if valid {
-		move := Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
-		currentBoard = applyMove(currentBoard, move)
+		move := handlers.Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
+		currentPos.MakeMove(move)
 		return js.ValueOf(map[string]interface{}{
 			"valid":      true,
-			"newFen":     boardToFEN(currentBoard),
+			"newFen":     currentPos.FEN(),
 			"gamestatus": true,
 		})
 	}
@@ -137,101 +182,6 @@ func validate_move_wasm(this js.Value, args []js.Value) interface{} {
 	})
 }
 
-func parseFEN(fen string) [8][8]rune {
-	var board [8][8]rune
-
-	rows := strings.Split(fen, "/")
-	for rowIdx, row := range rows {
-		colIdx := 0
-		for _, char := range row {
-			if char >= '1' && char <= '8' {
-				colIdx += int(char - '0')
-			} else {
-				board[rowIdx][colIdx] = char
-				colIdx++
-			}
-		}
-	}
-
-	return board
-}
-
-func applyMove(board [8][8]rune, move Move) [8][8]rune {
-	var newBoard [8][8]rune
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			newBoard[i][j] = board[i][j]
-		}
-	}
-
-	piece := board[move.FromRow][move.FromCol]
-	newBoard[move.FromRow][move.FromCol] = 0
-
-	if piece == 'K' || piece == 'k' {
-		if abs(move.ToCol-move.FromCol) == 2 {
-			if move.ToCol > move.FromCol {
-				newBoard[move.FromRow][5] = newBoard[move.FromRow][7]
-				newBoard[move.FromRow][7] = 0
-			} else {
-				newBoard[move.FromRow][3] = newBoard[move.FromRow][0]
-				newBoard[move.FromRow][0] = 0
-			}
-		}
-	}
-	if (piece == 'P' || piece == 'p') && move.ToCol != move.FromCol {
-		if board[move.ToRow][move.ToCol] == 0 {
-			newBoard[move.FromRow][move.ToCol] = 0
-		}
-	}
-	newBoard[move.ToRow][move.ToCol] = piece
-	if piece == 'P' && move.ToRow == 0 {
-		newBoard[move.ToRow][move.ToCol] = 'Q'
-	} else if piece == 'p' && move.ToRow == 7 {
-		newBoard[move.ToRow][move.ToCol] = 'q'
-	}
-
-	return newBoard
-}
-
-func boardToFEN(board [8][8]rune) string {
-	var fen strings.Builder
-
-	for row := 0; row < 8; row++ {
-		emptyCount := 0
-
-		for col := 0; col < 8; col++ {
-			piece := board[row][col]
-
-			if piece == 0 {
-				emptyCount++
-			} else {
-				if emptyCount > 0 {
-					fen.WriteString(fmt.Sprintf("%d", emptyCount))
-					emptyCount = 0
-				}
-				fen.WriteRune(piece)
-			}
-		}
-
-		if emptyCount > 0 {
-			fen.WriteString(fmt.Sprintf("%d", emptyCount))
-		}
-
-		if row < 7 {
-			fen.WriteString("/")
-		}
-	}
-
-	return fen.String()
-}
-
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
 // squareToCoords converts algebraic square like "e2" -> board coordinates (like engine_cli.go)
 func squareToCoords(s string) (row, col int, ok bool) {
 	if len(s) != 2 {
@@ -248,11 +198,30 @@ func squareToCoords(s string) (row, col int, ok bool) {
 	return row, col, true
 }
 
-// coordsToSquare converts board coordinates -> "e2" (like engine_cli.go)
-func coordsToSquare(row, col int) string {
-	file := 'a' + rune(col)
-	rank := '8' - rune(row)
-	return fmt.Sprintf("%c%c", file, rank)
+// searchContext returns a context that expires after the number of milliseconds in
+// args[i], so a worker answers before script.js gives up on it. A missing or
+// non-positive limit means no deadline.
+func searchContext(args []js.Value, i int) (context.Context, context.CancelFunc) {
+	if len(args) > i && args[i].Type() == js.TypeNumber && args[i].Int() > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(args[i].Int())*time.Millisecond)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// promotionLetter returns the lower-case promotion letter of move ("q", "r", "b", "n"), or "".
+func promotionLetter(move handlers.Move) string {
+	if move.Promotion == 0 {
+		return ""
+	}
+	return strings.ToLower(string(move.Promotion))
+}
+
+// parsePromotion is the inverse of promotionLetter.
+func parsePromotion(letter string) rune {
+	if letter == "" {
+		return 0
+	}
+	return rune(strings.ToUpper(letter)[0])
 }
 
 // isWhite checks if a piece is white (like engine_cli.go)
@@ -260,7 +229,7 @@ func isWhitePiece(piece rune) bool {
 	return piece == 'P' || piece == 'N' || piece == 'B' || piece == 'R' || piece == 'Q' || piece == 'K'
 }
 
-// validate_move_string_wasm accepts move in format "e2e4" (like engine_cli.go)
+// validate_move_string_wasm accepts move in format "e2e4", or "e7e8n" to pick the promotion piece
 func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 	if len(args) < 1 {
 		return js.ValueOf(map[string]interface{}{"valid": false, "error": "missing move string"})
@@ -272,8 +241,8 @@ func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 		isWhiteTurn = args[1].Bool()
 	}
 
-	if len(moveStr) != 4 {
-		return js.ValueOf(map[string]interface{}{"valid": false, "error": "invalid move format, use e2e4"})
+	if len(moveStr) != 4 && len(moveStr) != 5 {
+		return js.ValueOf(map[string]interface{}{"valid": false, "error": "invalid move format, use e2e4 or e7e8q"})
 	}
 
 	fromSq := moveStr[0:2]
@@ -286,7 +255,7 @@ func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 		return js.ValueOf(map[string]interface{}{"valid": false, "error": "invalid squares"})
 	}
 
-	piece := currentBoard[fromRow][fromCol]
+	piece := currentPos.Board[fromRow][fromCol]
 	if piece == 0 {
 		return js.ValueOf(map[string]interface{}{"valid": false, "error": "no piece on that square"})
 	}
@@ -299,18 +268,21 @@ func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 		return js.ValueOf(map[string]interface{}{"valid": false, "error": "not a " + side + " piece"})
 	}
 
+	// Promotions default to a queen unless the fifth letter picks another piece.
 	var promotionPiece *rune
-	if piece == 'P' && toRow == 0 {
+	if (piece == 'P' && toRow == 0) || (piece == 'p' && toRow == 7) {
 		q := 'Q'
+		if len(moveStr) == 5 {
+			q = parsePromotion(moveStr[4:])
+		}
 		promotionPiece = &q
-	} else if piece == 'p' && toRow == 7 {
-		q := 'q'
-		promotionPiece = &q
+	} else if len(moveStr) == 5 {
+		return js.ValueOf(map[string]interface{}{"valid": false, "error": "only a pawn reaching the last rank can promote"})
 	}
 
+	currentPos.WhiteToMove = isWhiteTurn
 	valid := handlers.IsValidMove(
-		currentBoard,
-		piece,
+		&currentPos,
 		fromRow,
 		fromCol,
 		toRow,
@@ -319,12 +291,20 @@ func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 	)
 
 	if valid {
-		move := Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
-		currentBoard = applyMove(currentBoard, move)
+		move := handlers.Move{FromRow: fromRow, FromCol: fromCol, ToRow: toRow, ToCol: toCol}
+		if promotionPiece != nil {
+			move.Promotion = *promotionPiece
+		}
+		san := handlers.MoveToSAN(&currentPos, move)
+		gameHistory = append(gameHistory, handlers.GetZobristValue(&currentPos))
+		currentPos.MakeMove(move)
 		return js.ValueOf(map[string]interface{}{
 			"valid":      true,
-			"newFen":     boardToFEN(currentBoard),
-			"gamestatus": true,
+			"san":        san,
+			"newFen":     currentPos.FEN(),
+			"gamestatus": currentPos.Status() == handlers.Ongoing,
+			"status":     currentPos.Status().String(),
+			"draw":       drawReason(&currentPos, gameHistory),
 		})
 	}
 
@@ -334,80 +314,80 @@ func validate_move_string_wasm(this js.Value, args []js.Value) interface{} {
 	})
 }
 
-// get_ai_move_string_wasm returns AI move in format "e2e4" (like engine_cli.go)
+// get_ai_move_string_wasm returns AI move in format "e2e4" (like engine_cli.go).
+// An optional second argument limits the search to that many milliseconds.
 func get_ai_move_string_wasm(this js.Value, args []js.Value) interface{} {
 	isWhiteTurn := false
 	if len(args) > 0 {
 		isWhiteTurn = args[0].Bool()
 	}
-	bestMove := handlers.FindBestMove(currentBoard, isWhiteTurn)
+	ctx, cancel := searchContext(args, 1)
+	defer cancel()
+	currentPos.WhiteToMove = isWhiteTurn
+	searcher.SetHistory(gameHistory)
+	bestMove := searcher.FindBestMove(ctx, &currentPos)
 
 	if bestMove.FromRow == 0 && bestMove.FromCol == 0 &&
 		bestMove.ToRow == 0 && bestMove.ToCol == 0 {
 		return js.ValueOf(map[string]interface{}{
 			"valid":  false,
-			"newFen": boardToFEN(currentBoard),
+			"newFen": currentPos.FEN(),
 		})
 	}
 
-	move := Move{
-		FromRow: bestMove.FromRow,
-		FromCol: bestMove.FromCol,
-		ToRow:   bestMove.ToRow,
-		ToCol:   bestMove.ToCol,
-	}
-
-	currentBoard = applyMove(currentBoard, move)
+	move := bestMove
+	san := handlers.MoveToSAN(&currentPos, move)
+	gameHistory = append(gameHistory, handlers.GetZobristValue(&currentPos))
+	currentPos.MakeMove(move)
 
-	// Check if the human side has any moves left.
-	isPossibleMove := handlers.FindBestMove(currentBoard, !isWhiteTurn)
-	isPossible := true
-	if isPossibleMove.FromRow == 0 && isPossibleMove.FromCol == 0 &&
-		isPossibleMove.ToRow == 0 && isPossibleMove.ToCol == 0 {
-		isPossible = false
-	}
-
-	// Convert to string format like "e2e4"
-	moveString := coordsToSquare(move.FromRow, move.FromCol) + coordsToSquare(move.ToRow, move.ToCol)
+	// "status" tells whether the human side is mated, stalemated or can play on.
+	status := currentPos.Status()
 
 	return js.ValueOf(map[string]interface{}{
-		"gamestatus": isPossible,
+		"gamestatus": status == handlers.Ongoing,
+		"status":     status.String(),
 		"valid":      true,
-		"move":       moveString,
-		"newFen":     boardToFEN(currentBoard),
+		"move":       move.String(),
+		"san":        san,
+		"newFen":     currentPos.FEN(),
+		"draw":       drawReason(&currentPos, gameHistory),
 	})
 }
 
 // get_all_legal_moves_wasm returns all legal moves as JSON string for the given FEN
 func get_all_legal_moves_wasm(this js.Value, args []js.Value) interface{} {
-	fen := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR"
+	fen := handlers.StartFEN
 	if len(args) > 0 {
 		fen = args[0].String()
 	}
 
-	board := parseFEN(fen)
-	isWhiteTurn := false // For AI move, it's black's turn
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
+	}
 	if len(args) > 1 {
-		isWhiteTurn = args[1].Bool()
+		pos.WhiteToMove = args[1].Bool()
 	}
 
-	allMoves := handlers.GenereateAllMoves(board, isWhiteTurn)
+	allMoves := handlers.GenereateAllMoves(&pos)
 
 	// Convert to JSON-serializable format
 	type MoveJSON struct {
-		FromRow int `json:"fromRow"`
-		FromCol int `json:"fromCol"`
-		ToRow   int `json:"toRow"`
-		ToCol   int `json:"toCol"`
+		FromRow   int    `json:"fromRow"`
+		FromCol   int    `json:"fromCol"`
+		ToRow     int    `json:"toRow"`
+		ToCol     int    `json:"toCol"`
+		Promotion string `json:"promotion,omitempty"`
 	}
 
 	movesJSON := make([]MoveJSON, len(allMoves))
 	for i, move := range allMoves {
 		movesJSON[i] = MoveJSON{
-			FromRow: move.FromRow,
-			FromCol: move.FromCol,
-			ToRow:   move.ToRow,
-			ToCol:   move.ToCol,
+			FromRow:   move.FromRow,
+			FromCol:   move.FromCol,
+			ToRow:     move.ToRow,
+			ToCol:     move.ToCol,
+			Promotion: promotionLetter(move),
 		}
 	}
 
@@ -419,7 +399,8 @@ func get_all_legal_moves_wasm(this js.Value, args []js.Value) interface{} {
 	return js.ValueOf(string(jsonBytes))
 }
 
-// search_subset_wasm searches only the provided moves and returns best move and score
+// search_subset_wasm searches only the provided moves and returns best move and score.
+// An optional fourth argument limits the search to that many milliseconds.
 func search_subset_wasm(this js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
 		return js.ValueOf(map[string]interface{}{"error": "missing arguments"})
@@ -428,18 +409,21 @@ func search_subset_wasm(this js.Value, args []js.Value) interface{} {
 	fen := args[0].String()
 	movesJson := args[1].String()
 
-	board := parseFEN(fen)
-	isWhiteTurn := false
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
+	}
 	if len(args) > 2 {
-		isWhiteTurn = args[2].Bool()
+		pos.WhiteToMove = args[2].Bool()
 	}
 
 	// Parse moves from JSON
 	type MoveJSON struct {
-		FromRow int `json:"fromRow"`
-		FromCol int `json:"fromCol"`
-		ToRow   int `json:"toRow"`
-		ToCol   int `json:"toCol"`
+		FromRow   int    `json:"fromRow"`
+		FromCol   int    `json:"fromCol"`
+		ToRow     int    `json:"toRow"`
+		ToCol     int    `json:"toCol"`
+		Promotion string `json:"promotion,omitempty"`
 	}
 
 	var movesJSON []MoveJSON
@@ -451,30 +435,34 @@ func search_subset_wasm(this js.Value, args []js.Value) interface{} {
 	movesToSearch := make([]handlers.Move, len(movesJSON))
 	for i, m := range movesJSON {
 		movesToSearch[i] = handlers.Move{
-			FromRow: m.FromRow,
-			FromCol: m.FromCol,
-			ToRow:   m.ToRow,
-			ToCol:   m.ToCol,
+			FromRow:   m.FromRow,
+			FromCol:   m.FromCol,
+			ToRow:     m.ToRow,
+			ToCol:     m.ToCol,
+			Promotion: parsePromotion(m.Promotion),
 		}
 	}
 
 	// Search the subset
-	bestMove, bestScore := handlers.SearchSpecificMoves(board, isWhiteTurn, movesToSearch)
-
-	// Convert move to string format
-	moveString := coordsToSquare(bestMove.FromRow, bestMove.FromCol) + coordsToSquare(bestMove.ToRow, bestMove.ToCol)
+	ctx, cancel := searchContext(args, 3)
+	defer cancel()
+	bestMove, bestScore := searcher.SearchSpecificMoves(ctx, &pos, movesToSearch)
 
 	return js.ValueOf(map[string]interface{}{
-		"move":    moveString,
-		"score":   bestScore,
-		"fromRow": bestMove.FromRow,
-		"fromCol": bestMove.FromCol,
-		"toRow":   bestMove.ToRow,
-		"toCol":   bestMove.ToCol,
+		"move":      bestMove.String(),
+		"score":     bestScore,
+		"fromRow":   bestMove.FromRow,
+		"fromCol":   bestMove.FromCol,
+		"toRow":     bestMove.ToRow,
+		"toCol":     bestMove.ToCol,
+		"promotion": promotionLetter(bestMove),
 	})
 }
 
-// apply_move_wasm applies a move to the board and returns the new FEN
+// apply_move_wasm applies a move to the board and returns the new FEN, the move in SAN
+// and the status of the side to move ("ongoing", "checkmate" or "stalemate").
+// An optional third argument lists the earlier FENs of the game, as for
+// init_board_wasm, so that "draw" can report a repetition.
 func apply_move_wasm(this js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
 		return js.ValueOf(map[string]interface{}{"error": "missing arguments"})
@@ -483,14 +471,18 @@ func apply_move_wasm(this js.Value, args []js.Value) interface{} {
 	fen := args[0].String()
 	moveJson := args[1].String()
 
-	board := parseFEN(fen)
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
+	}
 
 	// Parse move from JSON
 	type MoveJSON struct {
-		FromRow int `json:"fromRow"`
-		FromCol int `json:"fromCol"`
-		ToRow   int `json:"toRow"`
-		ToCol   int `json:"toCol"`
+		FromRow   int    `json:"fromRow"`
+		FromCol   int    `json:"fromCol"`
+		ToRow     int    `json:"toRow"`
+		ToCol     int    `json:"toCol"`
+		Promotion string `json:"promotion,omitempty"`
 	}
 
 	var moveJSON MoveJSON
@@ -498,18 +490,82 @@ func apply_move_wasm(this js.Value, args []js.Value) interface{} {
 		return js.ValueOf(map[string]interface{}{"error": "invalid move JSON: " + err.Error()})
 	}
 
-	move := Move{
-		FromRow: moveJSON.FromRow,
-		FromCol: moveJSON.FromCol,
-		ToRow:   moveJSON.ToRow,
-		ToCol:   moveJSON.ToCol,
+	move := handlers.Move{
+		FromRow:   moveJSON.FromRow,
+		FromCol:   moveJSON.FromCol,
+		ToRow:     moveJSON.ToRow,
+		ToCol:     moveJSON.ToCol,
+		Promotion: parsePromotion(moveJSON.Promotion),
+	}
+
+	history, err := parseHistory(args, 2)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"error": err.Error()})
 	}
 
 	// Apply move using the proper Go function
-	newBoard := applyMove(board, move)
-	newFen := boardToFEN(newBoard)
+	san := handlers.MoveToSAN(&pos, move)
+	history = append(history, handlers.GetZobristValue(&pos))
+	pos.MakeMove(move)
+	newFen := pos.FEN()
 
 	return js.ValueOf(map[string]interface{}{
 		"newFen": newFen,
+		"san":    san,
+		"status": pos.Status().String(),
+		"draw":   drawReason(&pos, history),
+	})
+}
+
+// load_book_wasm takes the contents of a Polyglot .bin file as a Uint8Array and
+// makes the searcher play from it. An empty array unloads the book.
+func load_book_wasm(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return js.ValueOf(map[string]interface{}{"loaded": false, "error": "missing arguments"})
+	}
+
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+	if len(data) == 0 {
+		searcher.SetBook(nil)
+		return js.ValueOf(map[string]interface{}{"loaded": false, "entries": 0})
+	}
+
+	book, err := handlers.ParseBook(data)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"loaded": false, "error": err.Error()})
+	}
+	searcher.SetBook(book)
+	return js.ValueOf(map[string]interface{}{"loaded": true, "entries": book.Len()})
+}
+
+// book_move_wasm picks a book move for the given FEN. found is false when no
+// book is loaded or it has no move for the position.
+func book_move_wasm(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return js.ValueOf(map[string]interface{}{"found": false, "error": "missing arguments"})
+	}
+
+	pos, err := handlers.ParseFEN(args[0].String())
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"found": false, "error": err.Error()})
+	}
+	book := searcher.Book()
+	if book == nil {
+		return js.ValueOf(map[string]interface{}{"found": false})
+	}
+	move, ok := book.PickMove(&pos)
+	if !ok {
+		return js.ValueOf(map[string]interface{}{"found": false})
+	}
+
+	return js.ValueOf(map[string]interface{}{
+		"found":     true,
+		"move":      move.String(),
+		"fromRow":   move.FromRow,
+		"fromCol":   move.FromCol,
+		"toRow":     move.ToRow,
+		"toCol":     move.ToCol,
+		"promotion": promotionLetter(move),
 	})
 }
@@ -0,0 +1,378 @@
+//go:build !js && !gui
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chess-engine/handlers"
+)
+
+// uciEngine is one UCI session: the current position plus the search running on it.
+type uciEngine struct {
+	out   io.Writer
+	outMu sync.Mutex // the search goroutine and the command loop both write to out
+
+	pos      handlers.Position
+	history  []uint64 // hashes of the positions before pos, for repetitions
+	searcher *handlers.Searcher
+	book     *handlers.Book // loaded from BookFile or passed in with searcher
+	ownBook  bool           // the OwnBook option: play from book when one is loaded
+
+	cancel   context.CancelFunc // stops the running search; nil when none is running
+	done     chan struct{}      // closed when the running search has printed bestmove
+	infinite bool               // the running search only ends on "stop"
+}
+
+// runUCI speaks the Universal Chess Interface on in/out until "quit" or EOF,
+// searching with searcher. If the caller has already read the initial "uci"
+// command, pass greeted=true so the identification is sent straight away.
+func runUCI(in io.Reader, out io.Writer, searcher *handlers.Searcher, greeted bool) {
+	pos, _ := handlers.ParseFEN(handlers.StartFEN)
+	e := &uciEngine{out: out, pos: pos, searcher: searcher, book: searcher.Book(), ownBook: searcher.Book() != nil}
+	if greeted {
+		e.handle("uci")
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if !e.handle(scanner.Text()) {
+			return
+		}
+	}
+	e.waitSearch()
+}
+
+func (e *uciEngine) send(format string, args ...interface{}) {
+	e.outMu.Lock()
+	defer e.outMu.Unlock()
+	fmt.Fprintf(e.out, format+"\n", args...)
+}
+
+// handle executes one command line and reports whether the session continues.
+func (e *uciEngine) handle(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+
+	switch fields[0] {
+	case "uci":
+		e.send("id name Go Chess Engine")
+		e.send("id author chess-engine contributors")
+		e.send("option name Hash type spin default %d min 1 max %d", handlers.DefaultHashMB, handlers.MaxHashMB)
+		e.send("option name Threads type spin default %d min 1 max %d", e.searcher.Threads(), handlers.MaxThreads)
+		e.send("option name OwnBook type check default %t", e.ownBook)
+		e.send("option name BookFile type string default <empty>")
+		e.send("option name SyzygyPath type string default <empty>")
+		e.send("option name NullMoveVerification type check default false")
+		e.send("uciok")
+	case "isready":
+		e.send("readyok")
+	case "ucinewgame":
+		e.waitSearch()
+		e.pos, _ = handlers.ParseFEN(handlers.StartFEN)
+		e.history = nil
+		e.searcher.NewGame()
+	case "position":
+		e.waitSearch()
+		if err := e.setPosition(fields[1:]); err != nil {
+			e.send("info string %v", err)
+		}
+	case "go":
+		e.waitSearch()
+		e.startSearch(fields[1:])
+	case "stop":
+		e.stopSearch()
+	case "setoption":
+		e.waitSearch()
+		e.setOption(fields[1:])
+	case "quit":
+		e.stopSearch()
+		return false
+	default:
+		e.send("info string unknown command %s", fields[0])
+	}
+	return true
+}
+
+// setPosition handles "position startpos|fen <FEN> [moves <move>...]".
+func (e *uciEngine) setPosition(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("position: missing startpos or fen")
+	}
+
+	movesAt := len(args)
+	for i, arg := range args {
+		if arg == "moves" {
+			movesAt = i
+			break
+		}
+	}
+
+	var fen string
+	switch args[0] {
+	case "startpos":
+		fen = handlers.StartFEN
+	case "fen":
+		fen = strings.Join(args[1:movesAt], " ")
+	default:
+		return fmt.Errorf("position: expected startpos or fen, got %q", args[0])
+	}
+	pos, err := handlers.ParseFEN(fen)
+	if err != nil {
+		return err
+	}
+
+	var history []uint64
+	if movesAt < len(args) {
+		for _, text := range args[movesAt+1:] {
+			move, ok := handlers.ParseMove(text)
+			var promotion *rune
+			if move.Promotion != 0 {
+				promotion = &move.Promotion
+			}
+			if !ok || !handlers.IsValidMove(&pos, move.FromRow, move.FromCol, move.ToRow, move.ToCol, promotion) {
+				e.pos, e.history = pos, history
+				return fmt.Errorf("position: illegal move %s", text)
+			}
+			history = append(history, handlers.GetZobristValue(&pos))
+			pos.MakeMove(move)
+		}
+	}
+	e.pos, e.history = pos, history
+	return nil
+}
+
+// uciDefaultDepth bounds a "go" that neither says infinite nor sends a limit for
+// the side to move, such as "go movestogo 20" or "go wtime 1000" with Black to
+// move; it matches the depth FindBestMove searches to.
+const uciDefaultDepth = 3
+
+// parseGoParams turns the arguments of "go" into search limits for the side to
+// move. infinite reports whether the search may only end on "stop", which is the
+// case only for "go infinite".
+func parseGoParams(args []string, whiteToMove bool) (limits handlers.SearchLimits, infinite bool) {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "infinite" {
+			infinite = true
+			continue
+		}
+		if i+1 >= len(args) {
+			break
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			continue
+		}
+		ms := time.Duration(n) * time.Millisecond
+		switch args[i] {
+		case "depth":
+			limits.MaxDepth = n
+		case "nodes":
+			limits.Nodes = int64(n)
+		case "movetime":
+			limits.MoveTime = ms
+		case "wtime":
+			if whiteToMove {
+				limits.TimeLeft = ms
+			}
+		case "btime":
+			if !whiteToMove {
+				limits.TimeLeft = ms
+			}
+		case "winc":
+			if whiteToMove {
+				limits.Increment = ms
+			}
+		case "binc":
+			if !whiteToMove {
+				limits.Increment = ms
+			}
+		case "movestogo":
+			limits.MovesToGo = n
+		default:
+			continue
+		}
+		i++
+	}
+	if infinite {
+		// "go infinite" ignores any other limit it is sent with.
+		return handlers.SearchLimits{}, true
+	}
+	if limits.MaxDepth == 0 && limits.MoveTime == 0 && limits.TimeLeft == 0 && limits.Nodes == 0 {
+		limits.MaxDepth = uciDefaultDepth
+	}
+	return limits, false
+}
+
+// startSearch handles "go": it searches a copy of the current position on its own
+// goroutine so that "stop" and "isready" are still answered while it runs.
+func (e *uciEngine) startSearch(args []string) {
+	pos := e.pos
+	limits, waitForStop := parseGoParams(args, pos.WhiteToMove)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.cancel, e.done, e.infinite = cancel, done, waitForStop
+	e.searcher.SetHistory(e.history)
+
+	go func() {
+		defer close(done)
+
+		best := e.searcher.FindBestMoveWithLimits(ctx, &pos, limits, func(info handlers.SearchInfo) {
+			e.sendInfo(pos.WhiteToMove, info)
+		})
+		// UCI forbids bestmove before "stop" during an infinite search.
+		if waitForStop {
+			<-ctx.Done()
+		}
+
+		if best == (handlers.Move{}) {
+			e.send("bestmove 0000")
+			return
+		}
+		e.send("bestmove %s", best)
+	}()
+}
+
+func (e *uciEngine) sendInfo(whiteToMove bool, info handlers.SearchInfo) {
+	// UCI scores are from the side to move; ours are from White's.
+	score := info.Score
+	if !whiteToMove {
+		score = -score
+	}
+	nps := int64(0)
+	if ms := info.Time.Milliseconds(); ms > 0 {
+		nps = info.Nodes * 1000 / ms
+	}
+	pv := make([]string, len(info.PV))
+	for i, move := range info.PV {
+		pv[i] = move.String()
+	}
+	e.send("info depth %d score %s nodes %d nps %d time %d pv %s",
+		info.Depth, uciScore(score), info.Nodes, nps, info.Time.Milliseconds(), strings.Join(pv, " "))
+}
+
+// uciScore formats a score from the side to move's point of view as "cp <n>", or
+// as "mate <moves>" with negative moves when the side to move is getting mated.
+func uciScore(score int) string {
+	plies, ok := handlers.MatePlies(score)
+	if !ok {
+		return fmt.Sprintf("cp %d", score)
+	}
+	if score < 0 {
+		return fmt.Sprintf("mate %d", -(plies+1)/2)
+	}
+	return fmt.Sprintf("mate %d", (plies+1)/2)
+}
+
+// waitSearch lets a running depth- or time-limited search finish; a search that
+// would only end on "stop" is stopped instead.
+func (e *uciEngine) waitSearch() {
+	if e.done == nil {
+		return
+	}
+	if e.infinite {
+		e.stopSearch()
+		return
+	}
+	<-e.done
+	e.cancel()
+	e.cancel, e.done = nil, nil
+}
+
+// stopSearch ends the running search, if any, and waits for its bestmove.
+func (e *uciEngine) stopSearch() {
+	if e.done == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+	e.cancel, e.done = nil, nil
+}
+
+// setOption handles "setoption name <id> [value <x>]".
+func (e *uciEngine) setOption(args []string) {
+	name, value := parseOption(args)
+	switch strings.ToLower(name) {
+	case "hash":
+		mb, err := strconv.Atoi(value)
+		if err != nil {
+			e.send("info string invalid Hash value %q", value)
+			return
+		}
+		e.searcher.SetHashSize(mb)
+	case "threads":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.send("info string invalid Threads value %q", value)
+			return
+		}
+		e.searcher.SetThreads(n)
+	case "ownbook":
+		e.ownBook = strings.EqualFold(value, "true")
+		e.applyBook()
+	case "bookfile":
+		e.book = nil
+		if value != "" && value != "<empty>" {
+			book, err := handlers.LoadBook(value)
+			if err != nil {
+				e.send("info string cannot load book: %v", err)
+			} else {
+				e.book = book
+			}
+		}
+		e.applyBook()
+	case "syzygypath":
+		if value == "" || value == "<empty>" {
+			e.searcher.SetTablebase(nil)
+			return
+		}
+		tb, err := handlers.OpenTablebase(value)
+		if err != nil {
+			e.send("info string cannot open tablebases: %v", err)
+			return
+		}
+		e.searcher.SetTablebase(tb)
+		e.send("info string found %d tablebases with up to %d pieces", tb.Len(), tb.MaxPieces())
+	case "nullmoveverification":
+		e.searcher.SetNullMoveVerification(strings.EqualFold(value, "true"))
+	default:
+		e.send("info string unknown option %s", name)
+	}
+}
+
+// applyBook hands the loaded book to the searcher when OwnBook is on.
+func (e *uciEngine) applyBook() {
+	if e.ownBook {
+		e.searcher.SetBook(e.book)
+	} else {
+		e.searcher.SetBook(nil)
+	}
+}
+
+// parseOption splits the arguments of setoption into the option name and value,
+// both of which may contain spaces.
+func parseOption(args []string) (name, value string) {
+	var nameParts, valueParts []string
+	target := &nameParts
+	for _, arg := range args {
+		switch arg {
+		case "name":
+			target = &nameParts
+		case "value":
+			target = &valueParts
+		default:
+			*target = append(*target, arg)
+		}
+	}
+	return strings.Join(nameParts, " "), strings.Join(valueParts, " ")
+}
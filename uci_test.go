@@ -0,0 +1,156 @@
+//go:build !js && !gui
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-engine/handlers"
+)
+
+// session drives a protocol loop such as runUCI or runXBoard through pipes, the
+// way a GUI talks to the engine.
+type session struct {
+	t     *testing.T
+	in    *io.PipeWriter
+	lines chan string
+}
+
+func startSession(t *testing.T, run func(in io.Reader, out io.Writer)) *session {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &session{t: t, in: inW, lines: make(chan string, 4096)}
+	go func() {
+		run(inR, outW)
+		outW.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			s.lines <- scanner.Text()
+		}
+		close(s.lines)
+	}()
+	return s
+}
+
+func (s *session) send(line string) {
+	s.t.Helper()
+	if _, err := fmt.Fprintln(s.in, line); err != nil {
+		s.t.Fatalf("sending %q: %v", line, err)
+	}
+}
+
+// expect reads output until a line starting with prefix and returns all the
+// lines read, that one last.
+func (s *session) expect(prefix string) []string {
+	s.t.Helper()
+	var read []string
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("output ended without %q; got %q", prefix, read)
+			}
+			read = append(read, line)
+			if strings.HasPrefix(line, prefix) {
+				return read
+			}
+		case <-timeout:
+			s.t.Fatalf("no %q within 30s; got %q", prefix, read)
+		}
+	}
+}
+
+// close ends the input and waits for the loop to return.
+func (s *session) close() {
+	s.t.Helper()
+	s.in.Close()
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case _, ok := <-s.lines:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			s.t.Fatal("the session did not end after its input was closed")
+		}
+	}
+}
+
+func TestParseGoParams(t *testing.T) {
+	tests := []struct {
+		args         string
+		whiteToMove  bool
+		wantLimits   handlers.SearchLimits
+		wantInfinite bool
+	}{
+		{"depth 5", true, handlers.SearchLimits{MaxDepth: 5}, false},
+		{"nodes 5000", true, handlers.SearchLimits{Nodes: 5000}, false},
+		{"movetime 250", false, handlers.SearchLimits{MoveTime: 250 * time.Millisecond}, false},
+		{"wtime 60000 btime 50000 winc 1000 binc 500 movestogo 20", true,
+			handlers.SearchLimits{TimeLeft: 60 * time.Second, Increment: time.Second, MovesToGo: 20}, false},
+		{"wtime 60000 btime 50000 winc 1000 binc 500 movestogo 20", false,
+			handlers.SearchLimits{TimeLeft: 50 * time.Second, Increment: 500 * time.Millisecond, MovesToGo: 20}, false},
+		// Nothing bounds the side to move: the search falls back to a depth.
+		{"wtime 1000", false, handlers.SearchLimits{MaxDepth: uciDefaultDepth}, false},
+		{"movestogo 20", true, handlers.SearchLimits{MovesToGo: 20, MaxDepth: uciDefaultDepth}, false},
+		{"", true, handlers.SearchLimits{MaxDepth: uciDefaultDepth}, false},
+		{"infinite", true, handlers.SearchLimits{}, true},
+		{"depth 4 infinite", true, handlers.SearchLimits{}, true},
+	}
+	for _, tc := range tests {
+		limits, infinite := parseGoParams(strings.Fields(tc.args), tc.whiteToMove)
+		if !reflect.DeepEqual(limits, tc.wantLimits) || infinite != tc.wantInfinite {
+			t.Errorf("parseGoParams(%q, white=%v) = %+v, %v; want %+v, %v",
+				tc.args, tc.whiteToMove, limits, infinite, tc.wantLimits, tc.wantInfinite)
+		}
+	}
+}
+
+func TestUCISession(t *testing.T) {
+	s := startSession(t, func(in io.Reader, out io.Writer) {
+		runUCI(in, out, handlers.NewSearcher(), false)
+	})
+	defer s.close()
+
+	s.send("uci")
+	s.expect("uciok")
+	s.send("isready")
+	s.expect("readyok")
+
+	s.send("position startpos moves e2e4q")
+	s.expect("info string position: illegal move e2e4q")
+
+	// Black to move with only White's clock sent must still come back.
+	s.send("position startpos moves e2e4")
+	s.send("go wtime 1000")
+	read := s.expect("bestmove")
+	text := strings.Fields(read[len(read)-1])[1]
+	pos, _ := handlers.ParseFEN(handlers.StartFEN)
+	e4, _ := handlers.ParseMove("e2e4")
+	pos.MakeMove(e4)
+	move, ok := handlers.ParseMove(text)
+	if !ok || !handlers.IsValidMove(&pos, move.FromRow, move.FromCol, move.ToRow, move.ToCol, nil) {
+		t.Errorf("bestmove %s is not legal after 1. e4", text)
+	}
+
+	// An infinite search answers isready but only moves after stop.
+	s.send("go infinite")
+	s.send("isready")
+	for _, line := range s.expect("readyok") {
+		if strings.HasPrefix(line, "bestmove") {
+			t.Errorf("infinite search sent %q before stop", line)
+		}
+	}
+	s.send("stop")
+	s.expect("bestmove")
+}
@@ -0,0 +1,367 @@
+//go:build !js && !gui
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chess-engine/handlers"
+)
+
+// xboardDefaultDepth is used when neither a clock nor "st" limits the search; it
+// matches the depth FindBestMove searches to.
+const xboardDefaultDepth = 3
+
+// xboardEngine is one XBoard/CECP session.
+type xboardEngine struct {
+	out   io.Writer
+	outMu sync.Mutex // the search goroutine and the command loop both write to out
+
+	pos      handlers.Position
+	history  []handlers.Position // positions before each move, for undo/remove
+	searcher *handlers.Searcher
+
+	force       bool // engine plays neither side
+	engineWhite bool // side the engine plays when not in force mode
+	post        bool
+
+	// Time control from "level", "st" and "sd".
+	movesPerSession int
+	inc             time.Duration
+	moveTime        time.Duration
+	maxDepth        int
+	engineTime      time.Duration // from "time"
+	engineMoves     int           // moves the engine has made since "new"
+
+	cancel  context.CancelFunc // stops the running search; nil when idle
+	done    chan struct{}      // closed when the running search has finished
+	aborted atomic.Bool        // the running search must finish without moving
+}
+
+// runXBoard speaks the XBoard/WinBoard protocol (CECP) on in/out until "quit" or
+// EOF, searching with searcher.
+func runXBoard(in io.Reader, out io.Writer, searcher *handlers.Searcher) {
+	e := &xboardEngine{out: out, searcher: searcher}
+	e.newGame()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if !e.handle(scanner.Text()) {
+			return
+		}
+	}
+	e.waitSearch()
+}
+
+func (e *xboardEngine) send(format string, args ...interface{}) {
+	e.outMu.Lock()
+	defer e.outMu.Unlock()
+	fmt.Fprintf(e.out, format+"\n", args...)
+}
+
+func (e *xboardEngine) newGame() {
+	e.pos, _ = handlers.ParseFEN(handlers.StartFEN)
+	e.history = nil
+	e.searcher.NewGame()
+	e.force = false
+	e.engineWhite = false
+	e.maxDepth = 0
+	e.engineMoves = 0
+}
+
+// handle executes one command line and reports whether the session continues.
+func (e *xboardEngine) handle(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	cmd, args := fields[0], fields[1:]
+
+	// "?" makes the engine move now and the commands that change the game abort
+	// its thinking without a move; all others wait for the move.
+	switch cmd {
+	case "?":
+		e.stopSearch(false)
+	case "new", "force", "result", "setboard", "undo", "remove", "quit":
+		e.stopSearch(true)
+	default:
+		e.waitSearch()
+	}
+
+	switch cmd {
+	case "xboard", "accepted", "rejected", "random", "hard", "easy", "computer", "otim", "name", "rating", "ics":
+		// Nothing to do.
+	case "protover":
+		e.send(`feature myname="Go Chess Engine" usermove=1 setboard=1 ping=1 colors=0 memory=1 smp=1 egt="syzygy" sigint=0 sigterm=0 done=1`)
+	case "ping":
+		if len(args) > 0 {
+			e.send("pong %s", args[0])
+		}
+	case "new":
+		e.newGame()
+	case "force":
+		e.force = true
+	case "go":
+		e.force = false
+		e.engineWhite = e.pos.WhiteToMove
+		e.think()
+	case "playother":
+		e.force = false
+		e.engineWhite = !e.pos.WhiteToMove
+	case "usermove":
+		if len(args) > 0 {
+			e.userMove(args[0])
+		}
+	case "setboard":
+		pos, err := handlers.ParseFEN(strings.Join(args, " "))
+		if err != nil {
+			e.send("tellusererror Illegal position: %v", err)
+			return true
+		}
+		e.pos = pos
+		e.history = nil
+	case "level":
+		e.setLevel(args)
+	case "st":
+		if len(args) > 0 {
+			if seconds, err := strconv.ParseFloat(args[0], 64); err == nil {
+				e.moveTime = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	case "sd":
+		if len(args) > 0 {
+			e.maxDepth, _ = strconv.Atoi(args[0])
+		}
+	case "time":
+		if len(args) > 0 {
+			if cs, err := strconv.Atoi(args[0]); err == nil {
+				e.engineTime = time.Duration(cs) * 10 * time.Millisecond
+			}
+		}
+	case "memory":
+		if len(args) > 0 {
+			if mb, err := strconv.Atoi(args[0]); err == nil {
+				e.searcher.SetHashSize(mb)
+			}
+		}
+	case "cores":
+		if len(args) > 0 {
+			if n, err := strconv.Atoi(args[0]); err == nil {
+				e.searcher.SetThreads(n)
+			}
+		}
+	case "egtpath":
+		if len(args) > 1 && args[0] == "syzygy" {
+			tb, err := handlers.OpenTablebase(strings.Join(args[1:], " "))
+			if err != nil {
+				e.send("telluser cannot open tablebases: %v", err)
+				return true
+			}
+			e.searcher.SetTablebase(tb)
+		}
+	case "post":
+		e.post = true
+	case "nopost":
+		e.post = false
+	case "undo":
+		e.takeBack(1)
+	case "remove":
+		e.takeBack(2)
+	case "result":
+		e.force = true
+	case "?":
+		// The search has already been stopped and has played its move.
+	case "quit":
+		return false
+	default:
+		// Protocol version 1 interfaces send bare moves instead of "usermove".
+		if _, ok := handlers.ParseMove(cmd); ok {
+			e.userMove(cmd)
+			return true
+		}
+		e.send("Error (unknown command): %s", cmd)
+	}
+	return true
+}
+
+// setLevel handles "level MPS BASE INC", where BASE is minutes or minutes:seconds.
+func (e *xboardEngine) setLevel(args []string) {
+	if len(args) < 3 {
+		e.send("Error (bad level): %s", strings.Join(args, " "))
+		return
+	}
+	mps, _ := strconv.Atoi(args[0])
+	var base time.Duration
+	if minutes, seconds, ok := strings.Cut(args[1], ":"); ok {
+		m, _ := strconv.Atoi(minutes)
+		s, _ := strconv.Atoi(seconds)
+		base = time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	} else {
+		m, _ := strconv.ParseFloat(args[1], 64)
+		base = time.Duration(m * float64(time.Minute))
+	}
+	inc, _ := strconv.ParseFloat(args[2], 64)
+
+	e.movesPerSession = mps
+	e.inc = time.Duration(inc * float64(time.Second))
+	e.engineTime = base
+	e.moveTime = 0
+}
+
+func (e *xboardEngine) userMove(text string) {
+	move, ok := handlers.ParseMove(text)
+	var promotion *rune
+	if move.Promotion != 0 {
+		promotion = &move.Promotion
+	}
+	if !ok || !handlers.IsValidMove(&e.pos, move.FromRow, move.FromCol, move.ToRow, move.ToCol, promotion) {
+		e.send("Illegal move: %s", text)
+		return
+	}
+	e.play(move)
+	if !e.force && e.pos.WhiteToMove == e.engineWhite {
+		e.think()
+	}
+}
+
+func (e *xboardEngine) play(move handlers.Move) {
+	e.history = append(e.history, e.pos)
+	e.pos.MakeMove(move)
+}
+
+// hashes returns the hashes of the positions before the current one.
+func (e *xboardEngine) hashes() []uint64 {
+	hashes := make([]uint64, len(e.history))
+	for i := range e.history {
+		hashes[i] = handlers.GetZobristValue(&e.history[i])
+	}
+	return hashes
+}
+
+func (e *xboardEngine) takeBack(plies int) {
+	for i := 0; i < plies && len(e.history) > 0; i++ {
+		e.pos = e.history[len(e.history)-1]
+		e.history = e.history[:len(e.history)-1]
+	}
+}
+
+// limits returns the search limits for the engine's next move.
+func (e *xboardEngine) limits() handlers.SearchLimits {
+	limits := handlers.SearchLimits{
+		MaxDepth:  e.maxDepth,
+		MoveTime:  e.moveTime,
+		TimeLeft:  e.engineTime,
+		Increment: e.inc,
+	}
+	if e.moveTime > 0 {
+		limits.TimeLeft = 0
+	}
+	if e.movesPerSession > 0 {
+		limits.MovesToGo = e.movesPerSession - e.engineMoves%e.movesPerSession
+	}
+	if limits.MaxDepth == 0 && limits.MoveTime == 0 && limits.TimeLeft == 0 {
+		limits.MaxDepth = xboardDefaultDepth
+	}
+	return limits
+}
+
+// think searches for the engine's move on its own goroutine, then plays and sends it.
+func (e *xboardEngine) think() {
+	if result := gameResult(&e.pos, e.hashes()); result != "" {
+		e.send("%s", result)
+		return
+	}
+
+	pos := e.pos
+	e.searcher.SetHistory(e.hashes())
+	limits := e.limits()
+	post := e.post
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.cancel, e.done = cancel, done
+	e.aborted.Store(false)
+
+	go func() {
+		defer close(done)
+
+		best := e.searcher.FindBestMoveWithLimits(ctx, &pos, limits, func(info handlers.SearchInfo) {
+			if post {
+				e.sendThinking(pos.WhiteToMove, info)
+			}
+		})
+		if best == (handlers.Move{}) || e.aborted.Load() {
+			return
+		}
+
+		e.play(best)
+		e.engineMoves++
+		e.send("move %s", best)
+		if result := gameResult(&e.pos, e.hashes()); result != "" {
+			e.send("%s", result)
+		}
+	}()
+}
+
+// sendThinking prints one line of CECP thinking output: ply score time(cs) nodes pv.
+func (e *xboardEngine) sendThinking(whiteToMove bool, info handlers.SearchInfo) {
+	score := info.Score
+	if !whiteToMove {
+		score = -score
+	}
+	pv := make([]string, len(info.PV))
+	for i, move := range info.PV {
+		pv[i] = move.String()
+	}
+	e.send("%d %d %d %d %s", info.Depth, score, info.Time.Milliseconds()/10, info.Nodes, strings.Join(pv, " "))
+}
+
+// waitSearch waits for the engine's search to finish if it is thinking.
+func (e *xboardEngine) waitSearch() {
+	if e.done == nil {
+		return
+	}
+	<-e.done
+	e.cancel()
+	e.cancel, e.done = nil, nil
+}
+
+// stopSearch stops the engine's search if it is thinking. The engine plays the
+// best move found so far unless abort is set.
+func (e *xboardEngine) stopSearch(abort bool) {
+	if e.done == nil {
+		return
+	}
+	if abort {
+		e.aborted.Store(true)
+	}
+	e.cancel()
+	e.waitSearch()
+}
+
+// gameResult returns the CECP result line when the game has ended by mate,
+// stalemate or a draw, or "" while it goes on. history holds the hashes of the
+// earlier positions.
+func gameResult(pos *handlers.Position, history []uint64) string {
+	switch pos.Status() {
+	case handlers.Checkmate:
+		if pos.WhiteToMove {
+			return "0-1 {Black mates}"
+		}
+		return "1-0 {White mates}"
+	case handlers.Stalemate:
+		return "1/2-1/2 {Stalemate}"
+	}
+	if reason := handlers.CheckDraw(pos, history); reason != handlers.NotDrawn {
+		return "1/2-1/2 {Draw by " + reason.String() + "}"
+	}
+	return ""
+}
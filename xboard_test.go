@@ -0,0 +1,48 @@
+//go:build !js && !gui
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"chess-engine/handlers"
+)
+
+// TestXBoardStopSearch checks that "?" makes the thinking engine move while
+// "force" stops it without a move.
+func TestXBoardStopSearch(t *testing.T) {
+	s := startSession(t, func(in io.Reader, out io.Writer) {
+		runXBoard(in, out, handlers.NewSearcher())
+	})
+	defer s.close()
+
+	s.send("xboard")
+	s.send("protover 2")
+	s.expect("feature")
+
+	// A 30 second search the engine must not finish.
+	s.send("new")
+	s.send("st 30")
+	s.send("go")
+	s.send("force")
+	s.send("ping 1")
+	for _, line := range s.expect("pong 1") {
+		if strings.HasPrefix(line, "move") {
+			t.Errorf("the engine sent %q after force", line)
+		}
+	}
+	// White is still to move.
+	s.send("usermove e2e4")
+	s.send("ping 2")
+	for _, line := range s.expect("pong 2") {
+		if strings.HasPrefix(line, "Illegal move") {
+			t.Errorf("e2e4 rejected after force: %q", line)
+		}
+	}
+
+	s.send("go")
+	s.send("?")
+	s.expect("move")
+}